@@ -1,11 +1,22 @@
 package cmd
 
 import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/muesli/reflow/indent"
 	"github.com/muesli/reflow/wordwrap"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/download"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/replay"
+	"github.com/ws396/autobinance/internal/signals"
+	"github.com/ws396/autobinance/internal/strategies"
 	"github.com/ws396/autobinance/internal/trader"
 	"github.com/ws396/autobinance/internal/util"
 )
@@ -25,6 +36,17 @@ type CLI struct {
 	help      string
 	quitting  bool
 	T         *trader.Trader
+	// Kept for the analyses that work on top of a backtest
+	lastBacktest *backtest.Result
+	lastRunID    uint
+	// Two backtest runs laid out side by side
+	runDiff string
+	// Set while a replay session is running
+	replay *replay.Replay
+	// Set while testdata is being downloaded
+	cancelDownload   context.CancelFunc
+	downloadProgress download.Progress
+	download         *downloadState
 }
 
 func InitialModel() (*CLI, error) {
@@ -37,15 +59,63 @@ func InitialModel() (*CLI, error) {
 		return nil, err
 	}
 
-	return &CLI{
+	cli := &CLI{
 		node:      root,
 		textInput: ti,
 		help:      "\\b - back to root, \\q - quit CLI",
 		T:         t,
-	}, nil
+	}
+	cli.startSignalSources()
+
+	return cli, nil
+}
+
+// External signal sources are optional and configured through .env
+func (cli *CLI) startSignalSources() {
+	ctx := context.Background()
+	errChans := []chan error{}
+
+	if s := os.Getenv("SIGNALS_MAX_SIZE"); s != "" {
+		size, err := strconv.ParseFloat(s, 64)
+		if err != nil || size < 0 {
+			cli.HandleError(globals.ErrWrongSignalMaxSize)
+		} else {
+			signals.MaxSize = size
+		}
+	}
+
+	if addr := os.Getenv("SIGNALS_WEBHOOK_ADDR"); addr != "" {
+		errChan := make(chan error)
+		go func() {
+			errChan <- signals.ListenWebhook(addr, os.Getenv("SIGNALS_WEBHOOK_TOKEN"), strategies.Signals)
+			close(errChan)
+		}()
+		errChans = append(errChans, errChan)
+	}
+	if path := os.Getenv("SIGNALS_FILE"); path != "" {
+		errChans = append(errChans, signals.WatchFile(ctx, path, strategies.Signals, time.Second))
+	}
+	if path := os.Getenv("SIGNALS_PIPE"); path != "" {
+		errChans = append(errChans, signals.ReadPipe(ctx, path, strategies.Signals))
+	}
+
+	for _, errChan := range errChans {
+		go func(errChan chan error) {
+			for err := range errChan {
+				if err != nil {
+					cli.HandleError(err)
+				}
+			}
+		}(errChan)
+	}
 }
 
 func (cli CLI) Init() tea.Cmd {
+	// Picks up a download started from an earlier session
+	if cli.download != nil {
+		return tea.Batch(textinput.Blink, watchDownload())
+	}
+
 	return textinput.Blink
 }
 
@@ -62,15 +132,21 @@ func (cli *CLI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case tea.KeyCtrlC:
 			return cli.QuitApp()
 		case tea.KeyEnter:
+			downloading := cli.download != nil
 			newMsg := cli.Logic()
 			newCmd := func() tea.Msg {
 				return newMsg
 			}
 			cli.textInput.Reset()
+			if !downloading && cli.download != nil {
+				return cli, tea.Batch(newCmd, watchDownload())
+			}
 			return cli, newCmd
 		}
 	case tea.WindowSizeMsg:
 		cli.width = msg.Width
+	case downloadTickMsg:
+		return cli, cli.pollDownload()
 	}
 
 	cli.textInput, cmd = cli.textInput.Update(msg)
@@ -1,15 +1,32 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/adshao/go-binance/v2"
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/ws396/autobinance/internal/analysis"
 	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/datasets"
 	"github.com/ws396/autobinance/internal/download"
 	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/montecarlo"
+	"github.com/ws396/autobinance/internal/optimize"
 	"github.com/ws396/autobinance/internal/output"
+	"github.com/ws396/autobinance/internal/replay"
+	"github.com/ws396/autobinance/internal/report"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/strategies"
+	"github.com/ws396/autobinance/internal/synthetic"
 	"github.com/ws396/autobinance/internal/util"
 )
 
@@ -19,17 +36,30 @@ type ViewNode struct {
 }
 
 var (
-	root   *ViewNode
-	root_1 *ViewNode
-	root_2 *ViewNode
-	root_3 *ViewNode
-	root_4 *ViewNode
-	root_5 *ViewNode
-	root_6 *ViewNode
-	root_7 *ViewNode
-	root_8 *ViewNode
-	root_9 *ViewNode
+	root     *ViewNode
+	root_1   *ViewNode
+	root_2   *ViewNode
+	root_3   *ViewNode
+	root_4   *ViewNode
+	root_5   *ViewNode
+	root_6   *ViewNode
+	root_7   *ViewNode
+	root_8   *ViewNode
+	root_8_1 *ViewNode
+	root_9   *ViewNode
 	//root_10 *ViewNode
+	root_11 *ViewNode
+	root_12 *ViewNode
+	root_13 *ViewNode
+	root_14 *ViewNode
+	root_15 *ViewNode
+	root_16 *ViewNode
+	root_17 *ViewNode
+	// Shows the diff of root_17
+	root_17_1 *ViewNode
+	root_18   *ViewNode
+	root_19   *ViewNode
+	root_20   *ViewNode
 )
 
 func init() {
@@ -48,7 +78,9 @@ func init() {
 			msg := fmt.Sprint(
 				simulationStatus,
 				"AUTOBINANCE", "\n",
-				"Trading status: ", tradingStatus, "\n\n",
+				"Trading status: ", tradingStatus, "\n",
+				replayStatus(cli),
+				downloadStatus(cli), "\n",
 				"1) Start trading session", "\n",
 				"2) Set strategies", "\n",
 				"3) Set trade symbols", "\n",
@@ -58,7 +90,17 @@ func init() {
 				"7) Recreate tables", "\n",
 				"8) Download testdata", "\n",
 				"9) Run backtest", "\n",
-				"10) Quit trading session",
+				"10) Quit trading session", "\n",
+				"11) Show last decisions", "\n",
+				"12) Write analyses of a strategy version to log", "\n",
+				"13) Optimize strategy params", "\n",
+				"14) Run walk-forward analysis", "\n",
+				"15) Run Monte Carlo analysis of the last backtest", "\n",
+				"16) List backtest runs", "\n",
+				"17) Compare two backtest runs", "\n",
+				"18) Generate synthetic testdata", "\n",
+				"19) Start replay session", "\n",
+				"20) Sync testdata",
 			)
 
 			return msg
@@ -121,11 +163,19 @@ func init() {
 					return nil
 				}
 
-				analyses := analysis.CreateAnalyses(
-					foundOrders,
-					foundOrders[0].CreatedAt,
-					foundOrders[len(foundOrders)-1].CreatedAt,
+				start, end := foundOrders[0].CreatedAt, foundOrders[len(foundOrders)-1].CreatedAt
+				analyses := withPortfolio(
+					analysis.CreateAnalyses(foundOrders, start, end),
+					analysis.PortfolioAnalysis(foundOrders, start, end),
 				)
+
+				benchmarks, err := liveBenchmarks(cli, foundOrders, start, end)
+				if err != nil {
+					cli.HandleError(err)
+					return nil
+				}
+				benchmarks.AddBenchmarks(analyses, foundOrders)
+
 				err = cli.T.StorageClient.StoreAnalyses(analyses)
 				if err != nil {
 					cli.HandleError(err)
@@ -167,6 +217,31 @@ func init() {
 				}
 
 				return root
+			case "11":
+				return root_11
+			case "12":
+				return root_12
+			case "13":
+				return root_13
+			case "14":
+				return root_14
+			case "15":
+				if cli.lastBacktest == nil {
+					cli.info = "Run a backtest first"
+					return nil
+				}
+
+				return root_15
+			case "16":
+				return root_16
+			case "17":
+				return root_17
+			case "18":
+				return root_18
+			case "19":
+				return root_19
+			case "20":
+				return root_20
 			default:
 				cli.info = "Invalid choice"
 			}
@@ -191,6 +266,8 @@ func init() {
 			return fmt.Sprint(
 				"Available strategies: ",
 				cli.T.Settings["available_strategies"].Value, "\n",
+				"Strategy versions: ",
+				strategyFingerprints(), "\n",
 				"Currently selected strategies: ",
 				cli.T.Settings["selected_strategies"].Value, "\n",
 				"Enter new strategy set (ex. example other):",
@@ -225,7 +302,7 @@ func init() {
 			return fmt.Sprint(
 				"Currently selected symbols: ",
 				cli.T.Settings["selected_symbols"].Value, "\n",
-				"Enter new symbols set (ex. LTCBTC ETHBTC):",
+				"Enter new symbols set, ", synthetic.Prefix, " ones for synthetic testdata (ex. LTCBTC ETHBTC):",
 			)
 		},
 		action: func(cli *CLI) *ViewNode {
@@ -233,7 +310,8 @@ func init() {
 			selectedSymbols := strings.Split(cli.textInput.Value(), " ")
 
 			for _, v := range selectedSymbols {
-				if !util.Contains(allSymbols, v) {
+				// Synthetic symbols stand for the real ones
+				if !util.Contains(allSymbols, strings.TrimPrefix(v, synthetic.Prefix)) {
 					cli.err = globals.ErrWrongSymbol
 					return nil
 				}
@@ -293,7 +371,9 @@ func init() {
 			return fmt.Sprint(
 				"Testdata will be downloaded for next symbols:", "\n",
 				cli.T.Settings["selected_symbols"].Value, "\n",
-				"Enter the desired period of time (ex. 01-02-2021 30-03-2021):",
+				"Enter the desired period of time and optionally the dataset, spot/klines by default:", "\n",
+				datasetNames(), "\n",
+				"(ex. 01-02-2021 30-03-2021 futures/um/fundingRate):",
 			)
 		},
 		action: func(cli *CLI) *ViewNode {
@@ -302,26 +382,61 @@ func init() {
 				return nil
 			}
 
-			start, end, err := util.ExtractTimepoints(cli.textInput.Value())
+			args := strings.Fields(cli.textInput.Value())
+			dataset := datasets.SpotKlines
+			if len(args) == 3 {
+				var err error
+				dataset, err = datasets.Parse(args[2])
+				if err != nil {
+					cli.HandleError(err)
+					return nil
+				}
+				args = args[:2]
+			}
+			start, end, err := util.ExtractTimepoints(strings.Join(args, " "))
 			if err != nil {
 				cli.HandleError(err)
 				return nil
 			}
 
-			// Visualize progress bar for this?
-
-			err = download.KlinesCSVFromZips(
-				cli.T.Settings["selected_symbols"].ValueArr,
-				globals.Timeframe,
-				start,
-				end,
-			)
+			err = startDownload(cli, func(ctx context.Context, workers int, progress chan download.Progress) (string, error) {
+				err := download.Download(ctx, download.Config{
+					Dataset:   dataset,
+					Symbols:   cli.T.Settings["selected_symbols"].ValueArr,
+					Timeframe: globals.Timeframe,
+					Start:     start,
+					End:       end,
+					Workers:   workers,
+					Progress:  progress,
+					Quality:   logQuality,
+				})
+				return "Testdata downloaded", err
+			})
 			if err != nil {
 				cli.HandleError(err)
 				return nil
 			}
 
-			cli.info = "Testdata downloaded"
+			return root_8_1
+		},
+	}
+
+	root_8_1 = &ViewNode{
+		view: func(cli *CLI) string {
+			if cli.cancelDownload == nil {
+				return fmt.Sprint(downloadBar(cli.downloadProgress), "\n", "Press Enter to go back")
+			}
+
+			return fmt.Sprint(
+				downloadBar(cli.downloadProgress), "\n",
+				"Enter c to cancel the download, or anything else to go back while it keeps going:",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			if cli.textInput.Value() == "c" && cli.cancelDownload != nil {
+				cli.cancelDownload()
+				return nil
+			}
 
 			return root
 		},
@@ -333,30 +448,423 @@ func init() {
 				"Backtesting will be done for next strategies-symbols:", "\n",
 				cli.T.Settings["selected_strategies"].Value, "\n",
 				cli.T.Settings["selected_symbols"].Value, "\n",
-				"Enter the period for backtesting (ex. 01-02-2021 30-03-2021):",
+				"Enter the period for backtesting and optionally the gap policy: skip (default), ffill or halt,", "\n",
+				"stop loss and take profit percents, limit buy offset, intrabar path: nearest (default), ohlc or olhc", "\n",
+				"the timeframe strategies run on, resampled from the 1m klines, lean to keep memory low on large datasets", "\n",
+				"and evals to keep every evaluation for the indicators in the report", "\n",
+				"(ex. 01-02-2021 30-03-2021 ffill sl:2 tp:4 limit:0.5 path:ohlc tf:1h lean):",
 			)
 		},
 		action: func(cli *CLI) *ViewNode {
-			analyses, err := backtest.Backtest(cli.textInput.Value(), cli.T.Settings)
+			result, err := backtest.Backtest(cli.textInput.Value(), cli.T.Settings)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			run, err := backtest.SaveRun(cli.T.StorageClient, result)
 			if err != nil {
 				cli.HandleError(err)
 				return nil
 			}
 
-			err = cli.T.StorageClient.StoreAnalyses(analyses)
+			util.WriteToLogMisc(withPortfolio(result.Analyses, result.Portfolio), result.Coverage)
+			cli.lastBacktest = result
+			cli.lastRunID = run.ID
+
+			path, err := report.WriteFile(result)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			cli.info = fmt.Sprint("Backtesting successful. Run ", run.ID, " written to storage, analyses to log_misc. Report: ", path)
+			if result.AmbiguousFills != 0 {
+				cli.info += fmt.Sprint(" ", result.AmbiguousFills, " fills were on ambiguous candles.")
+			}
+
+			return root
+		},
+	}
+
+	root_11 = &ViewNode{
+		view: func(cli *CLI) string {
+			evaluations := cli.T.LastEvaluations()
+			if len(evaluations) == 0 {
+				return "No decisions have been made yet (press Enter to go back to root)."
+			}
+
+			msg := ""
+			for _, o := range evaluations {
+				msg += fmt.Sprint(
+					o.Strategy, " ", o.Symbol, " ", o.Decision,
+					" at ", o.CreatedAt.Format("02-01-2006 15:04:05"), "\n",
+					o.Trace.String(), "\n\n",
+				)
+			}
+
+			return msg + "Press Enter to refresh, \\b to go back to root."
+		},
+		action: func(cli *CLI) *ViewNode {
+			return nil
+		},
+	}
+
+	root_12 = &ViewNode{
+		view: func(cli *CLI) string {
+			return fmt.Sprint(
+				"Strategy versions: ",
+				strategyFingerprints(), "\n",
+				"Enter the fingerprint to look up (ex. 1.0.0-3f2a9c1b7d4e):",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			analyses, err := cli.T.StorageClient.GetAnalysesByFingerprint(cli.textInput.Value())
 			if err != nil {
 				cli.HandleError(err)
 				return nil
 			}
 
 			util.WriteToLogMisc(analyses)
+			cli.info = fmt.Sprint(len(analyses), " analyses written to log_misc.")
+
+			return root
+		},
+	}
+
+	root_13 = &ViewNode{
+		view: func(cli *CLI) string {
+			return fmt.Sprint(
+				"Optimization will be done on the downloaded data of next symbols:", "\n",
+				cli.T.Settings["selected_symbols"].Value, "\n",
+				"Strategy versions: ",
+				strategyFingerprints(), "\n",
+				"Objectives: ", strings.Join(objectiveNames(), " "), "\n",
+				"Enter the strategy, param ranges, period, objective and optionally the method", "\n",
+				"(ex. example window=5:20:5 lookback=1:3:1 01-02-2021 30-03-2021 sharpe random:50):",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			results, err := optimize.Run(cli.textInput.Value(), cli.T.Settings)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			err = cli.T.StorageClient.StoreOptimizationResults(results)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			util.WriteToLogMisc(results)
+
+			if len(results) != 0 {
+				cli.info = fmt.Sprint(
+					"Optimization batch ", results[0].Batch, " stored and written to log_misc. ",
+					"Best params: ", results[0].Params,
+				)
+			}
+
+			return root
+		},
+	}
+
+	root_14 = &ViewNode{
+		view: func(cli *CLI) string {
+			return fmt.Sprint(
+				"Walk-forward analysis will be done on the downloaded data of next symbols:", "\n",
+				cli.T.Settings["selected_symbols"].Value, "\n",
+				"Strategy versions: ",
+				strategyFingerprints(), "\n",
+				"Objectives: ", strings.Join(objectiveNames(), " "), "\n",
+				"Enter the same input as for optimization, followed by in-sample/out-of-sample days", "\n",
+				"(ex. example window=5:20:5 lookback=1:3:1 01-02-2021 30-03-2021 sharpe 14d/7d):",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			result, err := optimize.RunWalkForward(cli.textInput.Value(), cli.T.Settings)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			for _, w := range result.Windows {
+				if len(w.Analyses) == 0 {
+					continue
+				}
+
+				err = cli.T.StorageClient.StoreAnalyses(w.Analyses)
+				if err != nil {
+					cli.HandleError(err)
+					return nil
+				}
+			}
+
+			util.WriteToLogMisc(result)
+			cli.info = fmt.Sprintf(
+				"Walk-forward analysis of %d windows written to log_misc. Out-of-sample profit: %.2f USD, efficiency: %.2f",
+				len(result.Windows), result.OutOfSample.ProfitUSD, result.Efficiency,
+			)
+
+			return root
+		},
+	}
+
+	root_15 = &ViewNode{
+		view: func(cli *CLI) string {
+			return fmt.Sprint(
+				"Monte Carlo analysis will resample the trades of the last backtest.", "\n",
+				"Enter the method (shuffle, bootstrap or skip), optionally followed by the amount of runs", "\n",
+				"and the chance to skip a trade (ex. skip 1000 0.2):",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			args := strings.Split(cli.textInput.Value(), " ")
+			method, err := montecarlo.ParseMethod(args[0])
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			config := montecarlo.Config{
+				Method: method,
+				Seed:   time.Now().UnixNano(),
+			}
+			if len(args) > 1 {
+				config.Runs, err = strconv.Atoi(args[1])
+				if err != nil {
+					cli.HandleError(err)
+					return nil
+				}
+			}
+			if len(args) > 2 {
+				skipChance, err := strconv.ParseFloat(args[2], 64)
+				if err != nil {
+					cli.HandleError(err)
+					return nil
+				}
+				config.SkipChance = &skipChance
+			}
+
+			result, err := montecarlo.Simulate(analysis.RoundTrips(cli.lastBacktest.Orders), config)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			err = cli.T.StorageClient.StoreMonteCarloResult(montecarlo.Record(result, cli.lastBacktest.Config, cli.lastRunID))
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			util.WriteToLogMisc(result)
+			cli.info = fmt.Sprintf(
+				"Monte Carlo analysis written to storage and log_misc. Median final equity: %.2f USD, ruin probability: %.1f%%",
+				result.FinalEquity.P50, result.RuinProbability*100,
+			)
+
+			return root
+		},
+	}
+
+	root_16 = &ViewNode{
+		view: func(cli *CLI) string {
+			runs, err := cli.T.StorageClient.GetBacktestRuns()
+			if err != nil {
+				cli.HandleError(err)
+				return ""
+			}
+			if len(runs) == 0 {
+				return "No backtests have been run yet (press Enter to go back to root)."
+			}
+
+			msg := ""
+			for _, r := range runs {
+				msg += fmt.Sprintf(
+					"%d) %s - %s %s %s | %s | trades: %d, profit: %.2f USD | %s\n",
+					r.ID, r.Start.Format("02-01-2006"), r.End.Format("02-01-2006"), r.Timeframe,
+					r.Symbols, r.Strategies, r.Trades, r.ProfitUSD, r.CreatedAt.Format("02-01-2006 15:04"),
+				)
+			}
+
+			return msg + "(press Enter to go back to root)"
+		},
+		action: func(cli *CLI) *ViewNode {
+			return root
+		},
+	}
+
+	root_17 = &ViewNode{
+		view: func(cli *CLI) string {
+			return "Enter the ids of two backtest runs to compare (ex. 3 5):"
+		},
+		action: func(cli *CLI) *ViewNode {
+			diff, err := diffRuns(cli.T.StorageClient, cli.textInput.Value())
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+			cli.runDiff = diff
+
+			return root_17_1
+		},
+	}
+
+	root_17_1 = &ViewNode{
+		view: func(cli *CLI) string {
+			return cli.runDiff + "\n(press Enter to go back to root)"
+		},
+		action: func(cli *CLI) *ViewNode {
+			return root
+		},
+	}
+
+	root_18 = &ViewNode{
+		view: func(cli *CLI) string {
+			names := []string{}
+			for name := range synthetic.Scenarios {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			return fmt.Sprint(
+				"Synthetic testdata will be stored apart from the downloaded one, under ", synthetic.Prefix, "<symbol>, for next symbols:", "\n",
+				cli.T.Settings["selected_symbols"].Value, "\n",
+				"Enter the period, a scenario (", strings.Join(names, ", "), ") and a seed", "\n",
+				"(ex. 01-01-2022 31-03-2022 stress 42):",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			err := synthetic.StoreScenario(cli.textInput.Value(), cli.T.Settings["selected_symbols"].ValueArr)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
 
-			cli.info = "Backtesting successful. Analyses written to storage and log_misc."
+			cli.info = fmt.Sprint("Synthetic testdata generated, select ", synthetic.Prefix, "<symbol> to backtest on it")
 
 			return root
 		},
 	}
 
+	root_19 = &ViewNode{
+		view: func(cli *CLI) string {
+			return fmt.Sprint(
+				"Stored klines of next symbols will be replayed through a trading session:", "\n",
+				cli.T.Settings["selected_symbols"].Value, "\n",
+				"Orders are kept apart from the trade history. Enter the period and the speed: 1x, 60x or max", "\n",
+				"(ex. 01-02-2021 03-02-2021 60x):",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			if cli.T.TradingRunning {
+				cli.HandleError(globals.ErrTradingAlreadyRunning)
+				return nil
+			}
+
+			r, err := replay.FromInput(cli.textInput.Value(), cli.T.Settings["selected_symbols"].ValueArr)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+			w, err := output.NewWriterCreator().CreateWriter(output.Excel)
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			restore := r.Attach(cli.T)
+			cli.replay = r
+			errChan := cli.T.StartTradingSession(r.Writer(w))
+			go func() {
+				err := r.Run()
+				if err != nil {
+					cli.HandleError(err)
+				}
+			}()
+			go func() {
+				defer restore()
+				defer r.Stop()
+				defer func() { cli.replay = nil }()
+
+				for err := range errChan {
+					if err != nil {
+						cli.T.StopTradingSession()
+						cli.HandleError(err)
+						return
+					}
+				}
+				cli.info = "Replay finished"
+			}()
+
+			return root_1
+		},
+	}
+
+	root_20 = &ViewNode{
+		view: func(cli *CLI) string {
+			return fmt.Sprint(
+				"Stored testdata of next symbols will be brought up to date:", "\n",
+				cli.T.Settings["selected_symbols"].Value, "\n",
+				"Only missing days are downloaded, the ones not published yet are fetched from the exchange.", "\n",
+				"Gaps left in the klines are written to log. Enter the start of the history and optionally", "\n",
+				"the timeframes, ", globals.Timeframe, " by default (ex. 01-01-2022 1m 1h):",
+			)
+		},
+		action: func(cli *CLI) *ViewNode {
+			if len(cli.T.Settings["selected_symbols"].Value) == 0 {
+				cli.err = globals.ErrSymbolsNotFound
+				return nil
+			}
+
+			args := strings.Fields(cli.textInput.Value())
+			if len(args) == 0 {
+				cli.HandleError(globals.ErrWrongArgumentAmount)
+				return nil
+			}
+			start, err := time.Parse("02-01-2006", args[0])
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+			timeframes := args[1:]
+			if len(timeframes) == 0 {
+				timeframes = []string{globals.Timeframe}
+			}
+
+			err = startDownload(cli, func(ctx context.Context, workers int, progress chan download.Progress) (string, error) {
+				reports, err := download.Sync(ctx, download.SyncConfig{
+					Symbols:    cli.T.Settings["selected_symbols"].ValueArr,
+					Timeframes: timeframes,
+					Start:      start,
+					Workers:    workers,
+					Progress:   progress,
+					Client:     cli.T.ExchangeClient,
+					Quality:    logQuality,
+				})
+				if len(reports) == 0 {
+					return "", err
+				}
+
+				gaps := 0
+				for _, r := range reports {
+					gaps += len(r.Gaps)
+				}
+				util.WriteToLogMisc(reports)
+
+				return fmt.Sprint("Testdata synced, ", gaps, " gaps written to log"), err
+			})
+			if err != nil {
+				cli.HandleError(err)
+				return nil
+			}
+
+			return root_8_1
+		},
+	}
+
 	/*
 		root_10 = &ViewNode{
 			view: func(cli *CLI) string {
@@ -368,3 +876,264 @@ func init() {
 		}
 	*/
 }
+
+func replayStatus(cli *CLI) string {
+	if cli.replay == nil {
+		return ""
+	}
+
+	speed := "max"
+	if cli.replay.Speed != replay.MaxSpeed {
+		speed = fmt.Sprint(cli.replay.Speed, "x")
+	}
+
+	return fmt.Sprint("Replaying at ", speed, ", replay time: ", cli.replay.Clock.Now().UTC().Format("02-01-2006 15:04"), "\n")
+}
+
+// downloadState is where the goroutines of a download leave its progress
+// and result, for Update to pick up. Nothing waits on it, so the download
+// keeps going with no session attached.
+type downloadState struct {
+	lock     sync.Mutex
+	progress download.Progress
+	result   *downloadResult
+}
+
+type downloadResult struct {
+	info string
+	err  error
+}
+
+type downloadTickMsg struct{}
+
+// startDownload runs the download in the background. Update picks up its
+// progress on every tick, and the message shown once it's done.
+func startDownload(cli *CLI, run func(ctx context.Context, workers int, progress chan download.Progress) (string, error)) error {
+	if cli.cancelDownload != nil {
+		return globals.ErrDownloadAlreadyRunning
+	}
+	workers, err := download.WorkersFromEnv()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	progress := make(chan download.Progress)
+	state := &downloadState{}
+	cli.cancelDownload = cancel
+	cli.downloadProgress = download.Progress{}
+	cli.download = state
+	done := make(chan downloadResult)
+	go func() {
+		info, err := run(ctx, workers, progress)
+		done <- downloadResult{info, err}
+	}()
+	go func() {
+		for p := range progress {
+			if p.Err != nil {
+				util.Logger.Error(p.Err.Error())
+			}
+			state.lock.Lock()
+			state.progress = p
+			state.lock.Unlock()
+		}
+
+		result := <-done
+		state.lock.Lock()
+		state.result = &result
+		state.lock.Unlock()
+	}()
+
+	return nil
+}
+
+// watchDownload ticks while a download is running, every session that's
+// attached has its own ticks.
+func watchDownload() tea.Cmd {
+	return tea.Tick(download.ProgressInterval, func(time.Time) tea.Msg {
+		return downloadTickMsg{}
+	})
+}
+
+// pollDownload brings the progress of the download over, and wraps it up
+// once it's done.
+func (cli *CLI) pollDownload() tea.Cmd {
+	state := cli.download
+	if state == nil {
+		return nil
+	}
+
+	state.lock.Lock()
+	cli.downloadProgress = state.progress
+	result := state.result
+	state.lock.Unlock()
+	if result == nil {
+		return watchDownload()
+	}
+
+	cli.cancelDownload()
+	cli.cancelDownload = nil
+	cli.download = nil
+	switch {
+	case result.err == context.Canceled:
+		cli.info = "Download cancelled"
+	case result.err != nil:
+		cli.HandleError(result.err)
+	default:
+		cli.info = result.info
+	}
+
+	return nil
+}
+
+// logQuality writes the quality reports of files with issues to log.
+func logQuality(q candles.Quality) {
+	if !q.Clean() {
+		util.WriteToLogMisc(q)
+	}
+}
+
+func datasetNames() string {
+	names := []string{}
+	for _, d := range datasets.All {
+		names = append(names, d.String())
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func downloadStatus(cli *CLI) string {
+	if cli.cancelDownload == nil {
+		return ""
+	}
+
+	return fmt.Sprint("Downloading testdata ", downloadBar(cli.downloadProgress), "\n")
+}
+
+// downloadBar draws the progress of a download, ex.
+// [########------------] 8/20 archives, 1 failed, 12.4 MB, ETA 35s
+func downloadBar(p download.Progress) string {
+	const width = 20
+	filled := 0
+	if p.Queued > 0 {
+		filled = width * p.Finished() / p.Queued
+	}
+
+	bar := fmt.Sprint(
+		"[", strings.Repeat("#", filled), strings.Repeat("-", width-filled), "] ",
+		p.Finished(), "/", p.Queued, " archives",
+	)
+	if p.Failed > 0 {
+		bar += fmt.Sprint(", ", p.Failed, " failed")
+	}
+	bar += fmt.Sprintf(", %.1f MB", float64(p.Bytes)/(1<<20))
+	if p.ETA > 0 {
+		bar += fmt.Sprint(", ETA ", p.ETA.Round(time.Second))
+	}
+
+	return bar
+}
+
+// withPortfolio adds the portfolio analysis next to the per strategy-symbol ones.
+func withPortfolio(analyses map[string]storage.Analysis, portfolio storage.Analysis) map[string]storage.Analysis {
+	result := map[string]storage.Analysis{analysis.PortfolioStrategy: portfolio}
+	for k, a := range analyses {
+		result[k] = a
+	}
+
+	return result
+}
+
+// liveBenchmarks fetches the klines of the traded and selected symbols over
+// the period of the orders.
+func liveBenchmarks(cli *CLI, orders []storage.Order, start, end time.Time) (analysis.Benchmarks, error) {
+	b := analysis.Benchmarks{
+		Klines: map[string][]*binance.Kline{},
+		Basket: cli.T.Settings["selected_symbols"].ValueArr,
+		Symbol: analysis.BenchmarkSymbol(),
+	}
+
+	symbols := append([]string{b.Symbol}, b.Basket...)
+	for _, o := range orders {
+		symbols = append(symbols, o.Symbol)
+	}
+	for _, s := range symbols {
+		if _, ok := b.Klines[s]; ok || s == "" {
+			continue
+		}
+
+		klines := []*binance.Kline{}
+		err := binancew.PageKlines(context.Background(), cli.T.ExchangeClient, s, globals.Timeframe, start, end, func(page []*binance.Kline) error {
+			klines = append(klines, page...)
+			return nil
+		})
+		if err != nil {
+			return analysis.Benchmarks{}, err
+		}
+		b.Klines[s] = klines
+	}
+
+	return b, nil
+}
+
+// diffRuns lays out two stored runs side by side, marking the rows that differ.
+func diffRuns(client storage.StorageClient, input string) (string, error) {
+	args := strings.Fields(input)
+	if len(args) != 2 {
+		return "", globals.ErrWrongRunIDs
+	}
+
+	runs := make([]*storage.BacktestRun, 2)
+	analyses := make([][]storage.Analysis, 2)
+	for i, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return "", globals.ErrWrongRunIDs
+		}
+
+		runs[i], err = client.GetBacktestRun(uint(id))
+		if err != nil {
+			return "", err
+		}
+		analyses[i], err = client.GetAnalysesByRun(uint(id))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	msg := ""
+	for _, r := range backtest.Diff(*runs[0], *runs[1], analyses[0], analyses[1]) {
+		mark := " "
+		if r.Differs() {
+			mark = "*"
+		}
+		msg += fmt.Sprintf("%s %-32s %-40s %s\n", mark, r.Name, r.A, r.B)
+	}
+
+	return msg, nil
+}
+
+func strategyFingerprints() string {
+	names := []string{}
+	for k := range strategies.StrategiesInfo {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	fingerprints := []string{}
+	for _, k := range names {
+		fingerprints = append(fingerprints, k+"@"+strategies.StrategiesInfo[k].Fingerprint())
+	}
+
+	return strings.Join(fingerprints, " ")
+}
+
+func objectiveNames() []string {
+	names := []string{}
+	for k := range optimize.Scores {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -1,11 +1,18 @@
 package analysis
 
 import (
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ws396/autobinance/internal/globals"
 	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/util"
+)
+
+const (
+	PortfolioStrategy = "portfolio"
+	PortfolioSymbol   = "ALL"
 )
 
 func CreateAnalyses(orders []storage.Order, start, end time.Time) map[string]storage.Analysis {
@@ -16,8 +23,15 @@ func CreateAnalyses(orders []storage.Order, start, end time.Time) map[string]sto
 			continue
 		}
 
-		k := o.Strategy + "_" + o.Symbol
+		k := Key(o)
 		a := analyses[k]
+		a.Strategy = o.Strategy
+		a.Symbol = o.Symbol
+		a.Fingerprint = o.Fingerprint
+		a.Timeframe = o.Timeframe
+		a.Fees += o.Fee
+		a.Slippage += o.Slippage
+		a.ProfitUSD -= o.Fee
 
 		if o.Decision == globals.Buy {
 			a.Buys += 1
@@ -42,17 +56,70 @@ func CreateAnalyses(orders []storage.Order, start, end time.Time) map[string]sto
 		analyses[k] = a
 	}
 
+	trips := map[string][]RoundTrip{}
+	for _, rt := range RoundTrips(orders) {
+		k := Key(storage.Order{Strategy: rt.Strategy, Symbol: rt.Symbol, Fingerprint: rt.Fingerprint})
+		trips[k] = append(trips[k], rt)
+	}
+
 	t := time.Now()
 	for k, a := range analyses {
-		ss := strings.Split(k, "_")
-		a.Strategy = ss[0]
-		a.Symbol = ss[1]
+		a.Metrics = Measure(trips[k], start, end)
 		a.Start = start
 		a.End = end
 		a.CreatedAt = t
-		a.Timeframe = orders[0].Timeframe
 		analyses[k] = a
 	}
 
 	return analyses
 }
+
+// PortfolioAnalysis sums up every strategy and symbol as if they were
+// traded from a single account. Its timeframe lists the ones of the
+// strategies if they differ, ex. "1m 1h".
+func PortfolioAnalysis(orders []storage.Order, start, end time.Time) storage.Analysis {
+	p := storage.Analysis{
+		Strategy:  PortfolioStrategy,
+		Symbol:    PortfolioSymbol,
+		Start:     start,
+		End:       end,
+		CreatedAt: time.Now(),
+	}
+	if len(orders) == 0 {
+		return p
+	}
+
+	timeframes := []string{}
+	for _, a := range CreateAnalyses(orders, start, end) {
+		if !util.Contains(timeframes, a.Timeframe) {
+			timeframes = append(timeframes, a.Timeframe)
+		}
+		p.Buys += a.Buys
+		p.Sells += a.Sells
+		p.SuccessfulSells += a.SuccessfulSells
+		p.ProfitUSD += a.ProfitUSD
+		p.Fees += a.Fees
+		p.Slippage += a.Slippage
+	}
+	if p.Sells != 0 {
+		p.SuccessRate = float64(p.SuccessfulSells) / float64(p.Sells) * 100
+	}
+	p.Metrics = Measure(RoundTrips(orders), start, end)
+	sort.Slice(timeframes, func(i, j int) bool {
+		return globals.Durations[timeframes[i]] < globals.Durations[timeframes[j]]
+	})
+	p.Timeframe = strings.Join(timeframes, " ")
+
+	return p
+}
+
+// Key groups orders by strategy and symbol. Orders from different strategy
+// versions or params never end up in the same analysis.
+func Key(o storage.Order) string {
+	k := o.Strategy + "_" + o.Symbol
+	if o.Fingerprint != "" {
+		k += "_" + o.Fingerprint
+	}
+
+	return k
+}
@@ -53,6 +53,7 @@ func TestAnalysis(t *testing.T) {
 
 		stubTime := time.Unix(1600000000, 0)
 		got := analysis.CreateAnalyses(orders, time.Unix(1600000000, 0), time.Unix(1600000000, 0))
+		profit := (orders[2].Price - orders[0].Price) * orders[0].Quantity
 
 		for k, a := range got {
 			a.CreatedAt = stubTime
@@ -69,10 +70,15 @@ func TestAnalysis(t *testing.T) {
 				SuccessfulSells: 1,
 				ProfitUSD:       1.5,
 				SuccessRate:     100,
-				Timeframe:       "1m",
-				Start:           stubTime,
-				End:             stubTime,
-				CreatedAt:       stubTime,
+				Metrics: storage.Metrics{
+					Trades:     1,
+					Expectancy: profit,
+					AvgWin:     profit,
+				},
+				Timeframe: "1m",
+				Start:     stubTime,
+				End:       stubTime,
+				CreatedAt: stubTime,
 			},
 		}
 
@@ -80,4 +86,63 @@ func TestAnalysis(t *testing.T) {
 			t.Errorf("created wrong analysis, got %v want %v", got, want)
 		}
 	})
+	t.Run("separates orders by fingerprint", func(t *testing.T) {
+		orders := []storage.Order{
+			{Strategy: "-", Fingerprint: "1.0.0-a", Symbol: "-", Decision: globals.Buy, Quantity: 1, Price: 5, Successful: true},
+			{Strategy: "-", Fingerprint: "1.0.0-a", Symbol: "-", Decision: globals.Sell, Quantity: 1, Price: 6, Successful: true},
+			{Strategy: "-", Fingerprint: "1.0.1-b", Symbol: "-", Decision: globals.Buy, Quantity: 1, Price: 6, Successful: true},
+			{Strategy: "-", Fingerprint: "1.0.1-b", Symbol: "-", Decision: globals.Sell, Quantity: 1, Price: 4, Successful: true},
+		}
+
+		got := analysis.CreateAnalyses(orders, time.Unix(1600000000, 0), time.Unix(1600000000, 0))
+
+		if len(got) != 2 {
+			t.Fatalf("expected 2 analyses, got %v", got)
+		}
+		if got["-_-_1.0.0-a"].ProfitUSD != 1 || got["-_-_1.0.1-b"].ProfitUSD != -2 {
+			t.Errorf("mixed orders of different fingerprints, got %v", got)
+		}
+		if got["-_-_1.0.1-b"].Fingerprint != "1.0.1-b" {
+			t.Errorf("analysis is missing fingerprint, got %v", got["-_-_1.0.1-b"])
+		}
+	})
+
+	t.Run("labels analyses with the timeframe of their orders", func(t *testing.T) {
+		start := time.Unix(1600000000, 0)
+		orders := []storage.Order{
+			{Strategy: "slow", Symbol: "A", Decision: globals.Hold, Timeframe: "1m", CreatedAt: start},
+			{Strategy: "slow", Symbol: "A", Decision: globals.Buy, Quantity: 1, Price: 5, Timeframe: "1h", Successful: true, CreatedAt: start},
+			{Strategy: "fast", Symbol: "A", Decision: globals.Buy, Quantity: 1, Price: 5, Timeframe: "5m", Successful: true, CreatedAt: start},
+			{Strategy: "fast", Symbol: "B", Decision: globals.Buy, Quantity: 1, Price: 5, Timeframe: "5m", Successful: true, CreatedAt: start},
+		}
+
+		got := analysis.CreateAnalyses(orders, start, start.Add(time.Hour))
+		portfolio := analysis.PortfolioAnalysis(orders, start, start.Add(time.Hour))
+
+		if got["slow_A"].Timeframe != "1h" || got["fast_A"].Timeframe != "5m" || got["fast_B"].Timeframe != "5m" {
+			t.Errorf("wrong timeframes of analyses, got %v", got)
+		}
+		if portfolio.Timeframe != "5m 1h" {
+			t.Errorf("expected portfolio to list the timeframes, got %v", portfolio.Timeframe)
+		}
+	})
+
+	t.Run("sums up the portfolio", func(t *testing.T) {
+		start := time.Unix(1600000000, 0)
+		orders := []storage.Order{
+			{Strategy: "-", Symbol: "A", Decision: globals.Buy, Quantity: 1, Price: 5, Successful: true, CreatedAt: start},
+			{Strategy: "-", Symbol: "B", Decision: globals.Buy, Quantity: 1, Price: 5, Successful: true, CreatedAt: start.Add(time.Minute)},
+			{Strategy: "-", Symbol: "A", Decision: globals.Sell, Quantity: 1, Price: 7, Successful: true, CreatedAt: start.Add(2 * time.Minute)},
+			{Strategy: "-", Symbol: "B", Decision: globals.Sell, Quantity: 1, Price: 4, Successful: true, CreatedAt: start.Add(3 * time.Minute)},
+		}
+
+		got := analysis.PortfolioAnalysis(orders, start, start.Add(6*time.Minute))
+
+		if got.Buys != 2 || got.Sells != 2 || got.ProfitUSD != 1 || got.SuccessRate != 50 {
+			t.Errorf("wrong portfolio totals, got %+v", got)
+		}
+		if got.Metrics.Trades != 2 || got.Metrics.ProfitFactor != 2 || got.Metrics.Exposure != 50 {
+			t.Errorf("wrong portfolio metrics, got %+v", got.Metrics)
+		}
+	})
 }
@@ -0,0 +1,223 @@
+package analysis
+
+import (
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+)
+
+const (
+	HoldBenchmark   = "hold"
+	BasketBenchmark = "basket"
+)
+
+// Benchmarks are held over the same klines the analyses were traded on.
+type Benchmarks struct {
+	// Klines of every traded symbol, and of Symbol if it's set
+	Klines map[string][]*binance.Kline
+	// Symbols held in equal weights, usually the selected ones
+	Basket []string
+	// Symbol held as the user's benchmark, empty leaves it out
+	Symbol string
+}
+
+// BenchmarkSymbol is the user's benchmark from .env, ex. BTCUSDT.
+func BenchmarkSymbol() string {
+	return strings.ToUpper(os.Getenv("BENCHMARK_SYMBOL"))
+}
+
+type price struct {
+	time  time.Time
+	close float64
+}
+
+// AddBenchmarks compares every analysis against the benchmarks, the
+// portfolio one against all of the trades.
+func (b Benchmarks) AddBenchmarks(analyses map[string]storage.Analysis, orders []storage.Order) {
+	all := RoundTrips(orders)
+	trips := map[string][]RoundTrip{}
+	for _, rt := range all {
+		k := Key(storage.Order{Strategy: rt.Strategy, Symbol: rt.Symbol, Fingerprint: rt.Fingerprint})
+		trips[k] = append(trips[k], rt)
+	}
+
+	for k, a := range analyses {
+		if a.Strategy == PortfolioStrategy {
+			a.Benchmarks = b.Compare(a, all)
+		} else {
+			a.Benchmarks = b.Compare(a, trips[k])
+		}
+		analyses[k] = a
+	}
+}
+
+// Compare marks the trades of the analysis to market on every candle of its
+// symbol, or of the basket for the portfolio, and compares the returns with
+// the ones of holding the benchmarks.
+func (b Benchmarks) Compare(a storage.Analysis, trips []RoundTrip) []storage.Comparison {
+	prices := map[string][]price{}
+	for s, klines := range b.Klines {
+		prices[s] = closes(klines, a.Start, a.End)
+	}
+
+	var grid []time.Time
+	if a.Symbol == PortfolioSymbol {
+		grid = mergeTimes(prices, b.Basket)
+	} else {
+		grid = mergeTimes(prices, []string{a.Symbol})
+	}
+	if len(grid) < 2 {
+		return nil
+	}
+
+	equity := markToMarket(trips, prices, grid)
+	comparisons := []storage.Comparison{}
+	add := func(name string, symbols []string) {
+		held := hold(prices, symbols, grid)
+		if held == nil {
+			return
+		}
+		comparisons = append(comparisons, compare(name, equity, held))
+	}
+
+	if a.Symbol != PortfolioSymbol {
+		add(HoldBenchmark+" "+a.Symbol, []string{a.Symbol})
+	}
+	if len(b.Basket) > 1 {
+		add(BasketBenchmark, b.Basket)
+	}
+	if b.Symbol != "" {
+		add(b.Symbol, []string{b.Symbol})
+	}
+
+	return comparisons
+}
+
+func compare(name string, equity, held []float64) storage.Comparison {
+	c := storage.Comparison{
+		Benchmark: name,
+		Return:    (held[len(held)-1]/held[0] - 1) * 100,
+	}
+	c.ExcessReturn = (equity[len(equity)-1]/equity[0]-1)*100 - c.Return
+
+	rs, rb := stepReturns(equity), stepReturns(held)
+	meanS, meanB := Mean(rs), Mean(rb)
+	var cov, varS, varB float64
+	for i := range rs {
+		cov += (rs[i] - meanS) * (rb[i] - meanB)
+		varS += (rs[i] - meanS) * (rs[i] - meanS)
+		varB += (rb[i] - meanB) * (rb[i] - meanB)
+	}
+
+	if varB != 0 {
+		c.Beta = cov / varB
+	}
+	if varS != 0 && varB != 0 {
+		c.Correlation = cov / math.Sqrt(varS*varB)
+	}
+	c.Alpha = (meanS - c.Beta*meanB) * 100
+
+	return c
+}
+
+// markToMarket is the equity of an account starting with the default buy
+// amount, with closed trades at their profit and open ones at the last close.
+func markToMarket(trips []RoundTrip, prices map[string][]price, grid []time.Time) []float64 {
+	equity := make([]float64, len(grid))
+	for i, t := range grid {
+		equity[i] = globals.BuyAmount
+		for _, rt := range trips {
+			switch {
+			case !rt.Exit.After(t):
+				equity[i] += rt.Profit
+			case rt.Entry.Before(t):
+				if p, ok := priceAt(prices[rt.Symbol], t); ok {
+					equity[i] += (p - rt.EntryPrice) * rt.Quantity
+				}
+			}
+		}
+	}
+
+	return equity
+}
+
+// hold is the value of equal parts of the symbols bought at the first
+// candle. Symbols without a price yet are held as cash.
+func hold(prices map[string][]price, symbols []string, grid []time.Time) []float64 {
+	held := make([]float64, len(grid))
+	for _, s := range symbols {
+		if len(prices[s]) == 0 {
+			return nil
+		}
+
+		first := prices[s][0].close
+		for i, t := range grid {
+			p, ok := priceAt(prices[s], t)
+			if !ok || first == 0 {
+				held[i] += 1 / float64(len(symbols))
+				continue
+			}
+			held[i] += p / first / float64(len(symbols))
+		}
+	}
+
+	return held
+}
+
+func stepReturns(values []float64) []float64 {
+	returns := make([]float64, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		returns[i-1] = values[i]/values[i-1] - 1
+	}
+
+	return returns
+}
+
+// closes of the candles that closed within the period.
+func closes(klines []*binance.Kline, start, end time.Time) []price {
+	prices := []price{}
+	for _, k := range klines {
+		t := time.UnixMilli(k.CloseTime + 1)
+		if t.Before(start) || t.After(end) {
+			continue
+		}
+
+		c, _ := strconv.ParseFloat(k.Close, 64)
+		prices = append(prices, price{t, c})
+	}
+
+	return prices
+}
+
+func mergeTimes(prices map[string][]price, symbols []string) []time.Time {
+	seen := map[time.Time]bool{}
+	times := []time.Time{}
+	for _, s := range symbols {
+		for _, p := range prices[s] {
+			if !seen[p.time] {
+				seen[p.time] = true
+				times = append(times, p.time)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	return times
+}
+
+// priceAt is the last close at or before t.
+func priceAt(prices []price, t time.Time) (float64, bool) {
+	i := sort.Search(len(prices), func(i int) bool { return prices[i].time.After(t) })
+	if i == 0 {
+		return 0, false
+	}
+
+	return prices[i-1].close, true
+}
@@ -0,0 +1,65 @@
+package analysis_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestBenchmarks(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	benchmarks := analysis.Benchmarks{
+		Klines: map[string][]*binance.Kline{
+			"LTCBTC":  testutil.MockKlines(start, 100, 110, 99, 120),
+			"BTCBUSD": testutil.MockKlines(start, 50, 50, 50, 50),
+			"BTCUSDT": testutil.MockKlines(start, 10, 9, 8, 5),
+		},
+		Basket: []string{"LTCBTC", "BTCBUSD"},
+		Symbol: "BTCUSDT",
+	}
+	// Bought at the first close and sold at the last, same as holding
+	orders := []storage.Order{
+		{Strategy: "example", Symbol: "LTCBTC", Decision: globals.Buy, Quantity: 1, Price: 100, Successful: true, CreatedAt: start.Add(time.Minute)},
+		{Strategy: "example", Symbol: "LTCBTC", Decision: globals.Sell, Quantity: 1, Price: 120, Successful: true, CreatedAt: start.Add(4 * time.Minute)},
+	}
+	end := start.Add(4 * time.Minute)
+
+	analyses := analysis.CreateAnalyses(orders, start, end)
+	benchmarks.AddBenchmarks(analyses, orders)
+
+	got := analyses[analysis.Key(orders[0])].Benchmarks
+	if len(got) != 3 {
+		t.Fatalf("expected hold, basket and symbol benchmarks, got %+v", got)
+	}
+
+	strategyReturn := 20 / globals.BuyAmount * 100
+	for i, want := range []storage.Comparison{
+		{Benchmark: "hold LTCBTC", Return: 20, ExcessReturn: strategyReturn - 20},
+		{Benchmark: "basket", Return: 10, ExcessReturn: strategyReturn - 10},
+		{Benchmark: "BTCUSDT", Return: -50, ExcessReturn: strategyReturn + 50},
+	} {
+		if got[i].Benchmark != want.Benchmark ||
+			math.Abs(got[i].Return-want.Return) > 1e-9 ||
+			math.Abs(got[i].ExcessReturn-want.ExcessReturn) > 1e-9 {
+			t.Errorf("wrong comparison, expected %+v, got %+v", want, got[i])
+		}
+	}
+
+	if got[0].Beta <= 0 || got[0].Correlation < 0.99 {
+		t.Errorf("expected holding the symbol to track it, got %+v", got[0])
+	}
+
+	t.Run("portfolio compares against the basket", func(t *testing.T) {
+		portfolio := analysis.PortfolioAnalysis(orders, start, end)
+		got := benchmarks.Compare(portfolio, analysis.RoundTrips(orders))
+		if len(got) != 2 || got[0].Benchmark != analysis.BasketBenchmark {
+			t.Fatalf("expected basket and symbol benchmarks, got %+v", got)
+		}
+	})
+}
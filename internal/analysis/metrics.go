@@ -0,0 +1,277 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+)
+
+// RoundTrip is a buy followed by the sell that closed it.
+type RoundTrip struct {
+	Strategy    string    `json:"strategy"`
+	Symbol      string    `json:"symbol"`
+	Fingerprint string    `json:"fingerprint"`
+	Entry       time.Time `json:"entry"`
+	Exit        time.Time `json:"exit"`
+	EntryPrice  float64   `json:"entryPrice"`
+	ExitPrice   float64   `json:"exitPrice"`
+	Quantity    float64   `json:"quantity"`
+	Fees        float64   `json:"fees"`
+	Profit      float64   `json:"profit"`
+	Return      float64   `json:"return"`
+}
+
+type EquityPoint struct {
+	Time   time.Time `json:"time"`
+	Equity float64   `json:"equity"`
+}
+
+// RoundTrips pairs successful buys and sells of every strategy and symbol,
+// ordered by exit time. Positions still open at the end are left out.
+// Profits are net of the fees of both orders.
+func RoundTrips(orders []storage.Order) []RoundTrip {
+	trips := []RoundTrip{}
+	open := map[string]storage.Order{}
+
+	for _, o := range orders {
+		if !o.Successful {
+			continue
+		}
+
+		k := Key(o)
+		switch o.Decision {
+		case globals.Buy:
+			open[k] = o
+		case globals.Sell:
+			entry, ok := open[k]
+			if !ok {
+				continue
+			}
+			delete(open, k)
+
+			fees := entry.Fee + o.Fee
+			profit := (o.Price-entry.Price)*o.Quantity - fees
+
+			trips = append(trips, RoundTrip{
+				Strategy:    o.Strategy,
+				Symbol:      o.Symbol,
+				Fingerprint: o.Fingerprint,
+				Entry:       entry.CreatedAt,
+				Exit:        o.CreatedAt,
+				EntryPrice:  entry.Price,
+				ExitPrice:   o.Price,
+				Quantity:    o.Quantity,
+				Fees:        fees,
+				Profit:      profit,
+				Return:      profit / (entry.Price * o.Quantity),
+			})
+		}
+	}
+
+	sort.SliceStable(trips, func(i, j int) bool {
+		return trips[i].Exit.Before(trips[j].Exit)
+	})
+
+	return trips
+}
+
+// EquityCurve starts at the given capital and steps on every closed trade.
+func EquityCurve(trips []RoundTrip, capital float64, start time.Time) []EquityPoint {
+	curve := []EquityPoint{{start, capital}}
+	equity := capital
+
+	for _, rt := range trips {
+		equity += rt.Profit
+		curve = append(curve, EquityPoint{rt.Exit, equity})
+	}
+
+	return curve
+}
+
+// MaxDrawdown returns the deepest fall from a peak in percent, along with
+// how long it took to recover from it (or until the end of the curve).
+func MaxDrawdown(curve []EquityPoint) (float64, time.Duration) {
+	var maxDrawdown float64
+	var maxDuration time.Duration
+	if len(curve) == 0 {
+		return 0, 0
+	}
+
+	peak := curve[0]
+	underwater := false
+	for _, p := range curve {
+		if p.Equity >= peak.Equity {
+			if d := p.Time.Sub(peak.Time); underwater && d > maxDuration {
+				maxDuration = d
+			}
+			peak = p
+			underwater = false
+			continue
+		}
+
+		underwater = true
+		if dd := (peak.Equity - p.Equity) / peak.Equity * 100; dd > maxDrawdown {
+			maxDrawdown = dd
+		}
+	}
+
+	if d := curve[len(curve)-1].Time.Sub(peak.Time); underwater && d > maxDuration {
+		maxDuration = d
+	}
+
+	return maxDrawdown, maxDuration
+}
+
+// Sharpe is the mean trade return over its standard deviation. It isn't
+// annualized, so it's only comparable between runs over the same period.
+func Sharpe(returns []float64) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+
+	mean := Mean(returns)
+	std := math.Sqrt(variance(returns, mean))
+	if std == 0 {
+		return 0
+	}
+
+	return mean / std
+}
+
+func Returns(trips []RoundTrip) []float64 {
+	returns := make([]float64, len(trips))
+	for i, rt := range trips {
+		returns[i] = rt.Return
+	}
+
+	return returns
+}
+
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum / float64(len(values))
+}
+
+func variance(values []float64, mean float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+
+	return sum / float64(len(values)-1)
+}
+
+// Measure computes the metrics of trades closed over the period, on an
+// account starting with the default buy amount.
+func Measure(trips []RoundTrip, start, end time.Time) storage.Metrics {
+	m := storage.Metrics{Trades: len(trips)}
+	if len(trips) == 0 {
+		return m
+	}
+
+	curve := EquityCurve(trips, globals.BuyAmount, start)
+	m.MaxDrawdown, m.MaxDrawdownDuration = MaxDrawdown(curve)
+
+	returns := Returns(trips)
+	m.Sharpe = Sharpe(returns)
+	m.Sortino = Sortino(returns)
+
+	totalReturn := (curve[len(curve)-1].Equity/globals.BuyAmount - 1) * 100
+	if m.MaxDrawdown != 0 {
+		m.Calmar = totalReturn / m.MaxDrawdown
+	}
+
+	var grossProfit, grossLoss float64
+	var wins, losses, streak int
+	for _, rt := range trips {
+		if rt.Profit > 0 {
+			grossProfit += rt.Profit
+			wins++
+			streak = 0
+			continue
+		}
+
+		grossLoss -= rt.Profit
+		losses++
+		streak++
+		if streak > m.LongestLosingStreak {
+			m.LongestLosingStreak = streak
+		}
+	}
+
+	if grossLoss != 0 {
+		m.ProfitFactor = grossProfit / grossLoss
+	}
+	if wins != 0 {
+		m.AvgWin = grossProfit / float64(wins)
+	}
+	if losses != 0 {
+		m.AvgLoss = grossLoss / float64(losses)
+	}
+	m.Expectancy = (grossProfit - grossLoss) / float64(len(trips))
+	m.Exposure = Exposure(trips, start, end)
+
+	return m
+}
+
+// Sortino is like Sharpe, but only losing returns count as risk.
+func Sortino(returns []float64) float64 {
+	if len(returns) < 2 {
+		return 0
+	}
+
+	var sum float64
+	for _, r := range returns {
+		if r < 0 {
+			sum += r * r
+		}
+	}
+	downside := math.Sqrt(sum / float64(len(returns)))
+	if downside == 0 {
+		return 0
+	}
+
+	return Mean(returns) / downside
+}
+
+// Exposure is the percent of the period with at least one open position.
+// Overlapping trades of different symbols are counted once.
+func Exposure(trips []RoundTrip, start, end time.Time) float64 {
+	period := end.Sub(start)
+	if period <= 0 || len(trips) == 0 {
+		return 0
+	}
+
+	sorted := make([]RoundTrip, len(trips))
+	copy(sorted, trips)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Entry.Before(sorted[j].Entry)
+	})
+
+	var exposed time.Duration
+	from, to := sorted[0].Entry, sorted[0].Exit
+	for _, rt := range sorted[1:] {
+		if rt.Entry.After(to) {
+			exposed += to.Sub(from)
+			from, to = rt.Entry, rt.Exit
+			continue
+		}
+		if rt.Exit.After(to) {
+			to = rt.Exit
+		}
+	}
+	exposed += to.Sub(from)
+
+	return math.Min(float64(exposed)/float64(period)*100, 100)
+}
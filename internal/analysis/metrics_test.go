@@ -0,0 +1,81 @@
+package analysis_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+)
+
+func TestRoundTrips(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	orders := []storage.Order{
+		{Strategy: "-", Symbol: "-", Decision: globals.Sell, Quantity: 1, Price: 3, Successful: true, CreatedAt: start},
+		{Strategy: "-", Symbol: "-", Decision: globals.Buy, Quantity: 2, Price: 5, Successful: true, CreatedAt: start.Add(time.Minute)},
+		{Strategy: "-", Symbol: "-", Decision: globals.Hold, Successful: false, CreatedAt: start.Add(2 * time.Minute)},
+		{Strategy: "-", Symbol: "-", Decision: globals.Sell, Quantity: 2, Price: 6, Successful: true, CreatedAt: start.Add(3 * time.Minute)},
+		{Strategy: "-", Symbol: "-", Decision: globals.Buy, Quantity: 2, Price: 6, Successful: true, CreatedAt: start.Add(4 * time.Minute)},
+	}
+
+	got := analysis.RoundTrips(orders)
+
+	if len(got) != 1 {
+		t.Fatalf("expected one closed round trip, got %v", got)
+	}
+	if got[0].Profit != 2 || math.Abs(got[0].Return-0.2) > 1e-9 {
+		t.Errorf("wrong round trip, got %+v", got[0])
+	}
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	curve := []analysis.EquityPoint{
+		{start, 100},
+		{start.Add(time.Hour), 120},
+		{start.Add(2 * time.Hour), 90},
+		{start.Add(3 * time.Hour), 110},
+		{start.Add(4 * time.Hour), 125},
+		{start.Add(5 * time.Hour), 115},
+	}
+
+	drawdown, duration := analysis.MaxDrawdown(curve)
+
+	if drawdown != 25 {
+		t.Errorf("got %v want 25", drawdown)
+	}
+	if duration != 3*time.Hour {
+		t.Errorf("got %v want %v", duration, 3*time.Hour)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	trips := []analysis.RoundTrip{
+		{Entry: start, Exit: start.Add(time.Hour), Profit: 10, Return: 0.2},
+		{Entry: start.Add(time.Hour), Exit: start.Add(2 * time.Hour), Profit: -5, Return: -0.1},
+		{Entry: start.Add(3 * time.Hour), Exit: start.Add(4 * time.Hour), Profit: -5, Return: -0.1},
+		{Entry: start.Add(4 * time.Hour), Exit: start.Add(5 * time.Hour), Profit: 20, Return: 0.4},
+	}
+
+	got := analysis.Measure(trips, start, start.Add(10*time.Hour))
+
+	if got.Trades != 4 || got.LongestLosingStreak != 2 {
+		t.Errorf("wrong trade counts, got %+v", got)
+	}
+	if got.ProfitFactor != 3 || got.AvgWin != 15 || got.AvgLoss != 5 || got.Expectancy != 5 {
+		t.Errorf("wrong trade averages, got %+v", got)
+	}
+	if got.Exposure != 40 {
+		t.Errorf("expected 40%% exposure, got %v", got.Exposure)
+	}
+	// 60 -> 50 is the deepest fall, and the account got back to 60 on the last trade
+	if math.Abs(got.MaxDrawdown-100.0/6) > 1e-9 || got.MaxDrawdownDuration != 4*time.Hour {
+		t.Errorf("wrong drawdown, got %v %v", got.MaxDrawdown, got.MaxDrawdownDuration)
+	}
+	if got.Sortino <= got.Sharpe || got.Calmar <= 0 {
+		t.Errorf("expected positive ratios with sortino above sharpe, got %+v", got)
+	}
+}
@@ -1,23 +1,25 @@
 package backtest
 
 import (
-	"encoding/csv"
-	"fmt"
-	"os"
+	"io"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
 	"github.com/ws396/autobinance/internal/analysis"
-	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/candles"
 	"github.com/ws396/autobinance/internal/globals"
-	"github.com/ws396/autobinance/internal/output"
 	"github.com/ws396/autobinance/internal/storage"
-	"github.com/ws396/autobinance/internal/trader"
 	"github.com/ws396/autobinance/internal/util"
 )
 
-func Backtest(input string, settings map[string]storage.Setting) (map[string]storage.Analysis, error) {
+// Input is the period optionally followed by a gap policy, exit options and
+// the timeframe of strategies, ex. "01-02-2021 30-03-2021 ffill sl:2 tp:4 limit:0.5 path:ohlc tf:1h lean".
+// The klines are streamed from disk, and "lean" keeps them out of the result too.
+// "evals" keeps the holds and rejected orders, for reports to draw the
+// indicators of every candle.
+func Backtest(input string, settings map[string]storage.Setting) (*Result, error) {
 	if !globals.SimulationMode {
 		return nil, globals.ErrNotInSimulationMode
 	}
@@ -25,89 +27,186 @@ func Backtest(input string, settings map[string]storage.Setting) (map[string]sto
 		return nil, globals.ErrSymbolsNotFound
 	}
 
-	klinesFeed := map[string][]*binance.Kline{}
-	start, end, err := util.ExtractTimepoints(input)
+	args := strings.Split(input, " ")
+	period := input
+	if len(args) > 2 {
+		period = strings.Join(args[:2], " ")
+	}
+	start, end, err := util.ExtractTimepoints(period)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, s := range settings["selected_symbols"].ValueArr {
-		path := fmt.Sprintf(
-			"%s%s_%s_%s_%s.csv",
-			globals.BacktestDataDir,
-			s,
-			globals.Timeframe,
-			start.Format("02-01-2006"),
-			end.Format("02-01-2006"),
-		)
-		f, err := os.Open(path)
+	config := Config{
+		Start:      start,
+		End:        end,
+		Symbols:    settings["selected_symbols"].ValueArr,
+		Strategies: settings["selected_strategies"].ValueArr,
+		Timeframe:  globals.Timeframe,
+		WindowSize: DefaultWindowSize,
+	}
+	if len(args) > 2 {
+		err = parseOptions(args[2:], &config)
 		if err != nil {
 			return nil, err
 		}
-		defer f.Close()
+	}
+
+	config.Fees, config.Slippage, err = CostsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	sources, err := OpenFeed(config.Symbols, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFeed(sources)
+
+	config.Intrabar, err = OpenIntrabar(config.Symbols, config.Timeframe, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFeed(config.Intrabar)
 
-		reader := csv.NewReader(f)
-		records, err := reader.ReadAll()
+	config.AggTrades, config.Funding, err = OpenDatasets(config.Symbols, config.Strategies, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDatasets(config.AggTrades, config.Funding)
+
+	config.Benchmark = analysis.BenchmarkSymbol()
+	if config.Benchmark != "" && !util.Contains(config.Symbols, config.Benchmark) && !config.Lean {
+		config.BenchmarkKlines, err = loadBenchmark(config.Benchmark, config.Timeframe, start, end)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	return NewStreamEngine(config, sources).Run()
+}
 
-		klines := []*binance.Kline{}
-		for _, record := range records {
-			openTime, _ := strconv.ParseInt(record[0], 10, 64)
-			closeTime, _ := strconv.ParseInt(record[6], 10, 64)
-			tradeNum, _ := strconv.ParseInt(record[8], 10, 64)
-			kline := &binance.Kline{
-				OpenTime:                 openTime,
-				Open:                     record[1],
-				High:                     record[2],
-				Low:                      record[3],
-				Close:                    record[4],
-				Volume:                   record[5],
-				CloseTime:                closeTime,
-				QuoteAssetVolume:         record[7],
-				TradeNum:                 tradeNum,
-				TakerBuyBaseAssetVolume:  record[9],
-				TakerBuyQuoteAssetVolume: record[10],
+func parseOptions(args []string, config *Config) error {
+	for _, arg := range args {
+		kv := strings.Split(arg, ":")
+		if arg == "lean" {
+			config.Lean = true
+			continue
+		}
+		if arg == "evals" {
+			config.RecordEvaluations = true
+			continue
+		}
+		if len(kv) == 1 {
+			policy, err := ParseGapPolicy(arg)
+			if err != nil {
+				return err
 			}
-			klines = append(klines, kline)
+			config.GapPolicy = policy
+			continue
+		}
+		if len(kv) != 2 {
+			return globals.ErrWrongBacktestOption
 		}
 
-		klinesFeed[s] = klines
-	}
+		// Every strategy runs on the timeframe, resampled from the base klines
+		if kv[0] == "tf" {
+			if _, ok := globals.Durations[kv[1]]; !ok {
+				return globals.ErrWrongTimeframe
+			}
+			config.Timeframes = map[string]string{}
+			for _, s := range config.Strategies {
+				config.Timeframes[s] = kv[1]
+			}
+			continue
+		}
+
+		if kv[0] == "path" {
+			mode, err := ParsePathMode(kv[1])
+			if err != nil {
+				return err
+			}
+			config.Path = mode
+			continue
+		}
 
-	batchLimit := 60
-	btExchangeClient := binancew.NewClientBacktest(start, end, klinesFeed, batchLimit)
-	btStorageClient := storage.NewInMemoryClient()
-	tickerChan := make(chan time.Time)
-	btTrader := trader.Trader{
-		StorageClient:  btStorageClient,
-		ExchangeClient: btExchangeClient,
-		Settings:       settings,
-		TickerChan:     tickerChan,
+		v, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || v < 0 {
+			return globals.ErrWrongBacktestOption
+		}
+		switch kv[0] {
+		case "sl":
+			config.StopLoss = v
+		case "tp":
+			config.TakeProfit = v
+		case "limit":
+			config.LimitOffset = v
+		default:
+			return globals.ErrWrongBacktestOption
+		}
 	}
-	w, err := output.NewWriterCreator().CreateWriter(output.Stub)
-	if err != nil {
-		return nil, err
+
+	return nil
+}
+
+// LoadFeed reads the klines downloaded for the period of every symbol. The
+// end date is inclusive, like in downloads.
+func LoadFeed(symbols []string, start, end time.Time) (map[string][]*binance.Kline, error) {
+	feed := map[string][]*binance.Kline{}
+	for _, s := range symbols {
+		var err error
+		feed[s], err = LoadKlines(s, globals.Timeframe, start, end)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	errChan := btTrader.StartTradingSession(w)
-	klinesLen := len(klinesFeed[settings["selected_symbols"].ValueArr[0]])
-	for i := 0; i < klinesLen-batchLimit; i++ {
-		tickerChan <- time.Now()
-		if err := <-errChan; err != nil {
-			btTrader.StopTradingSession()
+	return feed, nil
+}
+
+// OpenFeed opens the klines downloaded for the period of every symbol, to be
+// read as the backtest goes.
+func OpenFeed(symbols []string, start, end time.Time) (map[string]candles.KlineIterator, error) {
+	store := candles.DefaultStore()
+	sources := map[string]candles.KlineIterator{}
+	for _, s := range symbols {
+		cursor, err := store.Range(s, globals.Timeframe, start, end.Add(24*time.Hour))
+		if err != nil {
+			closeFeed(sources)
 			return nil, err
 		}
+		sources[s] = candles.Klines(cursor)
+	}
+
+	return sources, nil
+}
+
+func closeFeed(sources map[string]candles.KlineIterator) {
+	for _, it := range sources {
+		if c, ok := it.(io.Closer); ok {
+			c.Close()
+		}
 	}
+}
 
-	foundOrders, err := btStorageClient.GetAllOrders()
+// loadBenchmark reads the klines of the benchmark downsampled like the
+// replayed ones.
+func loadBenchmark(symbol, timeframe string, start, end time.Time) ([]*binance.Kline, error) {
+	cursor, err := candles.DefaultStore().Range(symbol, timeframe, start, end.Add(24*time.Hour))
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close()
+
+	return Downsample(candles.Klines(cursor), globals.Durations[timeframe])
+}
 
-	binancew.BacktestIndex = 0
-	analyses := analysis.CreateAnalyses(foundOrders, start, end)
+func LoadKlines(symbol, timeframe string, start, end time.Time) ([]*binance.Kline, error) {
+	cursor, err := candles.DefaultStore().Range(symbol, timeframe, start, end.Add(24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
 
-	return analyses, nil
+	return candles.Collect(candles.Klines(cursor))
 }
@@ -0,0 +1,216 @@
+package backtest
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// Binance spot rates without VIP levels
+const (
+	DefaultMakerFee    = 0.1
+	DefaultTakerFee    = 0.1
+	DefaultBNBDiscount = 25
+)
+
+// Rates are in percent of the order's notional.
+type Rates struct {
+	Maker float64 `json:"maker"`
+	Taker float64 `json:"taker"`
+}
+
+type Fees struct {
+	Rates
+	// Percent taken off the fees when they're paid in BNB
+	BNBDiscount float64 `json:"bnbDiscount"`
+	PayInBNB    bool    `json:"payInBNB"`
+	// Symbols with their own rates, ex. zero fee promotions
+	Overrides map[string]Rates `json:"overrides"`
+}
+
+// Fee is in the quote asset. Market orders take liquidity, resting orders make it.
+func (f Fees) Fee(symbol string, notional float64, maker bool) float64 {
+	rates := f.Rates
+	if r, ok := f.Overrides[symbol]; ok {
+		rates = r
+	}
+
+	rate := rates.Taker
+	if maker {
+		rate = rates.Maker
+	}
+	if f.PayInBNB {
+		rate *= 1 - f.BNBDiscount/100
+	}
+
+	return notional * rate / 100
+}
+
+// String describes the fee model, ex. "maker 0.1% taker 0.1% in BNB -25% BTCUSDT 0%/0%".
+func (f Fees) String() string {
+	s := fmt.Sprintf("maker %v%% taker %v%%", f.Maker, f.Taker)
+	if f.PayInBNB {
+		s += fmt.Sprintf(" in BNB -%v%%", f.BNBDiscount)
+	}
+
+	symbols := []string{}
+	for k := range f.Overrides {
+		symbols = append(symbols, k)
+	}
+	sort.Strings(symbols)
+	for _, k := range symbols {
+		s += fmt.Sprintf(" %s %v%%/%v%%", k, f.Overrides[k].Maker, f.Overrides[k].Taker)
+	}
+
+	return s
+}
+
+// Slippage is how far the fill price moves against the order, per unit.
+// Models print the way ParseSlippage reads them.
+type Slippage interface {
+	Slip(price, quantity float64, candle *binance.Kline) float64
+	String() string
+}
+
+// FixedBps slips every fill by the same basis points of the price.
+type FixedBps float64
+
+func (s FixedBps) Slip(price, quantity float64, candle *binance.Kline) float64 {
+	return price * float64(s) / 10000
+}
+
+func (s FixedBps) String() string {
+	return fmt.Sprint("bps:", float64(s))
+}
+
+// RangePercent slips by a percent of the candle's high-low range, so
+// volatile candles cost more.
+type RangePercent float64
+
+func (s RangePercent) Slip(price, quantity float64, candle *binance.Kline) float64 {
+	high, _ := strconv.ParseFloat(candle.High, 64)
+	low, _ := strconv.ParseFloat(candle.Low, 64)
+
+	return (high - low) * float64(s) / 100
+}
+
+func (s RangePercent) String() string {
+	return fmt.Sprint("range:", float64(s))
+}
+
+// VolumeImpact grows with the square root of the order's share of the candle
+// volume, scaled by the coefficient. Empty candles get the full coefficient.
+type VolumeImpact float64
+
+func (s VolumeImpact) Slip(price, quantity float64, candle *binance.Kline) float64 {
+	volume, _ := strconv.ParseFloat(candle.Volume, 64)
+	share := 1.0
+	if volume > 0 {
+		share = math.Min(quantity/volume, 1)
+	}
+
+	return price * float64(s) * math.Sqrt(share)
+}
+
+func (s VolumeImpact) String() string {
+	return fmt.Sprint("volume:", float64(s))
+}
+
+// ParseSlippage reads a model like "bps:5", "range:10" or "volume:0.1".
+// Empty input means no slippage.
+func ParseSlippage(s string) (Slippage, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	args := strings.Split(s, ":")
+	if len(args) != 2 {
+		return nil, globals.ErrWrongSlippageModel
+	}
+	v, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || v < 0 {
+		return nil, globals.ErrWrongSlippageModel
+	}
+
+	switch args[0] {
+	case "bps":
+		return FixedBps(v), nil
+	case "range":
+		return RangePercent(v), nil
+	case "volume":
+		return VolumeImpact(v), nil
+	default:
+		return nil, globals.ErrWrongSlippageModel
+	}
+}
+
+// ParseFeeOverrides reads per symbol rates like "BTCUSDT:0:0 ETHUSDT:0.02:0.04",
+// where the maker rate goes first.
+func ParseFeeOverrides(s string) (map[string]Rates, error) {
+	overrides := map[string]Rates{}
+	for _, o := range strings.Fields(s) {
+		args := strings.Split(o, ":")
+		if len(args) != 3 {
+			return nil, globals.ErrWrongFeeOverride
+		}
+
+		maker, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return nil, globals.ErrWrongFeeOverride
+		}
+		taker, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return nil, globals.ErrWrongFeeOverride
+		}
+
+		overrides[strings.ToUpper(args[0])] = Rates{maker, taker}
+	}
+
+	return overrides, nil
+}
+
+// CostsFromEnv reads the fee and slippage models of backtests from .env,
+// falling back to the default Binance rates.
+func CostsFromEnv() (Fees, Slippage, error) {
+	fees := Fees{
+		Rates:       Rates{DefaultMakerFee, DefaultTakerFee},
+		BNBDiscount: DefaultBNBDiscount,
+		PayInBNB:    os.Getenv("BACKTEST_FEES_IN_BNB") == "true",
+	}
+
+	for env, v := range map[string]*float64{
+		"BACKTEST_MAKER_FEE":    &fees.Maker,
+		"BACKTEST_TAKER_FEE":    &fees.Taker,
+		"BACKTEST_BNB_DISCOUNT": &fees.BNBDiscount,
+	} {
+		s := os.Getenv(env)
+		if s == "" {
+			continue
+		}
+
+		var err error
+		*v, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			return Fees{}, nil, err
+		}
+	}
+
+	var err error
+	fees.Overrides, err = ParseFeeOverrides(os.Getenv("BACKTEST_FEE_OVERRIDES"))
+	if err != nil {
+		return Fees{}, nil, err
+	}
+
+	slippage, err := ParseSlippage(os.Getenv("BACKTEST_SLIPPAGE"))
+	if err != nil {
+		return Fees{}, nil, err
+	}
+
+	return fees, slippage, nil
+}
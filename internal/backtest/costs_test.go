@@ -0,0 +1,62 @@
+package backtest_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+)
+
+func TestFees(t *testing.T) {
+	overrides, err := backtest.ParseFeeOverrides("btcusdt:0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fees := backtest.Fees{
+		Rates:       backtest.Rates{Maker: 0.1, Taker: 0.2},
+		BNBDiscount: 25,
+		Overrides:   overrides,
+	}
+
+	if got := fees.Fee("LTCBTC", 100, false); math.Abs(got-0.2) > 1e-9 {
+		t.Errorf("expected taker fee of 0.2, got %v", got)
+	}
+	if got := fees.Fee("LTCBTC", 100, true); math.Abs(got-0.1) > 1e-9 {
+		t.Errorf("expected maker fee of 0.1, got %v", got)
+	}
+	if got := fees.Fee("BTCUSDT", 100, false); got != 0 {
+		t.Errorf("expected override to remove the fee, got %v", got)
+	}
+
+	fees.PayInBNB = true
+	if got := fees.Fee("LTCBTC", 100, false); math.Abs(got-0.15) > 1e-9 {
+		t.Errorf("expected discounted fee of 0.15, got %v", got)
+	}
+}
+
+func TestSlippage(t *testing.T) {
+	candle := &binance.Kline{High: "110", Low: "100", Volume: "400"}
+	tests := map[string]float64{
+		"bps:10":     0.1,
+		"range:10":   1,
+		"volume:0.1": 5,
+	}
+
+	for s, want := range tests {
+		model, err := backtest.ParseSlippage(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := model.Slip(100, 100, candle); math.Abs(got-want) > 1e-9 {
+			t.Errorf("%v: got %v want %v", s, got, want)
+		}
+	}
+
+	for _, s := range []string{"bps", "bps:-1", "spread:1"} {
+		if _, err := backtest.ParseSlippage(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package backtest
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ws396/autobinance/internal/datasets"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/strategies"
+)
+
+// stream reads the records of a symbol as the replay gets to them, so only
+// the ones of a single candle are held at a time.
+type stream[T any] struct {
+	it   datasets.Iterator[T]
+	time func(T) int64
+	next *T
+	done bool
+}
+
+// until returns the records from before the time that haven't been read
+// yet. Times have to be asked for in order.
+func (s *stream[T]) until(t int64) ([]T, error) {
+	records := []T{}
+	for {
+		if s.next == nil {
+			if s.done {
+				return records, nil
+			}
+			next, err := s.it.Next()
+			if err == io.EOF {
+				s.done = true
+				return records, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			s.next = &next
+		}
+
+		if s.time(*s.next) >= t {
+			return records, nil
+		}
+		records = append(records, *s.next)
+		s.next = nil
+	}
+}
+
+// OpenDatasets opens the aggTrades and funding rates the strategies
+// declared, to be read as the backtest goes. Symbols that don't have them
+// downloaded for the period are left out.
+func OpenDatasets(symbols, names []string, start, end time.Time) (map[datasets.Dataset]map[string]datasets.Iterator[datasets.AggTrade], map[string]datasets.Iterator[datasets.FundingRate], error) {
+	trades := map[datasets.Dataset]map[string]datasets.Iterator[datasets.AggTrade]{}
+	funding := map[string]datasets.Iterator[datasets.FundingRate]{}
+	fundingOpen := false
+	end = end.Add(24 * time.Hour)
+
+	for _, name := range names {
+		info := strategies.StrategiesInfo[name]
+		if d, ok := info.AggTrades(); ok && trades[d] == nil {
+			trades[d] = map[string]datasets.Iterator[datasets.AggTrade]{}
+			for _, s := range symbols {
+				records, err := datasets.OpenAggTrades(d, s, start, end)
+				if errors.Is(err, globals.ErrDatasetNotFound) {
+					continue
+				}
+				if err != nil {
+					closeDatasets(trades, funding)
+					return nil, nil, err
+				}
+				trades[d][s] = records
+			}
+		}
+
+		if info.Funding() && !fundingOpen {
+			fundingOpen = true
+			for _, s := range symbols {
+				records, err := datasets.OpenFundingRates(s, start, end)
+				if errors.Is(err, globals.ErrDatasetNotFound) {
+					continue
+				}
+				if err != nil {
+					closeDatasets(trades, funding)
+					return nil, nil, err
+				}
+				funding[s] = records
+			}
+		}
+	}
+
+	return trades, funding, nil
+}
+
+func closeDatasets(trades map[datasets.Dataset]map[string]datasets.Iterator[datasets.AggTrade], funding map[string]datasets.Iterator[datasets.FundingRate]) {
+	for _, symbols := range trades {
+		for _, it := range symbols {
+			if c, ok := it.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}
+	for _, it := range funding {
+		if c, ok := it.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package backtest
+
+import (
+	"io"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/techanext"
+)
+
+// MaxKlines of a symbol are kept for charts and benchmarks, about as many as
+// reports draw.
+const MaxKlines = 5000
+
+// downsampler keeps the candles of a symbol as they're replayed. Once they'd
+// go over MaxKlines, its timeframe doubles and what's kept is resampled to
+// it, so memory stays bounded however long the run is.
+type downsampler struct {
+	timeframe time.Duration
+	klines    []*binance.Kline
+	resampler *techanext.Resampler
+}
+
+func newDownsampler(timeframe time.Duration) *downsampler {
+	return &downsampler{
+		timeframe: timeframe,
+		klines:    []*binance.Kline{},
+		resampler: techanext.NewResampler(timeframe, techanext.KeepPartial),
+	}
+}
+
+func (d *downsampler) add(k *binance.Kline) {
+	d.klines = append(d.klines, d.resampler.Add(k)...)
+	// Only done in between buckets, a bucket cut short would close the
+	// doubled one before the rest of its candles come in
+	if len(d.klines) < MaxKlines || d.resampler.Forming() != nil {
+		return
+	}
+
+	// Buckets are aligned to the epoch, so the ones of the doubled timeframe
+	// are made of whole ones of the current timeframe
+	d.timeframe *= 2
+	kept := d.klines
+	d.klines = []*binance.Kline{}
+	d.resampler = techanext.NewResampler(d.timeframe, techanext.KeepPartial)
+	for _, k := range kept {
+		d.klines = append(d.klines, d.resampler.Add(k)...)
+	}
+}
+
+// Klines kept so far, with the one still forming last.
+func (d *downsampler) Klines() []*binance.Kline {
+	if forming := d.resampler.Forming(); forming != nil {
+		return append(d.klines[:len(d.klines):len(d.klines)], forming)
+	}
+
+	return d.klines
+}
+
+// Downsample reads the klines of the timeframe into at most MaxKlines.
+func Downsample(it candles.KlineIterator, timeframe time.Duration) ([]*binance.Kline, error) {
+	d := newDownsampler(timeframe)
+	for {
+		k, err := it.Next()
+		if err == io.EOF {
+			return d.Klines(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.add(k)
+	}
+}
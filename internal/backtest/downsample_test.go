@@ -0,0 +1,40 @@
+package backtest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestDownsample(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	klines := testutil.MockKlines(start, testutil.WaveCloses(3*MaxKlines+7, 50)...)
+
+	got, err := Downsample(candles.Slice(klines), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) > MaxKlines {
+		t.Fatalf("expected at most %v klines, got %v", MaxKlines, len(got))
+	}
+	var volume float64
+	for i, k := range got {
+		if i > 0 && k.OpenTime <= got[i-1].OpenTime {
+			t.Fatalf("expected klines in time order, got %v after %v", k.OpenTime, got[i-1].OpenTime)
+		}
+		v, _ := strconv.ParseFloat(k.Volume, 64)
+		volume += v
+	}
+
+	last := klines[len(klines)-1]
+	if got[0].OpenTime != klines[0].OpenTime || got[0].Open != klines[0].Open || got[len(got)-1].Close != last.Close {
+		t.Errorf("expected downsampled klines to span the replayed ones, got %+v to %+v", got[0], got[len(got)-1])
+	}
+	if volume != float64(10*len(klines)) {
+		t.Errorf("expected every kline to be merged once, got volume %v", volume)
+	}
+}
@@ -0,0 +1,622 @@
+package backtest
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/sdcoffey/techan"
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/clock"
+	"github.com/ws396/autobinance/internal/datasets"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/strategies"
+	"github.com/ws396/autobinance/internal/techanext"
+	"github.com/ws396/autobinance/internal/trader"
+	"github.com/ws396/autobinance/internal/util"
+)
+
+const DefaultWindowSize = 60
+
+type Config struct {
+	Start      time.Time
+	End        time.Time
+	Symbols    []string
+	Strategies []string
+	// Base timeframe of the feed, which strategies on other timeframes get
+	// their candles resampled from
+	Timeframe string
+	// Per strategy overrides of the registered timeframes
+	Timeframes map[string]string
+	// Amount of candles handed to strategies on every evaluation
+	WindowSize int
+	GapPolicy  GapPolicy
+	// Per strategy overrides of the registered params
+	Params map[string]strategies.Params
+	// Orders fill at the next candle's open, with these costs on top
+	Fees     Fees
+	Slippage Slippage
+	// Every buy gets a stop and a take profit these percents away from its fill.
+	// Zero leaves them out
+	StopLoss   float64
+	TakeProfit float64
+	// Buys rest as limit orders this percent under the decision price for one candle
+	LimitOffset float64
+	// How resting orders find their way through a candle, unless there's
+	// lower timeframe data for it in Intrabar
+	Path PathMode
+	// Lower timeframe candles of the symbols in time order, read as the
+	// replay gets to them
+	Intrabar map[string]candles.KlineIterator
+	// Aggregated trades, by dataset, and funding rates of the symbols in time
+	// order, read as the replay gets to them for the strategies that
+	// declared them
+	AggTrades map[datasets.Dataset]map[string]datasets.Iterator[datasets.AggTrade]
+	Funding   map[string]datasets.Iterator[datasets.FundingRate]
+	// Keep holds and rejected orders too, for their indicators
+	RecordEvaluations bool
+	// Symbol analyses are compared against, next to buy-and-hold of their own
+	// symbol and the basket of Symbols. Its klines are only needed when it
+	// isn't one of Symbols, and can be downsampled like the replayed ones
+	Benchmark       string
+	BenchmarkKlines []*binance.Kline
+	// Lean runs keep neither the replayed candles nor holds. They go without
+	// benchmarks and charts
+	Lean bool
+}
+
+// Event is a closed candle. Events are replayed in the order they close.
+type Event struct {
+	Time   time.Time
+	Symbol string
+	Kline  *binance.Kline
+	// Following candle of the symbol, which orders get filled on
+	Next *binance.Kline
+}
+
+type Result struct {
+	Config         Config
+	Orders         []storage.Order
+	AmbiguousFills int
+	// Candles that were replayed, after the gap policy. Long runs have them
+	// downsampled to at most MaxKlines per symbol
+	Klines   map[string][]*binance.Kline
+	Analyses map[string]storage.Analysis
+	// All strategies and symbols together
+	Portfolio storage.Analysis
+	Coverage  map[string]Coverage
+	// Wall time the replay took
+	Duration time.Duration
+}
+
+// Engine replays klines candle by candle through Trader.Trade. All of its
+// state is local, so engines can run concurrently.
+type Engine struct {
+	config   Config
+	sources  map[string]candles.KlineIterator
+	clock    *clock.Simulated
+	storage  *storage.InMemoryClient
+	exchange *exchange
+	trader   *trader.Trader
+	// Timeframe of every strategy, and every timeframe above the base one
+	// that strategies run on or look at
+	timeframes map[string]string
+	resampled  []string
+	// Candles of every symbol and timeframe, and the resamplers building them
+	windows    map[string][]*binance.Kline
+	resamplers map[string]*techanext.Resampler
+	// Replayed candles of every symbol, kept for charts and benchmarks
+	klines   map[string]*downsampler
+	intrabar map[string]*intrabar
+	// Trades of the candle forming at every timeframe strategies reading
+	// them run on, and the funding rates settled so far, of every symbol
+	aggTrades       map[datasets.Dataset]map[string]*stream[datasets.AggTrade]
+	tradeTimeframes map[datasets.Dataset][]string
+	trades          map[string][]datasets.AggTrade
+	fundingRates    map[string]*stream[datasets.FundingRate]
+	funding         map[string][]datasets.FundingRate
+	// Resting orders of every strategy and symbol
+	resting map[string]*resting
+}
+
+type resting struct {
+	strategy    string
+	symbol      string
+	fingerprint string
+	orders      []RestingOrder
+}
+
+func NewEngine(config Config, feed map[string][]*binance.Kline) *Engine {
+	sources := map[string]candles.KlineIterator{}
+	for s, klines := range feed {
+		sources[s] = candles.Slice(klines)
+	}
+
+	return NewStreamEngine(config, sources)
+}
+
+// NewStreamEngine replays candles as they're read from the sources, which
+// have to be in time order.
+func NewStreamEngine(config Config, sources map[string]candles.KlineIterator) *Engine {
+	if config.Timeframe == "" {
+		config.Timeframe = globals.Timeframe
+	}
+	if config.WindowSize == 0 {
+		config.WindowSize = DefaultWindowSize
+	}
+	if config.GapPolicy == "" {
+		config.GapPolicy = Skip
+	}
+	if config.Lean {
+		config.RecordEvaluations = false
+	}
+
+	c := clock.NewSimulated(config.Start)
+	s := storage.NewInMemoryClient()
+	ex := newExchange(config.Fees, config.Slippage, config.LimitOffset)
+
+	timeframes := map[string]string{}
+	for _, s := range config.Strategies {
+		timeframes[s] = config.Timeframe
+		if tf := strategies.StrategiesInfo[s].Timeframe; tf != "" {
+			timeframes[s] = tf
+		}
+		if tf := config.Timeframes[s]; tf != "" {
+			timeframes[s] = tf
+		}
+	}
+	// Results tell what every strategy actually ran on
+	config.Timeframes = timeframes
+
+	resampled := []string{}
+	for _, s := range config.Strategies {
+		for _, tf := range append([]string{timeframes[s]}, strategies.StrategiesInfo[s].Timeframes...) {
+			if tf != config.Timeframe && !util.Contains(resampled, tf) {
+				resampled = append(resampled, tf)
+			}
+		}
+	}
+
+	lower := map[string]*intrabar{}
+	for s, it := range config.Intrabar {
+		lower[s] = &intrabar{it: it}
+	}
+
+	aggTrades := map[datasets.Dataset]map[string]*stream[datasets.AggTrade]{}
+	tradeTimeframes := map[datasets.Dataset][]string{}
+	for _, s := range config.Strategies {
+		d, ok := strategies.StrategiesInfo[s].AggTrades()
+		if !ok {
+			continue
+		}
+		if !util.Contains(tradeTimeframes[d], timeframes[s]) {
+			tradeTimeframes[d] = append(tradeTimeframes[d], timeframes[s])
+		}
+		if aggTrades[d] != nil {
+			continue
+		}
+		aggTrades[d] = map[string]*stream[datasets.AggTrade]{}
+		for symbol, it := range config.AggTrades[d] {
+			aggTrades[d][symbol] = &stream[datasets.AggTrade]{it: it, time: func(t datasets.AggTrade) int64 { return t.Time }}
+		}
+	}
+	fundingRates := map[string]*stream[datasets.FundingRate]{}
+	for symbol, it := range config.Funding {
+		fundingRates[symbol] = &stream[datasets.FundingRate]{it: it, time: func(r datasets.FundingRate) int64 { return r.Time }}
+	}
+
+	return &Engine{
+		config:   config,
+		sources:  sources,
+		clock:    c,
+		storage:  s,
+		exchange: ex,
+		trader: &trader.Trader{
+			StorageClient:     s,
+			ExchangeClient:    ex,
+			Settings:          map[string]storage.Setting{},
+			Params:            config.Params,
+			Timeframes:        timeframes,
+			Clock:             c,
+			RecordEvaluations: config.RecordEvaluations,
+		},
+		timeframes: timeframes,
+		resampled:  resampled,
+		windows:    map[string][]*binance.Kline{},
+		resamplers: map[string]*techanext.Resampler{},
+		resting:    map[string]*resting{},
+		klines:     map[string]*downsampler{},
+		intrabar:   lower,
+
+		aggTrades:       aggTrades,
+		tradeTimeframes: tradeTimeframes,
+		trades:          map[string][]datasets.AggTrade{},
+		fundingRates:    fundingRates,
+		funding:         map[string][]datasets.FundingRate{},
+	}
+}
+
+func (e *Engine) Run() (*Result, error) {
+	if len(e.config.Symbols) == 0 {
+		return nil, globals.ErrSymbolsNotFound
+	}
+	if len(e.config.Strategies) == 0 {
+		return nil, globals.ErrStrategiesNotFound
+	}
+	for _, s := range e.config.Strategies {
+		if strategies.StrategiesInfo[s].External {
+			return nil, globals.ErrNoSignalSource
+		}
+	}
+	for _, tf := range e.resampled {
+		if !techanext.IsMultiple(globals.Durations[tf], globals.Durations[e.config.Timeframe]) {
+			return nil, globals.ErrWrongTimeframe
+		}
+	}
+
+	started := time.Now()
+	events, err := newEvents(e.config.Symbols, e.sources, globals.Durations[e.config.Timeframe], e.config.GapPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if !e.config.Lean {
+		for _, s := range e.config.Symbols {
+			e.klines[s] = newDownsampler(globals.Durations[e.config.Timeframe])
+		}
+	}
+
+	for {
+		ev, err := events.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !e.config.Lean {
+			e.klines[ev.Symbol].add(ev.Kline)
+		}
+
+		err = e.handle(ev)
+		if err != nil {
+			return nil, err
+		}
+	}
+	coverage := events.coverage()
+
+	orders, err := e.storage.GetAllOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	var ambiguous int
+	for _, o := range orders {
+		if o.Ambiguous {
+			ambiguous++
+		}
+	}
+
+	klines := map[string][]*binance.Kline{}
+	for s, d := range e.klines {
+		klines[s] = d.Klines()
+	}
+	benchmarks := e.benchmarks(klines)
+	analyses := analysis.CreateAnalyses(orders, e.config.Start, e.config.End)
+	benchmarks.AddBenchmarks(analyses, orders)
+	portfolio := analysis.PortfolioAnalysis(orders, e.config.Start, e.config.End)
+	portfolio.Benchmarks = benchmarks.Compare(portfolio, analysis.RoundTrips(orders))
+
+	return &Result{
+		Config:         e.config,
+		Orders:         orders,
+		AmbiguousFills: ambiguous,
+		Klines:         klines,
+		Analyses:       analyses,
+		Portfolio:      portfolio,
+		Coverage:       coverage,
+		Duration:       time.Since(started),
+	}, nil
+}
+
+// benchmarks are held over the replayed candles.
+func (e *Engine) benchmarks(klines map[string][]*binance.Kline) analysis.Benchmarks {
+	b := analysis.Benchmarks{
+		Klines: map[string][]*binance.Kline{},
+		Basket: e.config.Symbols,
+		Symbol: e.config.Benchmark,
+	}
+	for s, k := range klines {
+		b.Klines[s] = k
+	}
+	if _, ok := b.Klines[b.Symbol]; !ok && b.Symbol != "" {
+		b.Klines[b.Symbol] = e.config.BenchmarkKlines
+	}
+
+	return b
+}
+
+func (e *Engine) handle(ev Event) error {
+	e.clock.Set(ev.Time)
+	e.exchange.next[ev.Symbol] = ev.Next
+
+	err := e.fillResting(ev)
+	if err != nil {
+		return err
+	}
+
+	closed := e.resample(ev)
+	err = e.readDatasets(ev)
+	if err != nil {
+		return err
+	}
+	defer e.resetTrades(ev.Symbol, closed)
+
+	// Candles closing before the start only warm the windows up
+	if ev.Time.Before(e.config.Start) {
+		return nil
+	}
+
+	series := map[string]*techan.TimeSeries{}
+	seriesOf := func(tf string) *techan.TimeSeries {
+		if series[tf] == nil {
+			series[tf] = techanext.GetSeries(e.windows[ev.Symbol+"_"+tf], globals.Durations[tf])
+		}
+		return series[tf]
+	}
+
+	for _, strategy := range e.config.Strategies {
+		tf := e.timeframes[strategy]
+		if !closed[tf] || len(e.windows[ev.Symbol+"_"+tf]) < e.config.WindowSize {
+			continue
+		}
+
+		// Higher timeframes only hold the buckets closed by now
+		bundle := strategies.Bundle{Series: seriesOf(tf), Higher: map[string]*techan.TimeSeries{}}
+		info := strategies.StrategiesInfo[strategy]
+		for _, higher := range info.Timeframes {
+			bundle.Higher[higher] = seriesOf(higher)
+		}
+		if d, ok := info.AggTrades(); ok {
+			bundle.Trades = e.candleTrades(d, ev.Symbol, tf)
+		}
+		if info.Funding() {
+			rates := e.funding[ev.Symbol]
+			bundle.Funding = rates[:len(rates):len(rates)]
+		}
+
+		order, err := e.trader.TradeBundle(strategy, ev.Symbol, bundle)
+		if err != nil {
+			return err
+		}
+
+		e.placeResting(order)
+	}
+
+	return nil
+}
+
+// readDatasets reads the trades and funding rates of the symbol up to the
+// end of the candle.
+func (e *Engine) readDatasets(ev Event) error {
+	end := ev.Kline.CloseTime + 1
+	for d, streams := range e.aggTrades {
+		s, ok := streams[ev.Symbol]
+		if !ok {
+			continue
+		}
+		trades, err := s.until(end)
+		if err != nil {
+			return err
+		}
+		for _, tf := range e.tradeTimeframes[d] {
+			k := tradesKey(d, ev.Symbol, tf)
+			e.trades[k] = append(e.trades[k], trades...)
+		}
+	}
+
+	if s, ok := e.fundingRates[ev.Symbol]; ok {
+		rates, err := s.until(end)
+		if err != nil {
+			return err
+		}
+		e.funding[ev.Symbol] = append(e.funding[ev.Symbol], rates...)
+	}
+
+	return nil
+}
+
+// candleTrades are the trades inside the last candle of the timeframe.
+// Buckets left out for missing candles leave their trades behind, which
+// are cut off here.
+func (e *Engine) candleTrades(d datasets.Dataset, symbol, tf string) []datasets.AggTrade {
+	trades := e.trades[tradesKey(d, symbol, tf)]
+	window := e.windows[symbol+"_"+tf]
+	open := window[len(window)-1].OpenTime
+	i := sort.Search(len(trades), func(i int) bool {
+		return trades[i].Time >= open
+	})
+
+	return trades[i:len(trades):len(trades)]
+}
+
+// resetTrades starts the trades of the candles that closed over.
+func (e *Engine) resetTrades(symbol string, closed map[string]bool) {
+	for d, timeframes := range e.tradeTimeframes {
+		for _, tf := range timeframes {
+			if closed[tf] {
+				delete(e.trades, tradesKey(d, symbol, tf))
+			}
+		}
+	}
+}
+
+func tradesKey(d datasets.Dataset, symbol, tf string) string {
+	return d.String() + "_" + symbol + "_" + tf
+}
+
+// resample adds the candle to the window of the base timeframe, and to the
+// buckets of the other timeframes strategies run on or look at. It returns the
+// timeframes that got a new closed candle.
+func (e *Engine) resample(ev Event) map[string]bool {
+	closed := map[string]bool{e.config.Timeframe: true}
+	e.push(ev.Symbol+"_"+e.config.Timeframe, ev.Kline)
+
+	for _, tf := range e.resampled {
+		k := ev.Symbol + "_" + tf
+		r, ok := e.resamplers[k]
+		if !ok {
+			r = techanext.NewResampler(globals.Durations[tf], techanext.DropPartial)
+			e.resamplers[k] = r
+		}
+		for _, bucket := range r.Add(ev.Kline) {
+			e.push(k, bucket)
+			closed[tf] = true
+		}
+	}
+
+	return closed
+}
+
+func (e *Engine) push(k string, kline *binance.Kline) {
+	window := append(e.windows[k], kline)
+	if len(window) > e.config.WindowSize {
+		window = window[len(window)-e.config.WindowSize:]
+	}
+	e.windows[k] = window
+}
+
+// placeResting keeps track of the orders resting after a trade: a limit buy
+// that's yet to fill, the exits of a filled buy, or nothing after a sell.
+func (e *Engine) placeResting(order *storage.Order) {
+	k := order.Strategy + "_" + order.Symbol
+	r := &resting{order.Strategy, order.Symbol, order.Fingerprint, nil}
+
+	switch {
+	case e.exchange.placed != nil:
+		r.orders = []RestingOrder{*e.exchange.placed}
+		e.exchange.placed = nil
+		e.resting[k] = r
+	case order.Successful && order.Decision == globals.Buy:
+		r.orders = e.exits(order.Price, order.Quantity)
+		if len(r.orders) != 0 {
+			e.resting[k] = r
+		}
+	case order.Successful && order.Decision == globals.Sell:
+		delete(e.resting, k)
+	}
+}
+
+func (e *Engine) exits(price, quantity float64) []RestingOrder {
+	exits := []RestingOrder{}
+	if e.config.StopLoss > 0 {
+		exits = append(exits, RestingOrder{StopLoss, globals.Sell, price * (1 - e.config.StopLoss/100), quantity})
+	}
+	if e.config.TakeProfit > 0 {
+		exits = append(exits, RestingOrder{TakeProfit, globals.Sell, price * (1 + e.config.TakeProfit/100), quantity})
+	}
+
+	return exits
+}
+
+// fillResting walks the candle through the symbol's resting orders. Limit
+// buys only live for a single candle, and the exits they place once filled
+// can fill on what's left of it.
+func (e *Engine) fillResting(ev Event) error {
+	keys := []string{}
+	for k, r := range e.resting {
+		if r.symbol == ev.Symbol {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	timeframe := globals.Durations[e.config.Timeframe]
+	var sub []*binance.Kline
+	if b, ok := e.intrabar[ev.Symbol]; ok && len(keys) != 0 {
+		var err error
+		sub, err = b.window(ev.Kline, timeframe)
+		if err != nil {
+			return err
+		}
+	}
+	paths := candlePaths(ev.Kline, sub, timeframe, e.config.Path)
+
+	for _, k := range keys {
+		r := e.resting[k]
+		delete(e.resting, k)
+
+		fill, ok := fillPaths(paths, r.orders)
+		if !ok {
+			if r.orders[0].Type != Limit {
+				e.resting[k] = r
+			}
+			continue
+		}
+
+		order, err := e.storeFill(r, ev.Kline, fill)
+		if err != nil {
+			return err
+		}
+		if fill.Order.Type != Limit {
+			continue
+		}
+
+		r.orders = e.exits(order.Price, order.Quantity)
+		if len(r.orders) == 0 {
+			continue
+		}
+		fill, ok = fillPaths(fill.rest, r.orders)
+		if !ok {
+			e.resting[k] = r
+			continue
+		}
+
+		_, err = e.storeFill(r, ev.Kline, fill)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stops are market orders once triggered, so they pay slippage on top.
+func (e *Engine) storeFill(r *resting, candle *binance.Kline, fill Fill) (*storage.Order, error) {
+	o := fill.Order
+	price := fill.Price
+	var slippage float64
+	if !o.maker() && e.config.Slippage != nil {
+		slip := e.config.Slippage.Slip(price, o.Quantity, candle)
+		price -= slip
+		slippage = slip * o.Quantity
+	}
+
+	order := &storage.Order{
+		Strategy:    r.strategy,
+		Fingerprint: r.fingerprint,
+		Symbol:      r.symbol,
+		Decision:    o.Side,
+		Quantity:    o.Quantity,
+		Price:       price,
+		Fee:         e.config.Fees.Fee(r.symbol, price*o.Quantity, o.maker()),
+		Slippage:    slippage,
+		Ambiguous:   fill.Ambiguous,
+		Trace: storage.Trace{storage.NewRuleTrace(string(o.Type), storage.Condition{
+			Name: "price reached",
+			Inputs: map[string]string{
+				"Order price": strconv.FormatFloat(o.Price, 'f', -1, 64),
+				"Fill price":  strconv.FormatFloat(fill.Price, 'f', -1, 64),
+			},
+			Result: true,
+		})},
+		Timeframe:  e.timeframes[r.strategy],
+		Successful: true,
+		CreatedAt:  fill.Time,
+	}
+
+	return order, e.storage.StoreOrder(order)
+}
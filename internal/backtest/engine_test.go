@@ -0,0 +1,354 @@
+package backtest_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/datasets"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/strategies"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestEngine(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	feed := map[string][]*binance.Kline{
+		"LTCBTC":  testutil.MockKlines(start, testutil.WaveCloses(500, 40)...),
+		"BTCBUSD": testutil.MockKlines(start, testutil.WaveCloses(500, 70)...),
+	}
+	config := backtest.Config{
+		Start:      start,
+		End:        start.Add(500 * time.Minute),
+		Symbols:    []string{"LTCBTC", "BTCBUSD"},
+		Strategies: []string{"example"},
+		Timeframe:  "1m",
+		WindowSize: 20,
+	}
+
+	t.Run("stamps orders with candle time", func(t *testing.T) {
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Orders) == 0 {
+			t.Fatal("expected backtest to produce orders")
+		}
+
+		for _, o := range result.Orders {
+			if o.CreatedAt.Before(start) || o.CreatedAt.After(config.End) || o.CreatedAt.Second() != 0 {
+				t.Fatalf("order is not stamped with candle time, got %v", o.CreatedAt)
+			}
+		}
+	})
+
+	t.Run("streams candles from csv in lean runs", func(t *testing.T) {
+		want, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sources := map[string]candles.KlineIterator{}
+		for s, klines := range feed {
+			b := &bytes.Buffer{}
+			w := csv.NewWriter(b)
+			for _, k := range klines {
+				w.Write(candles.FromKline(k).Record())
+			}
+			w.Flush()
+			sources[s] = candles.Klines(candles.NewReader(b))
+		}
+
+		config := config
+		config.Lean = true
+		got, err := backtest.NewStreamEngine(config, sources).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got.Orders, want.Orders) || !reflect.DeepEqual(got.Coverage, want.Coverage) {
+			t.Error("expected streamed backtest to match the one on loaded klines")
+		}
+		if len(got.Klines) != 0 {
+			t.Errorf("expected lean run to keep no klines, got %v symbols", len(got.Klines))
+		}
+	})
+
+	t.Run("runs concurrently with reproducible results", func(t *testing.T) {
+		results := make([]*backtest.Result, 4)
+		wg := sync.WaitGroup{}
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				result, err := backtest.NewEngine(config, feed).Run()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				results[i] = result
+			}(i)
+		}
+		wg.Wait()
+
+		for _, r := range results[1:] {
+			if !reflect.DeepEqual(r.Orders, results[0].Orders) {
+				t.Fatal("concurrent backtests produced different orders")
+			}
+		}
+	})
+
+	t.Run("fills at the next open with fees and slippage", func(t *testing.T) {
+		config := config
+		config.Fees = backtest.Fees{Rates: backtest.Rates{Maker: 0.1, Taker: 0.1}}
+		config.Slippage = backtest.FixedBps(10)
+
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fees float64
+		for _, o := range result.Orders {
+			if !o.Successful {
+				continue
+			}
+
+			// Mock candles open at the previous close, so all of the slippage comes from the model
+			reference := o.Price / 1.001
+			if o.Decision == globals.Sell {
+				reference = o.Price / 0.999
+			}
+			if math.Abs(o.Slippage-reference*0.001*o.Quantity) > 1e-9 {
+				t.Fatalf("wrong slippage, got %+v", o)
+			}
+			if math.Abs(o.Fee-o.Price*o.Quantity*0.001) > 1e-9 {
+				t.Fatalf("wrong fee, got %+v", o)
+			}
+			fees += o.Fee
+		}
+
+		if math.Abs(result.Portfolio.Fees-fees) > 1e-9 {
+			t.Errorf("expected %v fees in the portfolio analysis, got %v", fees, result.Portfolio.Fees)
+		}
+	})
+
+	t.Run("measures slippage from the next open", func(t *testing.T) {
+		// Every candle opens over the previous close
+		gapped := map[string][]*binance.Kline{}
+		for s, klines := range feed {
+			for i, k := range klines {
+				k := *k
+				if i > 0 {
+					prev, _ := strconv.ParseFloat(klines[i-1].Close, 64)
+					k.Open = strconv.FormatFloat(prev*1.002, 'f', -1, 64)
+				}
+				gapped[s] = append(gapped[s], &k)
+			}
+		}
+		config := config
+		config.Slippage = backtest.FixedBps(10)
+
+		result, err := backtest.NewEngine(config, gapped).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, o := range result.Orders {
+			if !o.Successful {
+				continue
+			}
+
+			reference := o.Price / 1.001
+			if o.Decision == globals.Sell {
+				reference = o.Price / 0.999
+			}
+			if math.Abs(o.Slippage-reference*0.001*o.Quantity) > 1e-9 {
+				t.Fatalf("expected only the slippage of the model, got %+v", o)
+			}
+		}
+	})
+
+	t.Run("exits positions on stops and take profits", func(t *testing.T) {
+		config := config
+		config.StopLoss = 1
+		config.TakeProfit = 1
+
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var exits, ambiguous int
+		for _, o := range result.Orders {
+			if o.Ambiguous {
+				ambiguous++
+			}
+			if len(o.Trace) == 1 && (o.Trace[0].Rule == "stop loss" || o.Trace[0].Rule == "take profit") {
+				exits++
+			}
+		}
+
+		if exits == 0 {
+			t.Error("expected some positions to be closed by exits")
+		}
+		if ambiguous != result.AmbiguousFills {
+			t.Errorf("expected %v ambiguous fills, got %v", ambiguous, result.AmbiguousFills)
+		}
+	})
+
+	t.Run("rests buys as limit orders", func(t *testing.T) {
+		config := config
+		config.LimitOffset = 0.1
+
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var limits int
+		for _, o := range result.Orders {
+			if o.Decision != globals.Buy {
+				continue
+			}
+			if len(o.Trace) != 1 || o.Trace[0].Rule != "limit" || o.Fee != 0 {
+				t.Fatalf("expected buys to fill as limit orders, got %+v", o)
+			}
+			limits++
+		}
+
+		if limits == 0 {
+			t.Error("expected some limit buys to fill")
+		}
+	})
+
+	t.Run("runs strategies on resampled timeframes", func(t *testing.T) {
+		config := config
+		config.Timeframes = map[string]string{"example": "5m"}
+
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Orders) == 0 {
+			t.Fatal("expected backtest to produce orders")
+		}
+		for _, o := range result.Orders {
+			if o.Timeframe != "5m" || o.CreatedAt.Minute()%5 != 0 {
+				t.Fatalf("expected orders on 5m candle closes, got %+v", o)
+			}
+		}
+
+		config.Timeframes = map[string]string{"example": "7m"}
+		_, err = backtest.NewEngine(config, feed).Run()
+		if err != globals.ErrWrongTimeframe {
+			t.Errorf("expected wrong timeframe error, got %v", err)
+		}
+	})
+
+	t.Run("rejects strategies fed by external signals", func(t *testing.T) {
+		config := config
+		config.Strategies = []string{"example", "external"}
+
+		_, err := backtest.NewEngine(config, feed).Run()
+		if err != globals.ErrNoSignalSource {
+			t.Errorf("expected no signal source error, got %v", err)
+		}
+	})
+
+	t.Run("hands strategies closed higher timeframe candles only", func(t *testing.T) {
+		var calls, lookaheads int
+		lock := sync.Mutex{}
+		strategies.AddBundleStrategyInfo("lookahead_probe", "1.0.0", nil, []string{"15m"},
+			func(bundle strategies.Bundle, params strategies.Params) (string, map[string]string, storage.Trace) {
+				lock.Lock()
+				defer lock.Unlock()
+				calls++
+				higher := bundle.Higher["15m"]
+				if len(higher.Candles) != 0 && higher.LastCandle().Period.End.After(bundle.Series.LastCandle().Period.End) {
+					lookaheads++
+				}
+
+				return globals.Hold, nil, nil
+			}, nil)
+		defer delete(strategies.StrategiesInfo, "lookahead_probe")
+
+		config := config
+		config.Strategies = []string{"lookahead_probe", "example_trend"}
+		_, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if calls == 0 || lookaheads != 0 {
+			t.Errorf("expected no lookahead over %v evaluations, got %v", calls, lookaheads)
+		}
+	})
+
+	t.Run("hands strategies the trades and funding rates of their candles", func(t *testing.T) {
+		var calls, misplaced, trades, rates int
+		lock := sync.Mutex{}
+		strategies.AddBundleStrategyInfo("datasets_probe", "1.0.0", nil, nil,
+			func(bundle strategies.Bundle, params strategies.Params) (string, map[string]string, storage.Trace) {
+				lock.Lock()
+				defer lock.Unlock()
+				calls++
+				period := bundle.Series.LastCandle().Period
+				for _, trade := range bundle.Trades {
+					if trade.Time < period.Start.UnixMilli() || trade.Time > period.End.UnixMilli() {
+						misplaced++
+					}
+				}
+				for _, rate := range bundle.Funding {
+					if rate.Time > period.End.UnixMilli() {
+						misplaced++
+					}
+				}
+				trades += len(bundle.Trades)
+				rates = len(bundle.Funding)
+
+				return globals.Hold, nil, nil
+			}, nil)
+		defer delete(strategies.StrategiesInfo, "datasets_probe")
+		err := strategies.SetDatasets("datasets_probe", datasets.SpotAggTrades, datasets.FundingRates)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		aggTrades := []datasets.AggTrade{}
+		for at := start; at.Before(config.End); at = at.Add(30 * time.Second) {
+			aggTrades = append(aggTrades, datasets.AggTrade{ID: int64(len(aggTrades)), Price: 100, Quantity: 1, Time: at.UnixMilli()})
+		}
+		funding := []datasets.FundingRate{}
+		for at := start; at.Before(config.End); at = at.Add(4 * time.Hour) {
+			funding = append(funding, datasets.FundingRate{Time: at.UnixMilli(), IntervalHours: 4, Rate: 0.0001})
+		}
+
+		config := config
+		config.Symbols = []string{"LTCBTC"}
+		config.Strategies = []string{"datasets_probe"}
+		config.AggTrades = map[datasets.Dataset]map[string]datasets.Iterator[datasets.AggTrade]{
+			datasets.SpotAggTrades: {"LTCBTC": datasets.Slice(aggTrades)},
+		}
+		config.Funding = map[string]datasets.Iterator[datasets.FundingRate]{"LTCBTC": datasets.Slice(funding)}
+		_, err = backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if calls == 0 || misplaced != 0 || trades != 2*calls || rates != len(funding) {
+			t.Errorf("expected 2 trades a candle and the funding rates so far over %v evaluations, got %v trades, %v rates and %v misplaced", calls, trades, rates, misplaced)
+		}
+	})
+}
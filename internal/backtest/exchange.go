@@ -0,0 +1,82 @@
+package backtest
+
+import (
+	"strconv"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// exchange fills market orders at the open of the candle following the
+// decision, so strategies never trade at a price they've already seen.
+type exchange struct {
+	binancew.ExchangeClient
+	fees     Fees
+	slippage Slippage
+	// Candle after the one being evaluated, for every symbol
+	next map[string]*binance.Kline
+	// Buys rest as limits this percent under the decision price, if set
+	limitOffset float64
+	// Last limit order put on the book, for the engine to pick up
+	placed *RestingOrder
+}
+
+func newExchange(fees Fees, slippage Slippage, limitOffset float64) *exchange {
+	return &exchange{
+		ExchangeClient: binancew.NewExtClientSim("", ""),
+		fees:           fees,
+		slippage:       slippage,
+		next:           map[string]*binance.Kline{},
+		limitOffset:    limitOffset,
+	}
+}
+
+func (e *exchange) CreateOrder(symbol, quantity, price string, side binance.SideType) (*binance.CreateOrderResponse, error) {
+	resp := &binance.CreateOrderResponse{
+		Symbol:           symbol,
+		Side:             side,
+		Type:             binance.OrderTypeMarket,
+		OrigQuantity:     quantity,
+		ExecutedQuantity: "0",
+		Status:           binance.OrderStatusTypeExpired,
+	}
+
+	// Nothing to fill against at the end of the data
+	candle := e.next[symbol]
+	if candle == nil {
+		return resp, nil
+	}
+
+	qty, _ := strconv.ParseFloat(quantity, 64)
+	if e.limitOffset > 0 && side == binance.SideType(globals.Buy) {
+		p, _ := strconv.ParseFloat(price, 64)
+		e.placed = &RestingOrder{Limit, globals.Buy, p * (1 - e.limitOffset/100), qty}
+		resp.Type = binance.OrderTypeLimit
+		resp.Status = binance.OrderStatusTypeNew
+
+		return resp, nil
+	}
+
+	// The price the order goes for, slippage is measured from it
+	resp.Price = candle.Open
+	fill, _ := strconv.ParseFloat(candle.Open, 64)
+	if e.slippage != nil {
+		slip := e.slippage.Slip(fill, qty, candle)
+		if side == binance.SideType(globals.Sell) {
+			slip = -slip
+		}
+		fill += slip
+	}
+	fee := e.fees.Fee(symbol, fill*qty, false)
+
+	resp.ExecutedQuantity = quantity
+	resp.Status = binance.OrderStatusTypeFilled
+	resp.Fills = []*binance.Fill{{
+		Price:      strconv.FormatFloat(fill, 'f', -1, 64),
+		Quantity:   quantity,
+		Commission: strconv.FormatFloat(fee, 'f', -1, 64),
+	}}
+
+	return resp, nil
+}
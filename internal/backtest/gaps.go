@@ -0,0 +1,164 @@
+package backtest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+type GapPolicy string
+
+const (
+	// Skip evaluates the symbol only on the candles that exist
+	Skip GapPolicy = "skip"
+	// ForwardFill replaces missing candles with flat ones at the last close
+	ForwardFill GapPolicy = "ffill"
+	// Halt fails the backtest on the first missing candle
+	Halt GapPolicy = "halt"
+)
+
+func ParseGapPolicy(s string) (GapPolicy, error) {
+	switch p := GapPolicy(s); p {
+	case Skip, ForwardFill, Halt:
+		return p, nil
+	}
+
+	return "", globals.ErrWrongGapPolicy
+}
+
+// Coverage describes how much of the replayed span a symbol had data for.
+// Listed and Delisted are the first and last candles seen, which differ from
+// the replay span for symbols that were listed late or delisted early.
+type Coverage struct {
+	Symbol     string    `json:"symbol"`
+	Expected   int       `json:"expected"`
+	Present    int       `json:"present"`
+	Filled     int       `json:"filled"`
+	Missing    int       `json:"missing"`
+	Duplicates int       `json:"duplicates"`
+	Gaps       int       `json:"gaps"`
+	Listed     time.Time `json:"listed"`
+	Delisted   time.Time `json:"delisted"`
+	Ratio      float64   `json:"ratio"`
+}
+
+// aligner drops duplicate or out of order candles and applies the gap policy
+// to every hole between the first and last candle of the symbol, as the
+// candles are read.
+type aligner struct {
+	symbol   string
+	klines   candles.KlineIterator
+	step     int64
+	policy   GapPolicy
+	prev     *binance.Kline
+	pending  *binance.Kline
+	coverage Coverage
+}
+
+func newAligner(symbol string, klines candles.KlineIterator, timeframe time.Duration, policy GapPolicy) *aligner {
+	return &aligner{
+		symbol:   symbol,
+		klines:   klines,
+		step:     timeframe.Milliseconds(),
+		policy:   policy,
+		coverage: Coverage{Symbol: symbol},
+	}
+}
+
+func (a *aligner) Next() (*binance.Kline, error) {
+	// Flat candles go out before the one that came after the gap
+	if a.pending != nil {
+		if next := a.prev.OpenTime + a.step; next < a.pending.OpenTime {
+			a.prev = flatKline(a.prev, next, a.step)
+			a.coverage.Filled++
+			return a.prev, nil
+		}
+
+		k := a.pending
+		a.pending = nil
+		return a.emit(k), nil
+	}
+
+	for {
+		k, err := a.klines.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if a.prev == nil {
+			a.coverage.Listed = time.UnixMilli(k.OpenTime).UTC()
+			return a.emit(k), nil
+		}
+		if k.OpenTime <= a.prev.OpenTime {
+			a.coverage.Duplicates++
+			continue
+		}
+
+		missing := int((k.OpenTime-a.prev.OpenTime)/a.step) - 1
+		if missing <= 0 {
+			return a.emit(k), nil
+		}
+
+		a.coverage.Gaps++
+		a.coverage.Missing += missing
+
+		switch a.policy {
+		case Halt:
+			return nil, fmt.Errorf(
+				"%w: %s has %d missing candles after %s",
+				globals.ErrDataGap,
+				a.symbol,
+				missing,
+				time.UnixMilli(a.prev.OpenTime).UTC().Format("02-01-2006 15:04"),
+			)
+		case ForwardFill:
+			a.pending = k
+			return a.Next()
+		}
+
+		return a.emit(k), nil
+	}
+}
+
+func (a *aligner) emit(k *binance.Kline) *binance.Kline {
+	a.prev = k
+	a.coverage.Present++
+	a.coverage.Delisted = time.UnixMilli(k.OpenTime).UTC()
+
+	return k
+}
+
+func flatKline(prev *binance.Kline, openTime, step int64) *binance.Kline {
+	return &binance.Kline{
+		OpenTime:                 openTime,
+		Open:                     prev.Close,
+		High:                     prev.Close,
+		Low:                      prev.Close,
+		Close:                    prev.Close,
+		Volume:                   "0",
+		CloseTime:                openTime + step - 1,
+		QuoteAssetVolume:         "0",
+		TakerBuyBaseAssetVolume:  "0",
+		TakerBuyQuoteAssetVolume: "0",
+	}
+}
+
+// Expected candles are counted over the whole replay span, so late listings
+// and early delistings show up as a lower ratio.
+func finishCoverage(coverage map[string]Coverage, first, last time.Time, timeframe time.Duration) {
+	expected := 0
+	if !first.IsZero() {
+		expected = int(last.Sub(first)/timeframe) + 1
+	}
+
+	for k, c := range coverage {
+		c.Expected = expected
+		if expected != 0 {
+			c.Ratio = float64(c.Present) / float64(expected)
+		}
+		coverage[k] = c
+	}
+}
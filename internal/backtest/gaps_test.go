@@ -0,0 +1,82 @@
+package backtest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestGapPolicies(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	full := testutil.MockKlines(start, testutil.WaveCloses(300, 40)...)
+
+	// Listed 100 minutes late, with 10 candles missing in the middle
+	late := testutil.MockKlines(start.Add(100*time.Minute), testutil.WaveCloses(200, 30)...)
+	gapped := append([]*binance.Kline{}, late[:50]...)
+	gapped = append(gapped, late[60:]...)
+	// A duplicate candle should be dropped
+	gapped = append(gapped[:20], append([]*binance.Kline{gapped[19]}, gapped[20:]...)...)
+
+	feed := map[string][]*binance.Kline{
+		"LTCBTC": full,
+		"ETHBTC": gapped,
+	}
+	config := backtest.Config{
+		Start:      start,
+		End:        start.Add(300 * time.Minute),
+		Symbols:    []string{"LTCBTC", "ETHBTC"},
+		Strategies: []string{"example"},
+		Timeframe:  "1m",
+		WindowSize: 20,
+	}
+
+	t.Run("skips missing candles and reports coverage", func(t *testing.T) {
+		config.GapPolicy = backtest.Skip
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := result.Coverage["ETHBTC"]
+		if c.Expected != 300 || c.Present != 190 || c.Missing != 10 || c.Gaps != 1 || c.Duplicates != 1 || c.Filled != 0 {
+			t.Errorf("wrong coverage, got %+v", c)
+		}
+		if !c.Listed.Equal(start.Add(100 * time.Minute)) {
+			t.Errorf("wrong listing time, got %v", c.Listed)
+		}
+		if result.Coverage["LTCBTC"].Ratio != 1 {
+			t.Errorf("expected full coverage, got %+v", result.Coverage["LTCBTC"])
+		}
+
+		for _, o := range result.Orders {
+			if o.Symbol == "ETHBTC" && o.CreatedAt.Before(c.Listed) {
+				t.Errorf("traded symbol before listing at %v", o.CreatedAt)
+			}
+		}
+	})
+
+	t.Run("forward fills missing candles", func(t *testing.T) {
+		config.GapPolicy = backtest.ForwardFill
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c := result.Coverage["ETHBTC"]; c.Filled != 10 {
+			t.Errorf("expected 10 filled candles, got %+v", c)
+		}
+	})
+
+	t.Run("halts on missing candles", func(t *testing.T) {
+		config.GapPolicy = backtest.Halt
+		_, err := backtest.NewEngine(config, feed).Run()
+		if !errors.Is(err, globals.ErrDataGap) {
+			t.Errorf("expected data gap error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package backtest
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// PathMode guesses the order in which a candle visited its high and low.
+type PathMode string
+
+const (
+	// Nearest goes to whichever extreme is closer to the open first
+	Nearest PathMode = "nearest"
+	// OHLC goes open, high, low, close
+	OHLC PathMode = "ohlc"
+	// OLHC goes open, low, high, close
+	OLHC PathMode = "olhc"
+)
+
+func ParsePathMode(s string) (PathMode, error) {
+	switch p := PathMode(s); p {
+	case Nearest, OHLC, OLHC:
+		return p, nil
+	}
+
+	return "", globals.ErrWrongPathMode
+}
+
+type RestingType string
+
+const (
+	StopLoss   RestingType = "stop loss"
+	TakeProfit RestingType = "take profit"
+	Limit      RestingType = "limit"
+)
+
+// RestingOrder waits on the book until the price reaches it. Stops fill
+// as market orders once triggered, the rest as makers.
+type RestingOrder struct {
+	Type     RestingType
+	Side     string
+	Price    float64
+	Quantity float64
+}
+
+func (r RestingOrder) maker() bool {
+	return r.Type != StopLoss
+}
+
+func (r RestingOrder) down() bool {
+	return r.Type == StopLoss || (r.Type == Limit && r.Side == globals.Buy)
+}
+
+// trigger returns the fill price if the order is reached while the price moves from a to b.
+// Orders the price has already gapped through fill at a.
+func (r RestingOrder) trigger(a, b float64) (float64, bool) {
+	if r.down() {
+		if a <= r.Price {
+			return a, true
+		}
+		return r.Price, b <= r.Price
+	}
+
+	if a >= r.Price {
+		return a, true
+	}
+	return r.Price, b >= r.Price
+}
+
+func (r RestingOrder) reached(low, high float64) bool {
+	if r.down() {
+		return low <= r.Price
+	}
+
+	return high >= r.Price
+}
+
+// path is a stretch of prices that ends at a known time, either a whole
+// candle or one of its lower timeframe candles.
+type path struct {
+	prices []float64
+	end    time.Time
+}
+
+// Fill of a resting order inside a candle. Ambiguous fills come from paths
+// that reached more than one of the orders, where the guessed order of the
+// high and low decided which one won.
+type Fill struct {
+	Order     RestingOrder
+	Price     float64
+	Time      time.Time
+	Ambiguous bool
+	// What's left of the candle after the fill, for orders placed by it
+	rest []path
+}
+
+// Path of a candle through its OHLC prices.
+func Path(k *binance.Kline, mode PathMode) []float64 {
+	open, _ := strconv.ParseFloat(k.Open, 64)
+	high, _ := strconv.ParseFloat(k.High, 64)
+	low, _ := strconv.ParseFloat(k.Low, 64)
+	closePrice, _ := strconv.ParseFloat(k.Close, 64)
+
+	highFirst := mode == OHLC
+	if mode == Nearest || mode == "" {
+		highFirst = high-open < open-low
+	}
+
+	if highFirst {
+		return []float64{open, high, low, closePrice}
+	}
+	return []float64{open, low, high, closePrice}
+}
+
+// candlePaths splits the candle into its lower timeframe candles when there
+// are any, and guesses the path through its own OHLC otherwise.
+func candlePaths(k *binance.Kline, sub []*binance.Kline, timeframe time.Duration, mode PathMode) []path {
+	if len(sub) == 0 {
+		return []path{{Path(k, mode), time.UnixMilli(k.OpenTime).Add(timeframe)}}
+	}
+
+	paths := make([]path, len(sub))
+	for i, s := range sub {
+		paths[i] = path{Path(s, mode), time.UnixMilli(s.CloseTime + 1)}
+	}
+
+	return paths
+}
+
+// fillPaths walks the paths and returns the first of the orders they reach.
+func fillPaths(paths []path, orders []RestingOrder) (Fill, bool) {
+	for n, p := range paths {
+		for i := 1; i < len(p.prices); i++ {
+			for _, o := range orders {
+				price, ok := o.trigger(p.prices[i-1], p.prices[i])
+				if !ok {
+					continue
+				}
+
+				rest := append([]path{{append([]float64{price}, p.prices[i:]...), p.end}}, paths[n+1:]...)
+				return Fill{
+					Order:     o,
+					Price:     price,
+					Time:      p.end,
+					Ambiguous: reachesOthers(p.prices, o, orders),
+					rest:      rest,
+				}, true
+			}
+		}
+	}
+
+	return Fill{}, false
+}
+
+func reachesOthers(prices []float64, filled RestingOrder, orders []RestingOrder) bool {
+	low, high := prices[0], prices[0]
+	for _, p := range prices {
+		low = math.Min(low, p)
+		high = math.Max(high, p)
+	}
+
+	for _, o := range orders {
+		if o != filled && o.reached(low, high) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// intrabar reads the lower timeframe candles of a symbol as the replay gets
+// to them, so only the ones of a single candle are held at a time.
+type intrabar struct {
+	it   candles.KlineIterator
+	next *binance.Kline
+	done bool
+}
+
+// window returns the lower timeframe candles inside the candle. Candles have
+// to be asked for in time order, the ones before are skipped.
+func (b *intrabar) window(k *binance.Kline, timeframe time.Duration) ([]*binance.Kline, error) {
+	end := k.OpenTime + timeframe.Milliseconds()
+	sub := []*binance.Kline{}
+	for {
+		if b.next == nil {
+			if b.done {
+				return sub, nil
+			}
+			next, err := b.it.Next()
+			if err == io.EOF {
+				b.done = true
+				return sub, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			b.next = next
+		}
+
+		if b.next.OpenTime >= end {
+			return sub, nil
+		}
+		if b.next.OpenTime >= k.OpenTime {
+			sub = append(sub, b.next)
+		}
+		b.next = nil
+	}
+}
+
+// OpenIntrabar looks for the finest timeframe under the given one that has
+// data downloaded for the period, to be read as the backtest goes. Symbols
+// without any are left out.
+func OpenIntrabar(symbols []string, timeframe string, start, end time.Time) (map[string]candles.KlineIterator, error) {
+	lower := []string{}
+	for tf, d := range globals.Durations {
+		if d < globals.Durations[timeframe] {
+			lower = append(lower, tf)
+		}
+	}
+	sort.Slice(lower, func(i, j int) bool {
+		return globals.Durations[lower[i]] < globals.Durations[lower[j]]
+	})
+
+	store := candles.DefaultStore()
+	intrabar := map[string]candles.KlineIterator{}
+	for _, s := range symbols {
+		for _, tf := range lower {
+			cursor, err := store.Range(s, tf, start, end.Add(24*time.Hour))
+			if errors.Is(err, globals.ErrKlinesNotFound) {
+				continue
+			}
+			if err != nil {
+				closeFeed(intrabar)
+				return nil, err
+			}
+			intrabar[s] = candles.Klines(cursor)
+			break
+		}
+	}
+
+	return intrabar, nil
+}
@@ -0,0 +1,95 @@
+package backtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestPath(t *testing.T) {
+	k := &binance.Kline{Open: "100", High: "102", Low: "95", Close: "97"}
+
+	tests := map[PathMode][]float64{
+		Nearest: {100, 102, 95, 97},
+		OHLC:    {100, 102, 95, 97},
+		OLHC:    {100, 95, 102, 97},
+	}
+	for mode, want := range tests {
+		if got := Path(k, mode); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v want %v", mode, got, want)
+		}
+	}
+}
+
+func TestFillPaths(t *testing.T) {
+	end := time.Unix(1600000000, 0)
+	exits := []RestingOrder{
+		{StopLoss, globals.Sell, 96, 1},
+		{TakeProfit, globals.Sell, 101, 1},
+	}
+
+	t.Run("flags bars that reach both exits", func(t *testing.T) {
+		fill, ok := fillPaths([]path{{[]float64{100, 102, 95, 97}, end}}, exits)
+		if !ok {
+			t.Fatal("expected a fill")
+		}
+
+		if fill.Order.Type != TakeProfit || fill.Price != 101 || !fill.Ambiguous {
+			t.Errorf("expected ambiguous take profit at 101, got %+v", fill)
+		}
+	})
+
+	t.Run("fills gapped stops at the open", func(t *testing.T) {
+		fill, ok := fillPaths([]path{{[]float64{94, 98, 93, 97}, end}}, exits)
+		if !ok {
+			t.Fatal("expected a fill")
+		}
+
+		if fill.Order.Type != StopLoss || fill.Price != 94 || fill.Ambiguous {
+			t.Errorf("expected clean stop at 94, got %+v", fill)
+		}
+	})
+
+	t.Run("lower timeframe candles settle the order", func(t *testing.T) {
+		start := end.Add(-time.Minute)
+		k := &binance.Kline{OpenTime: start.UnixMilli(), Open: "100", High: "102", Low: "95", Close: "97"}
+		sub := []*binance.Kline{
+			{OpenTime: start.UnixMilli(), CloseTime: start.Add(30*time.Second).UnixMilli() - 1, Open: "100", High: "100", Low: "95", Close: "96"},
+			{OpenTime: start.Add(30 * time.Second).UnixMilli(), CloseTime: end.UnixMilli() - 1, Open: "96", High: "102", Low: "96", Close: "97"},
+		}
+
+		fill, ok := fillPaths(candlePaths(k, sub, time.Minute, Nearest), exits)
+		if !ok {
+			t.Fatal("expected a fill")
+		}
+
+		if fill.Order.Type != StopLoss || fill.Ambiguous || !fill.Time.Equal(start.Add(30*time.Second)) {
+			t.Errorf("expected clean stop in the first half of the candle, got %+v", fill)
+		}
+	})
+}
+
+func TestIntrabarWindow(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	minutes := testutil.MockKlines(start, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+	b := &intrabar{it: candles.Slice(minutes)}
+
+	// Asked for the 3m candles from 00:03, the ones before are skipped and
+	// the last one only has a candle of data
+	for i, want := range [][]*binance.Kline{minutes[3:6], minutes[6:9], minutes[9:], nil} {
+		k := &binance.Kline{OpenTime: start.Add(time.Duration(3*(i+1)) * time.Minute).UnixMilli()}
+		got, err := b.window(k, 3*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(want) || (len(want) != 0 && got[0] != want[0]) {
+			t.Errorf("wrong lower timeframe candles of candle %v, got %v want %v", i, len(got), len(want))
+		}
+	}
+}
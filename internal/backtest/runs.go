@@ -0,0 +1,236 @@
+package backtest
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/strategies"
+)
+
+// NewRun describes how the backtest was run, for it to be stored.
+func NewRun(result *Result) *storage.BacktestRun {
+	config := result.Config
+	run := &storage.BacktestRun{
+		Symbols:      strings.Join(config.Symbols, " "),
+		Strategies:   strings.Join(config.Strategies, " "),
+		Params:       map[string]map[string]float64{},
+		Fingerprints: map[string]string{},
+		Timeframes:   config.Timeframes,
+		Revision:     revision(),
+		Fees:         config.Fees.String(),
+		GapPolicy:    string(config.GapPolicy),
+		StopLoss:     config.StopLoss,
+		TakeProfit:   config.TakeProfit,
+		LimitOffset:  config.LimitOffset,
+		Path:         string(config.Path),
+		Trades:       result.Portfolio.Metrics.Trades,
+		ProfitUSD:    result.Portfolio.ProfitUSD,
+		Duration:     result.Duration,
+		Timeframe:    config.Timeframe,
+		Start:        config.Start,
+		End:          config.End,
+	}
+	if config.Slippage != nil {
+		run.Slippage = config.Slippage.String()
+	}
+
+	for _, s := range config.Strategies {
+		info, ok := strategies.StrategiesInfo[s]
+		if !ok {
+			continue
+		}
+		info = info.WithParams(config.Params[s])
+		run.Params[s] = info.Params
+		run.Fingerprints[s] = info.Fingerprint()
+	}
+
+	return run
+}
+
+// SaveRun stores the run along with its orders and analyses, the portfolio
+// one included.
+func SaveRun(client storage.StorageClient, result *Result) (*storage.BacktestRun, error) {
+	analyses := map[string]storage.Analysis{analysis.PortfolioStrategy: result.Portfolio}
+	for k, a := range result.Analyses {
+		analyses[k] = a
+	}
+
+	run := NewRun(result)
+	err := client.StoreBacktestRun(run, result.Orders, analyses)
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// revision of the build, marked dirty if it had uncommitted changes.
+func revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	var rev, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	if rev != "" && modified == "true" {
+		rev += "-dirty"
+	}
+
+	return rev
+}
+
+// DiffRow is a line of two runs compared side by side.
+type DiffRow struct {
+	Name string
+	A    string
+	B    string
+}
+
+func (r DiffRow) Differs() bool {
+	return r.A != r.B
+}
+
+// Diff compares the setup of two runs, then the analyses of every strategy
+// and symbol either of them traded. Missing values are shown as "-".
+func Diff(a, b storage.BacktestRun, analysesA, analysesB []storage.Analysis) []DiffRow {
+	rows := []DiffRow{
+		{"Run", fmt.Sprint(a.ID), fmt.Sprint(b.ID)},
+		{"Period", period(a), period(b)},
+		{"Timeframe", a.Timeframe, b.Timeframe},
+		{"Symbols", a.Symbols, b.Symbols},
+		{"Strategies", a.Strategies, b.Strategies},
+		{"Revision", orDash(a.Revision), orDash(b.Revision)},
+		{"Fees", a.Fees, b.Fees},
+		{"Slippage", orDash(a.Slippage), orDash(b.Slippage)},
+		{"Gap policy", orDash(a.GapPolicy), orDash(b.GapPolicy)},
+		{"Stop loss", formatFloat(a.StopLoss), formatFloat(b.StopLoss)},
+		{"Take profit", formatFloat(a.TakeProfit), formatFloat(b.TakeProfit)},
+		{"Limit offset", formatFloat(a.LimitOffset), formatFloat(b.LimitOffset)},
+		{"Path", orDash(a.Path), orDash(b.Path)},
+		{"Duration", a.Duration.String(), b.Duration.String()},
+	}
+
+	for _, s := range union(keys(a.Fingerprints), keys(b.Fingerprints)) {
+		rows = append(rows,
+			DiffRow{"Version " + s, orDash(a.Fingerprints[s]), orDash(b.Fingerprints[s])},
+			DiffRow{"Params " + s, formatParams(a.Params[s]), formatParams(b.Params[s])},
+			DiffRow{"Timeframe " + s, orDash(a.Timeframes[s]), orDash(b.Timeframes[s])},
+		)
+	}
+
+	byKeyA, byKeyB := byKey(analysesA), byKey(analysesB)
+	for _, k := range union(keys(byKeyA), keys(byKeyB)) {
+		rows = append(rows, diffAnalyses(k, byKeyA[k], byKeyB[k])...)
+	}
+
+	return rows
+}
+
+func diffAnalyses(key string, a, b *storage.Analysis) []DiffRow {
+	fields := []struct {
+		name  string
+		value func(storage.Analysis) string
+	}{
+		{"trades", func(a storage.Analysis) string { return fmt.Sprint(a.Metrics.Trades) }},
+		{"profit", func(a storage.Analysis) string { return formatFixed(a.ProfitUSD) }},
+		{"fees", func(a storage.Analysis) string { return formatFixed(a.Fees) }},
+		{"success rate", func(a storage.Analysis) string { return formatFixed(a.SuccessRate) }},
+		{"max drawdown", func(a storage.Analysis) string { return formatFixed(a.Metrics.MaxDrawdown) }},
+		{"sharpe", func(a storage.Analysis) string { return formatFixed(a.Metrics.Sharpe) }},
+		{"profit factor", func(a storage.Analysis) string { return formatFixed(a.Metrics.ProfitFactor) }},
+	}
+
+	rows := []DiffRow{}
+	for _, f := range fields {
+		row := DiffRow{Name: key + " " + f.name, A: "-", B: "-"}
+		if a != nil {
+			row.A = f.value(*a)
+		}
+		if b != nil {
+			row.B = f.value(*b)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func byKey(analyses []storage.Analysis) map[string]*storage.Analysis {
+	result := map[string]*storage.Analysis{}
+	for i := range analyses {
+		a := &analyses[i]
+		result[a.Strategy+" "+a.Symbol] = a
+	}
+
+	return result
+}
+
+func keys[V any](m map[string]V) []string {
+	result := []string{}
+	for k := range m {
+		result = append(result, k)
+	}
+
+	return result
+}
+
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, k := range append(a, b...) {
+		if !seen[k] {
+			seen[k] = true
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func period(r storage.BacktestRun) string {
+	return r.Start.Format("02-01-2006") + " " + r.End.Format("02-01-2006")
+}
+
+func formatParams(params map[string]float64) string {
+	if len(params) == 0 {
+		return "-"
+	}
+
+	s := []string{}
+	for _, k := range keys(params) {
+		s = append(s, k+"="+formatFloat(params[k]))
+	}
+	sort.Strings(s)
+
+	return strings.Join(s, " ")
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatFixed(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
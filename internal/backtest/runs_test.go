@@ -0,0 +1,95 @@
+package backtest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/strategies"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestRuns(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	feed := map[string][]*binance.Kline{
+		"LTCBTC": testutil.MockKlines(start, testutil.WaveCloses(300, 40)...),
+	}
+	config := backtest.Config{
+		Start:      start,
+		End:        start.Add(300 * time.Minute),
+		Symbols:    []string{"LTCBTC"},
+		Strategies: []string{"example"},
+		Timeframe:  "1m",
+		WindowSize: 20,
+		Fees:       backtest.Fees{Rates: backtest.Rates{Maker: 0.1, Taker: 0.1}},
+	}
+
+	client := storage.NewInMemoryClient()
+	save := func(config backtest.Config) *storage.BacktestRun {
+		result, err := backtest.NewEngine(config, feed).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		run, err := backtest.SaveRun(client, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return run
+	}
+
+	first := save(config)
+	config.Slippage = backtest.FixedBps(10)
+	config.Params = map[string]strategies.Params{"example": {"window": 7}}
+	second := save(config)
+
+	t.Run("links orders and analyses to the run", func(t *testing.T) {
+		runs, err := client.GetBacktestRuns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 2 || runs[0].ID != second.ID {
+			t.Fatalf("expected both runs newest first, got %+v", runs)
+		}
+
+		orders, _ := client.GetOrdersByRun(first.ID)
+		if len(orders) == 0 {
+			t.Fatal("expected orders of the run to be stored")
+		}
+		live, _ := client.GetAllOrders()
+		if len(live) != 0 {
+			t.Fatalf("expected backtest orders to stay out of live ones, got %v", len(live))
+		}
+
+		analyses, _ := client.GetAnalysesByRun(second.ID)
+		if len(analyses) != 2 {
+			t.Fatalf("expected symbol and portfolio analyses, got %v", len(analyses))
+		}
+	})
+
+	t.Run("diffs two runs", func(t *testing.T) {
+		a, _ := client.GetBacktestRun(first.ID)
+		b, _ := client.GetBacktestRun(second.ID)
+		analysesA, _ := client.GetAnalysesByRun(a.ID)
+		analysesB, _ := client.GetAnalysesByRun(b.ID)
+
+		differs := map[string]bool{}
+		for _, r := range backtest.Diff(*a, *b, analysesA, analysesB) {
+			differs[r.Name] = r.Differs()
+		}
+
+		for name, want := range map[string]bool{
+			"Period":          false,
+			"Fees":            false,
+			"Slippage":        true,
+			"Params example":  true,
+			"Version example": true,
+		} {
+			if differs[name] != want {
+				t.Errorf("expected %v to differ: %v", name, want)
+			}
+		}
+	})
+}
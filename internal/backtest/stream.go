@@ -0,0 +1,137 @@
+package backtest
+
+import (
+	"container/heap"
+	"io"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/candles"
+)
+
+// events merges the aligned candles of every symbol by close time, so symbols
+// with different listing dates or holes in their data stay aligned. Only the
+// current and the following candle of every symbol are held at a time.
+type events struct {
+	timeframe time.Duration
+	aligners  []*aligner
+	heads     heads
+}
+
+type head struct {
+	symbol  string
+	aligner *aligner
+	kline   *binance.Kline
+	next    *binance.Kline
+}
+
+type heads []*head
+
+func (h heads) Len() int      { return len(h) }
+func (h heads) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h heads) Less(i, j int) bool {
+	if h[i].kline.OpenTime != h[j].kline.OpenTime {
+		return h[i].kline.OpenTime < h[j].kline.OpenTime
+	}
+	return h[i].symbol < h[j].symbol
+}
+func (h *heads) Push(x any) { *h = append(*h, x.(*head)) }
+func (h *heads) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func newEvents(symbols []string, sources map[string]candles.KlineIterator, timeframe time.Duration, policy GapPolicy) (*events, error) {
+	e := &events{timeframe: timeframe}
+
+	for _, symbol := range symbols {
+		source := sources[symbol]
+		if source == nil {
+			source = candles.Slice(nil)
+		}
+		a := newAligner(symbol, source, timeframe, policy)
+		e.aligners = append(e.aligners, a)
+
+		k, err := next(a)
+		if err != nil {
+			return nil, err
+		}
+		if k == nil {
+			continue
+		}
+		n, err := next(a)
+		if err != nil {
+			return nil, err
+		}
+		e.heads = append(e.heads, &head{symbol, a, k, n})
+	}
+	heap.Init(&e.heads)
+
+	return e, nil
+}
+
+// Next returns io.EOF once every symbol ran out of candles.
+func (e *events) Next() (Event, error) {
+	if len(e.heads) == 0 {
+		return Event{}, io.EOF
+	}
+
+	h := e.heads[0]
+	ev := Event{
+		Time:   time.UnixMilli(h.kline.OpenTime).Add(e.timeframe),
+		Symbol: h.symbol,
+		Kline:  h.kline,
+		Next:   h.next,
+	}
+
+	if h.next == nil {
+		heap.Pop(&e.heads)
+		return ev, nil
+	}
+
+	var err error
+	h.kline = h.next
+	h.next, err = next(h.aligner)
+	if err != nil {
+		return Event{}, err
+	}
+	heap.Fix(&e.heads, 0)
+
+	return ev, nil
+}
+
+// coverage is only complete once every event was read.
+func (e *events) coverage() map[string]Coverage {
+	coverage := map[string]Coverage{}
+	var first, last time.Time
+
+	for _, a := range e.aligners {
+		c := a.coverage
+		coverage[c.Symbol] = c
+		if c.Present == 0 {
+			continue
+		}
+		if first.IsZero() || c.Listed.Before(first) {
+			first = c.Listed
+		}
+		if c.Delisted.After(last) {
+			last = c.Delisted
+		}
+	}
+
+	finishCoverage(coverage, first, last, e.timeframe)
+
+	return coverage
+}
+
+// next is nil at the end of the candles.
+func next(it candles.KlineIterator) (*binance.Kline, error) {
+	k, err := it.Next()
+	if err == io.EOF {
+		return nil, nil
+	}
+
+	return k, err
+}
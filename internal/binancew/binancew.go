@@ -2,11 +2,13 @@ package binancew
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"sync"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/globals"
 )
 
 var (
@@ -89,6 +91,45 @@ func (client *ClientExt) GetKlinesByPeriod(symbol, timeframe string, start, end
 	return klines, nil
 }
 
+// PageKlines pages through the klines of [start, end) on the exchange, which
+// only hands out 500 of them at a time, passing every page on to page.
+func PageKlines(ctx context.Context, client ExchangeClient, symbol, timeframe string, start, end time.Time, page func([]*binance.Kline) error) error {
+	step := globals.Durations[timeframe]
+	from := start
+	for from.Before(end) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		klines, err := client.GetKlinesByPeriod(symbol, timeframe, from, end.Add(-time.Millisecond))
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", symbol, timeframe, err)
+		}
+		if len(klines) == 0 {
+			return nil
+		}
+
+		inPeriod := []*binance.Kline{}
+		for _, k := range klines {
+			if k.OpenTime >= from.UnixMilli() && k.OpenTime < end.UnixMilli() {
+				inPeriod = append(inPeriod, k)
+			}
+		}
+		err = page(inPeriod)
+		if err != nil {
+			return err
+		}
+
+		next := time.UnixMilli(klines[len(klines)-1].OpenTime).Add(step)
+		if !next.After(from) {
+			return nil
+		}
+		from = next
+	}
+
+	return nil
+}
+
 func (client *ClientExt) GetAccount() (*binance.Account, error) {
 	account, err := client.NewGetAccountService().
 		Do(context.Background())
@@ -0,0 +1,314 @@
+package candles
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// Candle is a kline with its fields parsed, so they're only parsed once
+// while streaming through a dataset.
+type Candle struct {
+	OpenTime      int64
+	Open          float64
+	High          float64
+	Low           float64
+	Close         float64
+	Volume        float64
+	CloseTime     int64
+	QuoteVolume   float64
+	Trades        int64
+	TakerBuyBase  float64
+	TakerBuyQuote float64
+}
+
+func FromKline(k *binance.Kline) Candle {
+	return Candle{
+		OpenTime:      k.OpenTime,
+		Open:          parse(k.Open),
+		High:          parse(k.High),
+		Low:           parse(k.Low),
+		Close:         parse(k.Close),
+		Volume:        parse(k.Volume),
+		CloseTime:     k.CloseTime,
+		QuoteVolume:   parse(k.QuoteAssetVolume),
+		Trades:        k.TradeNum,
+		TakerBuyBase:  parse(k.TakerBuyBaseAssetVolume),
+		TakerBuyQuote: parse(k.TakerBuyQuoteAssetVolume),
+	}
+}
+
+func (c Candle) Kline() *binance.Kline {
+	return &binance.Kline{
+		OpenTime:                 c.OpenTime,
+		Open:                     format(c.Open),
+		High:                     format(c.High),
+		Low:                      format(c.Low),
+		Close:                    format(c.Close),
+		Volume:                   format(c.Volume),
+		CloseTime:                c.CloseTime,
+		QuoteAssetVolume:         format(c.QuoteVolume),
+		TradeNum:                 c.Trades,
+		TakerBuyBaseAssetVolume:  format(c.TakerBuyBase),
+		TakerBuyQuoteAssetVolume: format(c.TakerBuyQuote),
+	}
+}
+
+// Iterator hands out candles one at a time, and io.EOF after the last one.
+type Iterator interface {
+	Next() (Candle, error)
+}
+
+// KlineIterator is the same for klines, which is what the backtester replays.
+type KlineIterator interface {
+	Next() (*binance.Kline, error)
+}
+
+// Reader parses candles from CSV rows in the format of data.binance.vision
+// as they're read, so only a single row is held at a time.
+type Reader struct {
+	csv    *csv.Reader
+	closer io.Closer
+	row    int
+	// Whether the rows had their times in µs
+	micro bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	// Newer archives have a trailing column the older ones don't
+	reader.FieldsPerRecord = -1
+	// A stray quote makes a malformed row, not an unreadable file
+	reader.LazyQuotes = true
+
+	return &Reader{csv: reader}
+}
+
+// Open reads the candles of a CSV file, which the reader closes.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewReader(f)
+	r.closer = f
+
+	return r, nil
+}
+
+func (r *Reader) Next() (Candle, error) {
+	record, err := r.csv.Read()
+	if _, ok := err.(*csv.ParseError); ok {
+		// The reader carries on from the next row
+		r.row++
+		return Candle{}, err
+	}
+	if err != nil {
+		return Candle{}, err
+	}
+	r.row++
+
+	c, micro, err := parseRecord(record)
+	r.micro = r.micro || micro
+	// Some archives start with a header
+	if err != nil && r.row == 1 && !isNumber(record[0]) {
+		return r.Next()
+	}
+	if err != nil {
+		return Candle{}, fmt.Errorf("%w: row %d", err, r.row)
+	}
+
+	return c, nil
+}
+
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+
+	return r.closer.Close()
+}
+
+// ParseRecord parses a CSV row of a kline. Times in µs, which newer spot
+// archives have, are turned into ms like everywhere else.
+func ParseRecord(record []string) (Candle, error) {
+	c, _, err := parseRecord(record)
+	return c, err
+}
+
+func parseRecord(record []string) (Candle, bool, error) {
+	if len(record) < 11 {
+		return Candle{}, false, globals.ErrMalformedCandle
+	}
+
+	var c Candle
+	var failed error
+	parseInt := func(s string) int64 {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			failed = err
+		}
+		return v
+	}
+	parseFloat := func(s string) float64 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			failed = err
+		}
+		return v
+	}
+
+	c.OpenTime = parseInt(record[0])
+	c.Open = parseFloat(record[1])
+	c.High = parseFloat(record[2])
+	c.Low = parseFloat(record[3])
+	c.Close = parseFloat(record[4])
+	c.Volume = parseFloat(record[5])
+	c.CloseTime = parseInt(record[6])
+	c.QuoteVolume = parseFloat(record[7])
+	c.Trades = parseInt(record[8])
+	c.TakerBuyBase = parseFloat(record[9])
+	c.TakerBuyQuote = parseFloat(record[10])
+
+	if failed != nil {
+		return Candle{}, false, globals.ErrMalformedCandle
+	}
+
+	micro := c.OpenTime >= microseconds
+	c.OpenTime, c.CloseTime = Millis(c.OpenTime), Millis(c.CloseTime)
+
+	return c, micro, nil
+}
+
+// Times from this on are taken as µs, in ms they'd be tens of thousands of
+// years away.
+const microseconds = 1e15
+
+// Millis takes a time in ms or µs and hands it back in ms.
+func Millis(t int64) int64 {
+	if t >= microseconds {
+		return t / 1000
+	}
+
+	return t
+}
+
+// Record is the CSV row of the candle.
+func (c Candle) Record() []string {
+	k := c.Kline()
+	return []string{
+		strconv.FormatInt(k.OpenTime, 10),
+		k.Open,
+		k.High,
+		k.Low,
+		k.Close,
+		k.Volume,
+		strconv.FormatInt(k.CloseTime, 10),
+		k.QuoteAssetVolume,
+		strconv.FormatInt(k.TradeNum, 10),
+		k.TakerBuyBaseAssetVolume,
+		k.TakerBuyQuoteAssetVolume,
+		"0",
+	}
+}
+
+type klines struct {
+	it Iterator
+}
+
+// Klines turns the candles into klines as they're iterated. Closing it
+// closes the candles.
+func Klines(it Iterator) KlineIterator {
+	return &klines{it}
+}
+
+func (k *klines) Next() (*binance.Kline, error) {
+	c, err := k.it.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Kline(), nil
+}
+
+func (k *klines) Close() error {
+	if c, ok := k.it.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+type slice struct {
+	klines []*binance.Kline
+	i      int
+}
+
+// Slice iterates over klines that are already in memory.
+func Slice(klines []*binance.Kline) KlineIterator {
+	return &slice{klines: klines}
+}
+
+func (s *slice) Next() (*binance.Kline, error) {
+	if s.i >= len(s.klines) {
+		return nil, io.EOF
+	}
+	s.i++
+
+	return s.klines[s.i-1], nil
+}
+
+type fromSlice struct {
+	klines []*binance.Kline
+	i      int
+}
+
+// FromSlice parses klines that are already in memory into candles.
+func FromSlice(klines []*binance.Kline) Iterator {
+	return &fromSlice{klines: klines}
+}
+
+func (s *fromSlice) Next() (Candle, error) {
+	if s.i >= len(s.klines) {
+		return Candle{}, io.EOF
+	}
+	s.i++
+
+	return FromKline(s.klines[s.i-1]), nil
+}
+
+// Collect reads what's left of the iterator into memory.
+func Collect(it KlineIterator) ([]*binance.Kline, error) {
+	result := []*binance.Kline{}
+	for {
+		k, err := it.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, k)
+	}
+}
+
+func parse(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+func format(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
@@ -0,0 +1,57 @@
+package candles_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+func TestReader(t *testing.T) {
+	rows := "open_time,open,high,low,close,volume,close_time,quote_volume,count,taker_buy_volume,taker_buy_quote_volume,ignore\n" +
+		"1671494400000,0.003856,0.003857,0.003851,0.003857,146.133,1671494459999,0.56317999,40,58.714,0.22624394,0\n" +
+		"1671494460000,0.003856,0.003858,0.003853,0.003856,20.465,1671494519999,0.07889942,11,9.588,0.03696249\n"
+
+	t.Run("parses rows lazily and skips the header", func(t *testing.T) {
+		r := candles.NewReader(strings.NewReader(rows))
+
+		c, err := r.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, candles.Candle{
+			OpenTime:      1671494400000,
+			Open:          0.003856,
+			High:          0.003857,
+			Low:           0.003851,
+			Close:         0.003857,
+			Volume:        146.133,
+			CloseTime:     1671494459999,
+			QuoteVolume:   0.56317999,
+			Trades:        40,
+			TakerBuyBase:  58.714,
+			TakerBuyQuote: 0.22624394,
+		}, c)
+
+		klines, err := candles.Collect(candles.Klines(r))
+		assert.NoError(t, err)
+		assert.Len(t, klines, 1)
+		assert.Equal(t, "0.003856", klines[0].Close)
+		assert.Equal(t, c, candles.FromKline(c.Kline()))
+	})
+
+	t.Run("reports malformed rows", func(t *testing.T) {
+		r := candles.NewReader(strings.NewReader(rows + "1671494520000,0.003853,oops\n"))
+
+		var err error
+		for err == nil {
+			_, err = r.Next()
+		}
+
+		assert.True(t, errors.Is(err, globals.ErrMalformedCandle))
+		assert.Contains(t, err.Error(), "row 4")
+		assert.NotEqual(t, io.EOF, err)
+	})
+}
@@ -0,0 +1,503 @@
+package candles
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+const (
+	monthFormat = "2006-01"
+	// Every field of a candle as 8 bytes
+	recordSize = 11 * 8
+	headerSize = 8
+)
+
+var magic = []byte("ABKL\x00\x00\x00\x01")
+
+// Writes to every store are serialized, which keeps the index of a symbol
+// consistent when downloads of it overlap.
+var writing sync.Mutex
+
+// Store keeps candles in binary files per symbol, timeframe and month, ex.
+// LTCBTC/1m/2021-02.bin, next to an index of what every file holds. Files
+// hold fixed size records in time order without duplicates, so ranges are
+// found with a binary search and read sequentially.
+type Store struct {
+	dir string
+}
+
+// Month is an index entry. First and Last are open times in ms.
+type Month struct {
+	Month string `json:"month"`
+	Count int    `json:"count"`
+	First int64  `json:"first"`
+	Last  int64  `json:"last"`
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir}
+}
+
+// DefaultStore is the store downloads go to and backtests read from.
+func DefaultStore() *Store {
+	return NewStore(globals.BacktestDataDir)
+}
+
+// Index lists the months stored for the symbol and timeframe in order.
+func (s *Store) Index(symbol, timeframe string) ([]Month, error) {
+	data, err := os.ReadFile(s.indexPath(symbol, timeframe))
+	if os.IsNotExist(err) {
+		return []Month{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := []Month{}
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// Write merges the candles into the store. Candles after the last stored
+// one of their month are appended, others are merged into the month with
+// the new ones replacing stored candles of the same open time. Candles are
+// expected in time order, only a month of them is held at a time. The index
+// is updated as every month is written, so the months written before a
+// failure are kept.
+func (s *Store) Write(symbol, timeframe string, it Iterator) error {
+	writing.Lock()
+	defer writing.Unlock()
+
+	index, err := s.Index(symbol, timeframe)
+	if err != nil {
+		return err
+	}
+	months := map[string]Month{}
+	for _, m := range index {
+		months[m.Month] = m
+	}
+
+	var month string
+	batch := []Candle{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		m, err := s.writeMonth(symbol, timeframe, months[month], month, batch)
+		if err != nil {
+			return err
+		}
+		months[month] = m
+		batch = batch[:0]
+		return s.writeIndex(symbol, timeframe, months)
+	}
+
+	for {
+		c, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if m := monthOf(c.OpenTime); m != month {
+			err = flush()
+			if err != nil {
+				return err
+			}
+			month = m
+		}
+		batch = append(batch, c)
+	}
+
+	return flush()
+}
+
+func (s *Store) writeIndex(symbol, timeframe string, months map[string]Month) error {
+	index := make([]Month, 0, len(months))
+	for _, m := range months {
+		index = append(index, m)
+	}
+	sort.Slice(index, func(i, j int) bool {
+		return index[i].Month < index[j].Month
+	})
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(s.indexPath(symbol, timeframe), data)
+}
+
+func (s *Store) writeMonth(symbol, timeframe string, stored Month, month string, batch []Candle) (Month, error) {
+	batch = dedupe(batch)
+	path := s.monthPath(symbol, timeframe, month)
+
+	if stored.Count != 0 && batch[0].OpenTime > stored.Last {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+		if err != nil {
+			return stored, err
+		}
+		defer f.Close()
+
+		// Records past the indexed ones are left over from a failed append
+		size := int64(headerSize + stored.Count*recordSize)
+		err = f.Truncate(size)
+		if err != nil {
+			return stored, err
+		}
+		_, err = f.Seek(size, io.SeekStart)
+		if err != nil {
+			return stored, err
+		}
+		err = writeRecords(f, batch)
+		if err != nil {
+			f.Truncate(size)
+			return stored, err
+		}
+
+		stored.Count += len(batch)
+		stored.Last = batch[len(batch)-1].OpenTime
+		return stored, f.Close()
+	}
+
+	merged := batch
+	if stored.Count != 0 {
+		existing, err := readMonth(path)
+		if err != nil {
+			return stored, err
+		}
+		// New candles go last, so they're the ones kept
+		merged = dedupe(append(existing, batch...))
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return stored, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".month-*")
+	if err != nil {
+		return stored, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.Write(magic)
+	if err != nil {
+		return stored, err
+	}
+	err = writeRecords(f, merged)
+	if err != nil {
+		return stored, err
+	}
+	err = f.Close()
+	if err != nil {
+		return stored, err
+	}
+
+	return Month{
+		Month: month,
+		Count: len(merged),
+		First: merged[0].OpenTime,
+		Last:  merged[len(merged)-1].OpenTime,
+	}, os.Rename(f.Name(), path)
+}
+
+// Range reads the candles opening in [start, end) in time order. It fails
+// with globals.ErrKlinesNotFound when nothing is stored for the range.
+func (s *Store) Range(symbol, timeframe string, start, end time.Time) (*Cursor, error) {
+	index, err := s.Index(symbol, timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cursor{start: start.UnixMilli(), end: end.UnixMilli()}
+	for _, m := range index {
+		if m.Count != 0 && m.Last >= c.start && m.First < c.end {
+			c.paths = append(c.paths, s.monthPath(symbol, timeframe, m.Month))
+		}
+	}
+	if len(c.paths) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", globals.ErrKlinesNotFound, symbol, timeframe)
+	}
+
+	return c, nil
+}
+
+// Cursor iterates over a range of stored candles, a file at a time.
+type Cursor struct {
+	paths  []string
+	start  int64
+	end    int64
+	file   *os.File
+	reader *bufio.Reader
+	record [recordSize]byte
+}
+
+func (c *Cursor) Next() (Candle, error) {
+	for {
+		if c.reader == nil {
+			if len(c.paths) == 0 {
+				return Candle{}, io.EOF
+			}
+			err := c.open(c.paths[0])
+			if err != nil {
+				return Candle{}, err
+			}
+			c.paths = c.paths[1:]
+		}
+
+		_, err := io.ReadFull(c.reader, c.record[:])
+		if err == io.EOF {
+			c.Close()
+			continue
+		}
+		if err != nil {
+			return Candle{}, err
+		}
+
+		candle := decode(c.record[:])
+		if candle.OpenTime >= c.end {
+			c.Close()
+			c.paths = nil
+			return Candle{}, io.EOF
+		}
+
+		return candle, nil
+	}
+}
+
+// open seeks to the first candle of the range in the file.
+func (c *Cursor) open(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	count := int((info.Size() - headerSize) / recordSize)
+	if info.Size() < headerSize || (info.Size()-headerSize)%recordSize != 0 {
+		f.Close()
+		return fmt.Errorf("%w: %s", globals.ErrMalformedCandle, path)
+	}
+
+	record := make([]byte, recordSize)
+	var failed error
+	first := sort.Search(count, func(i int) bool {
+		_, err := f.ReadAt(record, headerSize+int64(i)*recordSize)
+		if err != nil {
+			failed = err
+			return true
+		}
+		return decode(record).OpenTime >= c.start
+	})
+	if failed != nil {
+		f.Close()
+		return failed
+	}
+
+	_, err = f.Seek(headerSize+int64(first)*recordSize, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	c.file = f
+	c.reader = bufio.NewReader(f)
+	return nil
+}
+
+func (c *Cursor) Close() error {
+	if c.file == nil {
+		return nil
+	}
+
+	err := c.file.Close()
+	c.file = nil
+	c.reader = nil
+	return err
+}
+
+// Listed is the open time of the first candle there is of the symbol and
+// timeframe, 0 if it isn't known.
+func (s *Store) Listed(symbol, timeframe string) (int64, error) {
+	data, err := os.ReadFile(s.listedPath(symbol, timeframe))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var listed int64
+	err = json.Unmarshal(data, &listed)
+	if err != nil {
+		return 0, err
+	}
+
+	return listed, nil
+}
+
+// SetListed remembers that there are no candles of the symbol and timeframe
+// before the open time.
+func (s *Store) SetListed(symbol, timeframe string, openTime int64) error {
+	writing.Lock()
+	defer writing.Unlock()
+
+	data, err := json.Marshal(openTime)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(s.listedPath(symbol, timeframe), data)
+}
+
+func (s *Store) indexPath(symbol, timeframe string) string {
+	return filepath.Join(s.dir, symbol, timeframe, "index.json")
+}
+
+func (s *Store) listedPath(symbol, timeframe string) string {
+	return filepath.Join(s.dir, symbol, timeframe, "listed.json")
+}
+
+func (s *Store) monthPath(symbol, timeframe, month string) string {
+	return filepath.Join(s.dir, symbol, timeframe, month+".bin")
+}
+
+func monthOf(openTime int64) string {
+	return time.UnixMilli(openTime).UTC().Format(monthFormat)
+}
+
+func readMonth(path string) ([]Candle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerSize || (len(data)-headerSize)%recordSize != 0 {
+		return nil, fmt.Errorf("%w: %s", globals.ErrMalformedCandle, path)
+	}
+
+	result := make([]Candle, 0, (len(data)-headerSize)/recordSize)
+	for i := headerSize; i < len(data); i += recordSize {
+		result = append(result, decode(data[i:i+recordSize]))
+	}
+
+	return result, nil
+}
+
+func writeRecords(w io.Writer, candles []Candle) error {
+	bw := bufio.NewWriter(w)
+	record := make([]byte, recordSize)
+	for _, c := range candles {
+		encode(record, c)
+		_, err := bw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// dedupe sorts the candles by open time, keeping the last of the ones opening
+// at the same time.
+func dedupe(candles []Candle) []Candle {
+	sort.SliceStable(candles, func(i, j int) bool {
+		return candles[i].OpenTime < candles[j].OpenTime
+	})
+
+	result := candles[:0]
+	for _, c := range candles {
+		if len(result) != 0 && result[len(result)-1].OpenTime == c.OpenTime {
+			result[len(result)-1] = c
+			continue
+		}
+		result = append(result, c)
+	}
+
+	return result
+}
+
+func encode(b []byte, c Candle) {
+	fields := []uint64{
+		uint64(c.OpenTime),
+		math.Float64bits(c.Open),
+		math.Float64bits(c.High),
+		math.Float64bits(c.Low),
+		math.Float64bits(c.Close),
+		math.Float64bits(c.Volume),
+		uint64(c.CloseTime),
+		math.Float64bits(c.QuoteVolume),
+		uint64(c.Trades),
+		math.Float64bits(c.TakerBuyBase),
+		math.Float64bits(c.TakerBuyQuote),
+	}
+	for i, f := range fields {
+		binary.LittleEndian.PutUint64(b[i*8:], f)
+	}
+}
+
+func decode(b []byte) Candle {
+	field := func(i int) uint64 {
+		return binary.LittleEndian.Uint64(b[i*8:])
+	}
+
+	return Candle{
+		OpenTime:      int64(field(0)),
+		Open:          math.Float64frombits(field(1)),
+		High:          math.Float64frombits(field(2)),
+		Low:           math.Float64frombits(field(3)),
+		Close:         math.Float64frombits(field(4)),
+		Volume:        math.Float64frombits(field(5)),
+		CloseTime:     int64(field(6)),
+		QuoteVolume:   math.Float64frombits(field(7)),
+		Trades:        int64(field(8)),
+		TakerBuyBase:  math.Float64frombits(field(9)),
+		TakerBuyQuote: math.Float64frombits(field(10)),
+	}
+}
+
+func writeAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,145 @@
+package candles_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestStore(t *testing.T) {
+	// Three hours across the turn of the year
+	start := time.Date(2022, 12, 31, 23, 30, 0, 0, time.UTC)
+	klines := testutil.MockKlines(start, testutil.WaveCloses(180, 30)...)
+	store := candles.NewStore(t.TempDir())
+
+	read := func(from, to time.Time) []*binance.Kline {
+		cursor, err := store.Range("LTCBTC", "1m", from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cursor.Close()
+
+		got, err := candles.Collect(candles.Klines(cursor))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	closes := func(klines []*binance.Kline) []string {
+		result := []string{}
+		for _, k := range klines {
+			result = append(result, k.Close)
+		}
+		return result
+	}
+
+	t.Run("splits candles into months and indexes them", func(t *testing.T) {
+		err := store.Write("LTCBTC", "1m", candles.FromSlice(klines[:60]))
+		assert.NoError(t, err)
+
+		index, err := store.Index("LTCBTC", "1m")
+		assert.NoError(t, err)
+		assert.Equal(t, []candles.Month{
+			{Month: "2022-12", Count: 30, First: klines[0].OpenTime, Last: klines[29].OpenTime},
+			{Month: "2023-01", Count: 30, First: klines[30].OpenTime, Last: klines[59].OpenTime},
+		}, index)
+	})
+
+	t.Run("appends and merges overlapping candles", func(t *testing.T) {
+		err := store.Write("LTCBTC", "1m", candles.FromSlice(klines[120:]))
+		assert.NoError(t, err)
+		// Overlaps both of the earlier writes
+		err = store.Write("LTCBTC", "1m", candles.FromSlice(klines[40:130]))
+		assert.NoError(t, err)
+
+		assert.Equal(t, closes(klines), closes(read(start, start.Add(3*time.Hour))))
+
+		index, err := store.Index("LTCBTC", "1m")
+		assert.NoError(t, err)
+		assert.Equal(t, 150, index[1].Count)
+	})
+
+	t.Run("reads ranges", func(t *testing.T) {
+		got := read(start.Add(25*time.Minute), start.Add(95*time.Minute))
+
+		assert.Equal(t, closes(klines[25:95]), closes(got))
+		assert.Equal(t, klines[25].OpenTime, got[0].OpenTime)
+	})
+
+	t.Run("fails on ranges without candles", func(t *testing.T) {
+		_, err := store.Range("LTCBTC", "1m", start.AddDate(0, 2, 0), start.AddDate(0, 3, 0))
+		assert.True(t, errors.Is(err, globals.ErrKlinesNotFound))
+
+		_, err = store.Range("ETHBTC", "1m", start, start.Add(time.Hour))
+		assert.True(t, errors.Is(err, globals.ErrKlinesNotFound))
+	})
+
+	t.Run("keeps the index consistent over failed writes", func(t *testing.T) {
+		dir := t.TempDir()
+		store := candles.NewStore(dir)
+		err := store.Write("LTCBTC", "1m", candles.FromSlice(klines[:15]))
+		assert.NoError(t, err)
+
+		// Fails halfway through January, after December is written
+		err = store.Write("LTCBTC", "1m", &failing{candles.FromSlice(klines[15:50]), 35})
+		assert.Equal(t, errFailing, err)
+		index, err := store.Index("LTCBTC", "1m")
+		assert.NoError(t, err)
+		assert.Equal(t, []candles.Month{
+			{Month: "2022-12", Count: 30, First: klines[0].OpenTime, Last: klines[29].OpenTime},
+		}, index)
+
+		// An append cut short leaves records the index doesn't have
+		err = store.Write("LTCBTC", "1m", candles.FromSlice(klines[30:60]))
+		assert.NoError(t, err)
+		path := filepath.Join(dir, "LTCBTC", "1m", "2023-01.bin")
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		assert.NoError(t, err)
+		_, err = f.Write(data[len(data)-recordSize*3/2:])
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		err = store.Write("LTCBTC", "1m", candles.FromSlice(klines[60:90]))
+		assert.NoError(t, err)
+
+		cursor, err := store.Range("LTCBTC", "1m", start, start.Add(3*time.Hour))
+		assert.NoError(t, err)
+		defer cursor.Close()
+		got, err := candles.Collect(candles.Klines(cursor))
+		assert.NoError(t, err)
+		assert.Equal(t, closes(klines[:90]), closes(got))
+		index, err = store.Index("LTCBTC", "1m")
+		assert.NoError(t, err)
+		assert.Equal(t, 60, index[1].Count)
+	})
+}
+
+// Every field of a candle as 8 bytes
+const recordSize = 11 * 8
+
+var errFailing = errors.New("failing")
+
+// failing fails after handing out the first n candles.
+type failing struct {
+	it candles.Iterator
+	n  int
+}
+
+func (f *failing) Next() (candles.Candle, error) {
+	if f.n == 0 {
+		return candles.Candle{}, errFailing
+	}
+	f.n--
+
+	return f.it.Next()
+}
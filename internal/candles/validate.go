@@ -0,0 +1,196 @@
+package candles
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// Checks tune what the validator looks for.
+type Checks struct {
+	// Expected time between candles, gaps aren't looked for if not set
+	Step time.Duration
+	// Shortest run of zero volume candles that's reported, none if not set
+	ZeroVolumeRun int
+	// Candles moving more than OutlierFactor times the mean move of the
+	// last OutlierWindow ones are outliers
+	OutlierFactor float64
+	OutlierWindow int
+}
+
+var DefaultChecks = Checks{
+	ZeroVolumeRun: 5,
+	OutlierFactor: 15,
+	OutlierWindow: 100,
+}
+
+// Quality of a file of candles. Rejected rows are left out of the candles,
+// the other issues are only reported.
+type Quality struct {
+	File         string  `json:"file"`
+	Rows         int     `json:"rows"`
+	Valid        int     `json:"valid"`
+	Microseconds bool    `json:"microseconds"`
+	Rejected     []Issue `json:"rejected"`
+	Outliers     []Issue `json:"outliers"`
+	ZeroVolume   []Run   `json:"zeroVolume"`
+	Gaps         []Run   `json:"gaps"`
+}
+
+func (q Quality) Clean() bool {
+	return len(q.Rejected) == 0 && len(q.Outliers) == 0 && len(q.ZeroVolume) == 0 && len(q.Gaps) == 0
+}
+
+type Issue struct {
+	Row      int    `json:"row"`
+	OpenTime int64  `json:"openTime"`
+	Reason   string `json:"reason"`
+}
+
+// Run of candles by open time, for gaps From is the first missing one.
+type Run struct {
+	From    int64 `json:"from"`
+	To      int64 `json:"to"`
+	Candles int   `json:"candles"`
+}
+
+// Validator reads the candles of a file, leaving out malformed rows and
+// candles that can't be right: prices that aren't positive, a high under the
+// low, an open or close out of their range, negative volumes and times that
+// go back. Zero volume runs, outliers and gaps are reported along.
+type Validator struct {
+	reader  *Reader
+	checks  Checks
+	quality Quality
+	prev    *Candle
+	// Zero volume run going on
+	zeros Run
+	// Last absolute log returns and their sum
+	moves []float64
+	sum   float64
+}
+
+func NewValidator(r *Reader, file string, checks Checks) *Validator {
+	return &Validator{
+		reader:  r,
+		checks:  checks,
+		quality: Quality{File: file},
+	}
+}
+
+func (v *Validator) Next() (Candle, error) {
+	for {
+		c, err := v.reader.Next()
+		if err == io.EOF {
+			v.endZeros()
+			return Candle{}, err
+		}
+		var parseErr *csv.ParseError
+		if errors.Is(err, globals.ErrMalformedCandle) || errors.As(err, &parseErr) {
+			v.quality.Rows++
+			v.reject(Candle{}, err.Error())
+			continue
+		}
+		if err != nil {
+			return Candle{}, err
+		}
+		v.quality.Rows++
+
+		if reason := inconsistent(c); reason != "" {
+			v.reject(c, reason)
+			continue
+		}
+		if v.prev != nil && c.OpenTime <= v.prev.OpenTime {
+			v.reject(c, "open time not after the previous candle")
+			continue
+		}
+
+		v.check(c)
+		v.prev = &c
+		v.quality.Valid++
+
+		return c, nil
+	}
+}
+
+// Quality is complete once the validator has handed out io.EOF.
+func (v *Validator) Quality() Quality {
+	q := v.quality
+	q.Microseconds = v.reader.micro
+
+	return q
+}
+
+func (v *Validator) reject(c Candle, reason string) {
+	v.quality.Rejected = append(v.quality.Rejected, Issue{v.reader.row, c.OpenTime, reason})
+}
+
+func (v *Validator) check(c Candle) {
+	step := v.checks.Step.Milliseconds()
+	if v.prev != nil && step > 0 && c.OpenTime-v.prev.OpenTime > step {
+		v.quality.Gaps = append(v.quality.Gaps, Run{
+			From:    v.prev.OpenTime + step,
+			To:      c.OpenTime,
+			Candles: int((c.OpenTime-v.prev.OpenTime)/step) - 1,
+		})
+	}
+
+	if c.Volume == 0 {
+		if v.zeros.Candles == 0 {
+			v.zeros.From = c.OpenTime
+		}
+		v.zeros.To = c.OpenTime
+		v.zeros.Candles++
+	} else {
+		v.endZeros()
+	}
+
+	if v.prev == nil || v.checks.OutlierWindow <= 0 {
+		return
+	}
+	move := math.Abs(math.Log(c.Close / v.prev.Close))
+	if mean := v.sum / float64(len(v.moves)); len(v.moves) == v.checks.OutlierWindow && mean > 0 && move > v.checks.OutlierFactor*mean {
+		v.quality.Outliers = append(v.quality.Outliers, Issue{
+			v.reader.row,
+			c.OpenTime,
+			fmt.Sprintf("close moved %.2f%%, %.0f times the mean move", (math.Exp(move)-1)*100, move/mean),
+		})
+	}
+	v.moves = append(v.moves, move)
+	v.sum += move
+	if len(v.moves) > v.checks.OutlierWindow {
+		v.sum -= v.moves[0]
+		v.moves = v.moves[1:]
+	}
+}
+
+func (v *Validator) endZeros() {
+	if v.checks.ZeroVolumeRun > 0 && v.zeros.Candles >= v.checks.ZeroVolumeRun {
+		v.quality.ZeroVolume = append(v.quality.ZeroVolume, v.zeros)
+	}
+	v.zeros = Run{}
+}
+
+func inconsistent(c Candle) string {
+	switch {
+	case c.Open <= 0 || c.High <= 0 || c.Low <= 0 || c.Close <= 0:
+		return "price not positive"
+	case c.High < c.Low:
+		return "high under low"
+	case c.Open < c.Low || c.Open > c.High:
+		return "open out of range"
+	case c.Close < c.Low || c.Close > c.High:
+		return "close out of range"
+	case c.Volume < 0:
+		return "negative volume"
+	case c.CloseTime < c.OpenTime:
+		return "close time before open time"
+	}
+
+	return ""
+}
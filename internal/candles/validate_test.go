@@ -0,0 +1,101 @@
+package candles_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ws396/autobinance/internal/candles"
+)
+
+func TestValidator(t *testing.T) {
+	start := int64(1735689600000) // 01-01-2025
+	minute := time.Minute.Milliseconds()
+	row := func(i int, open, high, low, close, volume float64) string {
+		openTime := (start + int64(i)*minute) * 1000
+		return fmt.Sprintf("%d,%v,%v,%v,%v,%v,%d,0,1,0,0,0\n", openTime, open, high, low, close, volume, openTime+minute*1000-1)
+	}
+
+	// µs rows, like newer spot archives
+	rows := ""
+	for i := 0; i < 120; i++ {
+		price := 100 + float64(i%2)*0.1
+		switch {
+		case i == 3:
+			rows += "not,a,row\n"
+		case i == 8:
+			rows += strings.Replace(row(i, price, price, price, price, 1), ",", `",`, 1)
+		case i == 4:
+			rows += row(i, price, 99, 101, price, 1)
+		case i == 5:
+			rows += row(i, price, price, price, 105, 1)
+		case i == 6:
+			rows += row(2, price, price, price, price, 1)
+		case i >= 10 && i < 20:
+			rows += row(i, price, price, price, price, 0)
+		case i >= 30 && i < 33:
+			// Gap
+		case i == 115:
+			rows += row(i, price, 130, price, 130, 1)
+		default:
+			rows += row(i, price, price, price, price, 1)
+		}
+	}
+
+	checks := candles.DefaultChecks
+	checks.Step = time.Minute
+	v := candles.NewValidator(candles.NewReader(strings.NewReader(rows)), "test.csv", checks)
+
+	got := []candles.Candle{}
+	for {
+		c, err := v.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		got = append(got, c)
+	}
+	q := v.Quality()
+
+	t.Run("turns µs times into ms", func(t *testing.T) {
+		assert.True(t, q.Microseconds)
+		assert.Equal(t, start, got[0].OpenTime)
+		assert.Equal(t, start+minute-1, got[0].CloseTime)
+	})
+
+	t.Run("rejects malformed and inconsistent rows", func(t *testing.T) {
+		reasons := []string{}
+		for _, issue := range q.Rejected {
+			reasons = append(reasons, issue.Reason)
+		}
+		assert.Len(t, reasons, 5)
+		assert.Contains(t, reasons[0], "malformed")
+		assert.Equal(t, []string{"high under low", "close out of range", "open time not after the previous candle"}, reasons[1:4])
+		assert.Equal(t, 7, q.Rejected[3].Row)
+		// Stray quote
+		assert.Contains(t, reasons[4], "malformed")
+		assert.Equal(t, 9, q.Rejected[4].Row)
+		assert.Equal(t, q.Rows-5, q.Valid)
+		assert.Len(t, got, q.Valid)
+	})
+
+	t.Run("reports zero volume runs, outliers and gaps", func(t *testing.T) {
+		assert.Equal(t, []candles.Run{{From: start + 10*minute, To: start + 19*minute, Candles: 10}}, q.ZeroVolume)
+		// Rejected rows leave gaps too
+		assert.Equal(t, []candles.Run{
+			{From: start + 3*minute, To: start + 7*minute, Candles: 4},
+			{From: start + 8*minute, To: start + 9*minute, Candles: 1},
+			{From: start + 30*minute, To: start + 33*minute, Candles: 3},
+		}, q.Gaps)
+		if assert.Len(t, q.Outliers, 2) {
+			// The jump and the way back
+			assert.Equal(t, start+115*minute, q.Outliers[0].OpenTime)
+		}
+		assert.False(t, q.Clean())
+	})
+}
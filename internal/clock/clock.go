@@ -0,0 +1,40 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Simulated only moves when told to, so backtests can stamp orders with candle time.
+type Simulated struct {
+	now  time.Time
+	lock sync.RWMutex
+}
+
+func NewSimulated(now time.Time) *Simulated {
+	return &Simulated{now: now}
+}
+
+func (c *Simulated) Now() time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.now
+}
+
+func (c *Simulated) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = now
+}
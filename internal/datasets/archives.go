@@ -0,0 +1,215 @@
+package datasets
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// Iterator hands out records in time order, io.EOF once there are no more.
+type Iterator[T any] interface {
+	Next() (T, error)
+}
+
+type slice[T any] struct {
+	records []T
+	i       int
+}
+
+// Slice iterates over records that are already in memory.
+func Slice[T any](records []T) Iterator[T] {
+	return &slice[T]{records: records}
+}
+
+func (s *slice[T]) Next() (T, error) {
+	var zero T
+	if s.i >= len(s.records) {
+		return zero, io.EOF
+	}
+	s.i++
+
+	return s.records[s.i-1], nil
+}
+
+// Records reads the rows of the stored archives of a symbol in time order,
+// an archive at a time, keeping the ones in [start, end).
+type Records[T any] struct {
+	paths  []string
+	parse  func([]string) (T, error)
+	time   func(T) int64
+	start  int64
+	end    int64
+	zip    *zip.ReadCloser
+	files  []*zip.File
+	file   io.ReadCloser
+	reader *Reader[T]
+}
+
+func OpenAggTrades(d Dataset, symbol string, start, end time.Time) (*Records[AggTrade], error) {
+	return open(d, symbol, start, end, ParseAggTrade, func(t AggTrade) int64 { return t.Time })
+}
+
+func OpenTrades(d Dataset, symbol string, start, end time.Time) (*Records[Trade], error) {
+	return open(d, symbol, start, end, ParseTrade, func(t Trade) int64 { return t.Time })
+}
+
+func OpenFundingRates(symbol string, start, end time.Time) (*Records[FundingRate], error) {
+	return open(FundingRates, symbol, start, end, ParseFundingRate, func(r FundingRate) int64 { return r.Time })
+}
+
+func open[T any](d Dataset, symbol string, start, end time.Time, parse func([]string) (T, error), time func(T) int64) (*Records[T], error) {
+	if d.Klines() {
+		return nil, globals.ErrWrongDataset
+	}
+
+	paths, err := archives(d, symbol, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", globals.ErrDatasetNotFound, d, symbol)
+	}
+
+	return &Records[T]{
+		paths: paths,
+		parse: parse,
+		time:  time,
+		start: start.UnixMilli(),
+		end:   end.UnixMilli(),
+	}, nil
+}
+
+func (r *Records[T]) Next() (T, error) {
+	var zero T
+	for {
+		if r.reader == nil {
+			err := r.nextFile()
+			if err != nil {
+				return zero, err
+			}
+		}
+
+		v, err := r.reader.Next()
+		if err == io.EOF {
+			r.file.Close()
+			r.reader = nil
+			continue
+		}
+		if err != nil {
+			return zero, err
+		}
+
+		t := r.time(v)
+		if t < r.start {
+			continue
+		}
+		if t >= r.end {
+			// Rows are in time order
+			r.paths, r.files = nil, nil
+			r.file.Close()
+			r.reader = nil
+			continue
+		}
+
+		return v, nil
+	}
+}
+
+// nextFile moves on to the next CSV file, of the same archive or the next one.
+func (r *Records[T]) nextFile() error {
+	for len(r.files) == 0 {
+		if r.zip != nil {
+			r.zip.Close()
+			r.zip = nil
+		}
+		if len(r.paths) == 0 {
+			return io.EOF
+		}
+
+		var err error
+		r.zip, err = zip.OpenReader(r.paths[0])
+		if err != nil {
+			return err
+		}
+		r.paths = r.paths[1:]
+		r.files = r.zip.File
+	}
+
+	var err error
+	r.file, err = r.files[0].Open()
+	if err != nil {
+		return err
+	}
+	r.files = r.files[1:]
+	r.reader = NewReader(r.file, r.parse)
+
+	return nil
+}
+
+func (r *Records[T]) Close() error {
+	if r.reader != nil {
+		r.file.Close()
+		r.reader = nil
+	}
+	if r.zip != nil {
+		return r.zip.Close()
+	}
+
+	return nil
+}
+
+// archives lists the stored archives of the symbol overlapping the period in
+// time order. Monthly archives stand in for the daily ones of their month.
+func archives(d Dataset, symbol string, start, end time.Time) ([]string, error) {
+	dir := d.Dir(symbol, "")
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	type archive struct {
+		path  string
+		start time.Time
+		end   time.Time
+	}
+	prefix := symbol + "-" + d.Name + "-"
+	monthly := map[time.Time]bool{}
+	found := []archive{}
+	for _, e := range entries {
+		date := strings.TrimSuffix(strings.TrimPrefix(e.Name(), prefix), ".zip")
+		if !strings.HasPrefix(e.Name(), prefix) || !strings.HasSuffix(e.Name(), ".zip") {
+			continue
+		}
+
+		if t, err := time.Parse("2006-01", date); err == nil {
+			monthly[t] = true
+			found = append(found, archive{dir + e.Name(), t, t.AddDate(0, 1, 0)})
+		} else if t, err := time.Parse("2006-01-02", date); err == nil {
+			found = append(found, archive{dir + e.Name(), t, t.AddDate(0, 0, 1)})
+		}
+	}
+
+	paths := []string{}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].start.Before(found[j].start)
+	})
+	for _, a := range found {
+		month := time.Date(a.start.Year(), a.start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		daily := a.end.Sub(a.start) < 28*24*time.Hour
+		if (daily && monthly[month]) || !a.end.After(start) || !a.start.Before(end) {
+			continue
+		}
+		paths = append(paths, a.path)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,132 @@
+package datasets
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// Dataset is one of the public datasets of data.binance.vision, ex. the
+// aggTrades of the spot market.
+type Dataset struct {
+	Market string
+	Name   string
+}
+
+var (
+	SpotKlines       = Dataset{"spot", "klines"}
+	SpotAggTrades    = Dataset{"spot", "aggTrades"}
+	SpotTrades       = Dataset{"spot", "trades"}
+	FuturesKlines    = Dataset{"futures/um", "klines"}
+	FuturesAggTrades = Dataset{"futures/um", "aggTrades"}
+	FuturesTrades    = Dataset{"futures/um", "trades"}
+	MarkPriceKlines  = Dataset{"futures/um", "markPriceKlines"}
+	IndexPriceKlines = Dataset{"futures/um", "indexPriceKlines"}
+	FundingRates     = Dataset{"futures/um", "fundingRate"}
+
+	All = []Dataset{
+		SpotKlines,
+		SpotAggTrades,
+		SpotTrades,
+		FuturesKlines,
+		FuturesAggTrades,
+		FuturesTrades,
+		MarkPriceKlines,
+		IndexPriceKlines,
+		FundingRates,
+	}
+)
+
+// Parse takes datasets the way they're laid out on data.binance.vision,
+// ex. spot/aggTrades or futures/um/fundingRate.
+func Parse(s string) (Dataset, error) {
+	for _, d := range All {
+		if d.String() == s {
+			return d, nil
+		}
+	}
+
+	return Dataset{}, globals.ErrWrongDataset
+}
+
+func (d Dataset) String() string {
+	return d.Market + "/" + d.Name
+}
+
+// Klines tells whether the dataset is made of klines, which have an interval
+// and go to a candle store.
+func (d Dataset) Klines() bool {
+	return strings.HasSuffix(d.Name, "lines")
+}
+
+// MonthlyOnly datasets aren't published in daily archives.
+func (d Dataset) MonthlyOnly() bool {
+	return d == FundingRates
+}
+
+// Filename of the archive of the day, or of the month if monthly.
+func (d Dataset) Filename(symbol, interval string, t time.Time, monthly bool) string {
+	kind := d.Name
+	if d.Klines() {
+		kind = interval
+	}
+	layout := "2006-01-02"
+	if monthly {
+		layout = "2006-01"
+	}
+
+	return fmt.Sprintf("%s-%s-%s.zip", symbol, kind, t.Format(layout))
+}
+
+// URL of the archive, ex.
+// <base_url>/data/futures/um/daily/markPriceKlines/<symbol>/<interval>/<filename>
+// <base_url>/data/spot/monthly/aggTrades/<symbol>/<filename>
+func (d Dataset) URL(symbol, interval, filename string, monthly bool) string {
+	period := "daily"
+	if monthly {
+		period = "monthly"
+	}
+
+	return fmt.Sprintf("%sdata/%s/%s/%s/%s",
+		globals.BacktestDataBaseURL,
+		d.Market,
+		period,
+		d.Name,
+		d.dir(symbol, interval)+filename,
+	)
+}
+
+// Dir is where the archives of the symbol are kept. Spot klines keep the
+// layout they had before the other datasets, flat in the data directory.
+func (d Dataset) Dir(symbol, interval string) string {
+	if d == SpotKlines {
+		return globals.BacktestDataDir
+	}
+
+	return d.root() + d.dir(symbol, interval)
+}
+
+// Store is where the klines of the dataset are kept. Spot klines are in the
+// default store backtests read from.
+func (d Dataset) Store() *candles.Store {
+	if d == SpotKlines {
+		return candles.DefaultStore()
+	}
+
+	return candles.NewStore(d.root())
+}
+
+func (d Dataset) root() string {
+	return globals.BacktestDataDir + d.String() + "/"
+}
+
+func (d Dataset) dir(symbol, interval string) string {
+	if d.Klines() {
+		return symbol + "/" + interval + "/"
+	}
+
+	return symbol + "/"
+}
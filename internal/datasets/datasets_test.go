@@ -0,0 +1,74 @@
+package datasets_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ws396/autobinance/internal/datasets"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+func TestDatasets(t *testing.T) {
+	t.Run("lays out archives like data.binance.vision", func(t *testing.T) {
+		globals.BacktestDataBaseURL = "https://data.binance.vision/"
+		day := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+
+		for _, c := range []struct {
+			dataset datasets.Dataset
+			monthly bool
+			want    string
+		}{
+			{datasets.SpotKlines, false, "https://data.binance.vision/data/spot/daily/klines/BTCUSDT/1m/BTCUSDT-1m-2022-12-20.zip"},
+			{datasets.SpotAggTrades, true, "https://data.binance.vision/data/spot/monthly/aggTrades/BTCUSDT/BTCUSDT-aggTrades-2022-12.zip"},
+			{datasets.MarkPriceKlines, false, "https://data.binance.vision/data/futures/um/daily/markPriceKlines/BTCUSDT/1m/BTCUSDT-1m-2022-12-20.zip"},
+			{datasets.FundingRates, true, "https://data.binance.vision/data/futures/um/monthly/fundingRate/BTCUSDT/BTCUSDT-fundingRate-2022-12.zip"},
+		} {
+			filename := c.dataset.Filename("BTCUSDT", "1m", day, c.monthly)
+			if got := c.dataset.URL("BTCUSDT", "1m", filename, c.monthly); got != c.want {
+				t.Errorf("wrong url for %s, got %v want %v", c.dataset, got, c.want)
+			}
+		}
+	})
+
+	t.Run("parses dataset names", func(t *testing.T) {
+		for _, d := range datasets.All {
+			got, err := datasets.Parse(d.String())
+			if err != nil || got != d {
+				t.Errorf("failed to parse %s, got %v", d, got)
+			}
+		}
+		if _, err := datasets.Parse("futures/cm/klines"); err != globals.ErrWrongDataset {
+			t.Errorf("expected wrong dataset error, got %v", err)
+		}
+	})
+
+	t.Run("parses rows and skips headers", func(t *testing.T) {
+		r := datasets.NewReader(strings.NewReader(
+			"calc_time,funding_interval_hours,last_funding_rate\n1671494400000,8,0.00010000\n",
+		), datasets.ParseFundingRate)
+
+		rate, err := r.Next()
+		if err != nil || rate != (datasets.FundingRate{Time: 1671494400000, IntervalHours: 8, Rate: 0.0001}) {
+			t.Errorf("wrong funding rate, got %+v %v", rate, err)
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Errorf("expected the end of rows, got %v", err)
+		}
+	})
+
+	t.Run("fails on malformed rows", func(t *testing.T) {
+		r := datasets.NewReader(strings.NewReader(
+			"1,16800.1,0.5,1,3,1671498000000,true\n2,16800.1,,1,3,1671498000000,true\n",
+		), datasets.ParseAggTrade)
+
+		if _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Next(); !errors.Is(err, globals.ErrMalformedRecord) || !strings.Contains(err.Error(), "row 2") {
+			t.Errorf("expected malformed record on row 2, got %v", err)
+		}
+	})
+}
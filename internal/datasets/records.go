@@ -0,0 +1,178 @@
+package datasets
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// AggTrade is a trade aggregated over the fills of a taker order at the same
+// price. Times are in ms, the µs of newer archives are turned into ms.
+type AggTrade struct {
+	ID           int64
+	Price        float64
+	Quantity     float64
+	FirstTradeID int64
+	LastTradeID  int64
+	Time         int64
+	BuyerMaker   bool
+	// Spot only
+	BestMatch bool
+}
+
+type Trade struct {
+	ID            int64
+	Price         float64
+	Quantity      float64
+	QuoteQuantity float64
+	Time          int64
+	BuyerMaker    bool
+	// Spot only
+	BestMatch bool
+}
+
+// FundingRate of perpetual futures, paid every IntervalHours.
+type FundingRate struct {
+	Time          int64
+	IntervalHours int
+	Rate          float64
+}
+
+// ParseAggTrade takes the rows of spot and futures aggTrades archives:
+// id, price, quantity, first trade id, last trade id, time, buyer maker
+// and, on spot, best match.
+func ParseAggTrade(record []string) (AggTrade, error) {
+	if len(record) < 7 {
+		return AggTrade{}, globals.ErrMalformedRecord
+	}
+
+	p := &parser{}
+	t := AggTrade{
+		ID:           p.int(record[0]),
+		Price:        p.float(record[1]),
+		Quantity:     p.float(record[2]),
+		FirstTradeID: p.int(record[3]),
+		LastTradeID:  p.int(record[4]),
+		Time:         candles.Millis(p.int(record[5])),
+		BuyerMaker:   p.bool(record[6]),
+	}
+	if len(record) > 7 {
+		t.BestMatch = p.bool(record[7])
+	}
+
+	return t, p.err
+}
+
+// ParseTrade takes the rows of spot and futures trades archives: id, price,
+// quantity, quote quantity, time, buyer maker and, on spot, best match.
+func ParseTrade(record []string) (Trade, error) {
+	if len(record) < 6 {
+		return Trade{}, globals.ErrMalformedRecord
+	}
+
+	p := &parser{}
+	t := Trade{
+		ID:            p.int(record[0]),
+		Price:         p.float(record[1]),
+		Quantity:      p.float(record[2]),
+		QuoteQuantity: p.float(record[3]),
+		Time:          candles.Millis(p.int(record[4])),
+		BuyerMaker:    p.bool(record[5]),
+	}
+	if len(record) > 6 {
+		t.BestMatch = p.bool(record[6])
+	}
+
+	return t, p.err
+}
+
+// ParseFundingRate takes the rows of fundingRate archives: time, interval
+// in hours and rate.
+func ParseFundingRate(record []string) (FundingRate, error) {
+	if len(record) < 3 {
+		return FundingRate{}, globals.ErrMalformedRecord
+	}
+
+	p := &parser{}
+	r := FundingRate{
+		Time:          candles.Millis(p.int(record[0])),
+		IntervalHours: int(p.int(record[1])),
+		Rate:          p.float(record[2]),
+	}
+
+	return r, p.err
+}
+
+// Reader parses the rows of an archive as they're read, skipping the header
+// some of them start with.
+type Reader[T any] struct {
+	csv   *csv.Reader
+	parse func([]string) (T, error)
+	row   int
+}
+
+func NewReader[T any](r io.Reader, parse func([]string) (T, error)) *Reader[T] {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	reader.FieldsPerRecord = -1
+
+	return &Reader[T]{csv: reader, parse: parse}
+}
+
+func (r *Reader[T]) Next() (T, error) {
+	var zero T
+	record, err := r.csv.Read()
+	if err != nil {
+		return zero, err
+	}
+	r.row++
+
+	v, err := r.parse(record)
+	if err != nil && r.row == 1 && !isNumber(record[0]) {
+		return r.Next()
+	}
+	if err != nil {
+		return zero, fmt.Errorf("%w: row %d", err, r.row)
+	}
+
+	return v, nil
+}
+
+// parser keeps the first error, so a row is checked once at the end.
+type parser struct {
+	err error
+}
+
+func (p *parser) int(s string) int64 {
+	v, err := strconv.ParseInt(s, 10, 64)
+	p.fail(err)
+	return v
+}
+
+func (p *parser) float(s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	p.fail(err)
+	return v
+}
+
+func (p *parser) bool(s string) bool {
+	v, err := strconv.ParseBool(strings.ToLower(s))
+	p.fail(err)
+	return v
+}
+
+func (p *parser) fail(err error) {
+	if err != nil && p.err == nil {
+		p.err = globals.ErrMalformedRecord
+	}
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
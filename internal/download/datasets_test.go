@@ -0,0 +1,201 @@
+package download
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/datasets"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+func TestDownloadDatasets(t *testing.T) {
+	symbol := "BTCUSDT"
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2022, 12, 21, 0, 0, 0, 0, time.UTC)
+	ms := func(t time.Time) int64 { return t.UnixMilli() }
+
+	rows := map[datasets.Dataset]func(day time.Time) string{
+		datasets.FuturesAggTrades: func(day time.Time) string {
+			return "agg_trade_id,price,quantity,first_trade_id,last_trade_id,transact_time,is_buyer_maker\n" +
+				fmt.Sprintf("%d,16800.1,0.5,1,3,%d,true\n", day.Day(), ms(day.Add(time.Hour))) +
+				fmt.Sprintf("%d,16801.2,0.25,4,4,%d,false\n", day.Day()*10, ms(day.Add(2*time.Hour)))
+		},
+		datasets.SpotTrades: func(day time.Time) string {
+			return fmt.Sprintf("%d,16800.1,0.5,8400.05,%d,True,True\n", day.Day(), ms(day.Add(time.Hour)))
+		},
+		datasets.MarkPriceKlines: func(day time.Time) string {
+			return "open_time,open,high,low,close,volume,close_time,quote_volume,count,taker_buy_volume,taker_buy_quote_volume,ignore\n" +
+				fmt.Sprintf("%d,16800,16810,16790,16805,0,%d,0,60,0,0,0\n", ms(day), ms(day.Add(time.Minute))-1)
+		},
+		datasets.FundingRates: func(month time.Time) string {
+			return "calc_time,funding_interval_hours,last_funding_rate\n" +
+				fmt.Sprintf("%d,8,0.0001\n%d,8,-0.00005\n", ms(start), ms(start.Add(8*time.Hour)))
+		},
+	}
+
+	globals.BacktestDataBaseURL = "/"
+	mux := http.NewServeMux()
+	for d, row := range rows {
+		for _, a := range generateArchives(d, symbol, "1m", start, end) {
+			data := zipped(t, strings.TrimSuffix(a.url[strings.LastIndex(a.url, "/")+1:], ".zip")+".csv", row(a.start))
+			mux.HandleFunc(a.url, func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "archive.zip", time.Now(), bytes.NewReader(data))
+			})
+			mux.HandleFunc(a.url+".CHECKSUM", func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, "%x  archive.zip\n", sha256.Sum256(data))
+			})
+		}
+	}
+	// Only the first day is published
+	partial := generateArchives(datasets.IndexPriceKlines, symbol, "1m", start, end)[0]
+	partialData := zipped(t, "index.csv", rows[datasets.MarkPriceKlines](partial.start))
+	mux.HandleFunc(partial.url, func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "archive.zip", time.Now(), bytes.NewReader(partialData))
+	})
+	mux.HandleFunc(partial.url+".CHECKSUM", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%x  archive.zip\n", sha256.Sum256(partialData))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	globals.BacktestDataBaseURL = ts.URL + "/"
+	globals.BacktestDataDir = t.TempDir() + "/"
+
+	for d := range rows {
+		err := Download(context.Background(), Config{
+			Dataset:   d,
+			Symbols:   []string{symbol},
+			Timeframe: "1m",
+			Start:     start,
+			End:       end,
+		})
+		if err != nil {
+			t.Fatalf("failed to download %s: %s", d, err)
+		}
+	}
+
+	t.Run("reads trades from the archives", func(t *testing.T) {
+		records, err := datasets.OpenAggTrades(datasets.FuturesAggTrades, symbol, start.Add(90*time.Minute), end.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer records.Close()
+
+		got := []datasets.AggTrade{}
+		for {
+			trade, err := records.Next()
+			if err != nil {
+				break
+			}
+			got = append(got, trade)
+		}
+		if len(got) != 3 || got[0].ID != 200 || got[0].Price != 16801.2 || got[0].BuyerMaker || got[1].ID != 21 || got[1].LastTradeID != 3 {
+			t.Errorf("wrong aggTrades in the period, got %+v", got)
+		}
+
+		trades, err := datasets.OpenTrades(datasets.SpotTrades, symbol, start, end.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer trades.Close()
+		trade, err := trades.Next()
+		if err != nil || trade.QuoteQuantity != 8400.05 || !trade.BestMatch {
+			t.Errorf("wrong trade, got %+v %v", trade, err)
+		}
+	})
+
+	t.Run("reads funding rates from monthly archives", func(t *testing.T) {
+		records, err := datasets.OpenFundingRates(symbol, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer records.Close()
+
+		first, _ := records.Next()
+		second, _ := records.Next()
+		if first.Rate != 0.0001 || second.Rate != -0.00005 || second.IntervalHours != 8 {
+			t.Errorf("wrong funding rates, got %+v %+v", first, second)
+		}
+	})
+
+	t.Run("stores kline datasets apart", func(t *testing.T) {
+		cursor, err := datasets.MarkPriceKlines.Store().Range(symbol, "1m", start, end.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := candles.Collect(candles.Klines(cursor))
+		cursor.Close()
+		if err != nil || len(got) != 2 || got[0].Close != "16805" {
+			t.Errorf("wrong mark price klines, got %v %v", len(got), err)
+		}
+
+		if _, err := candles.DefaultStore().Range(symbol, "1m", start, end); err == nil {
+			t.Error("expected mark price klines to stay out of the spot store")
+		}
+	})
+
+	t.Run("stores the archives that came in when others fail", func(t *testing.T) {
+		progress := make(chan Progress)
+		failed := []string{}
+		drained := make(chan struct{})
+		go func() {
+			defer close(drained)
+			for p := range progress {
+				if p.Err != nil {
+					failed = append(failed, p.File)
+				}
+			}
+		}()
+
+		err := Download(context.Background(), Config{
+			Dataset:   datasets.IndexPriceKlines,
+			Symbols:   []string{symbol},
+			Timeframe: "1m",
+			Start:     start,
+			End:       end,
+			Progress:  progress,
+		})
+		if !errors.Is(err, globals.ErrCouldNotDownloadFile) {
+			t.Errorf("expected missing archive error, got %v", err)
+		}
+
+		<-drained
+		if len(failed) != 1 || failed[0] != "BTCUSDT-1m-2022-12-21.zip" {
+			t.Errorf("expected only the missing archive to fail, got %v", failed)
+		}
+
+		cursor, err := datasets.IndexPriceKlines.Store().Range(symbol, "1m", start, end.Add(24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := candles.Collect(candles.Klines(cursor))
+		cursor.Close()
+		if err != nil || len(got) != 1 {
+			t.Errorf("expected the klines of the published day, got %v %v", len(got), err)
+		}
+	})
+}
+
+func zipped(t *testing.T, name, content string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	f, err := w.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(content))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
@@ -2,222 +2,341 @@ package download
 
 import (
 	"archive/zip"
-	"encoding/csv"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/datasets"
 	"github.com/ws396/autobinance/internal/globals"
 )
 
+const DefaultWorkers = 4
+
+type Config struct {
+	// Spot klines if not set
+	Dataset datasets.Dataset
+	Symbols []string
+	// Interval of kline datasets
+	Timeframe string
+	Start     time.Time
+	End       time.Time
+	// Archives downloaded at once, DefaultWorkers if not set
+	Workers int
+	// Gets a snapshot whenever an archive is done or failed, and every
+	// ProgressInterval in between. It's closed once the download is over
+	Progress chan<- Progress
+	// Gets the quality report of every file of klines as it's stored, from
+	// the workers
+	Quality func(candles.Quality)
+}
+
+// Progress of a download. Bytes only count what's been downloaded, archives
+// that were already there don't add to them.
+type Progress struct {
+	Queued int
+	Done   int
+	Failed int
+	Bytes  int64
+	ETA    time.Duration
+	// Archive the snapshot was sent for, empty on the periodic ones
+	File string
+	Err  error
+}
+
+func (p Progress) Finished() int {
+	return p.Done + p.Failed
+}
+
+var ProgressInterval = 250 * time.Millisecond
+
+// WorkersFromEnv reads the amount of download workers from .env.
+func WorkersFromEnv() (int, error) {
+	s := os.Getenv("DOWNLOAD_WORKERS")
+	if s == "" {
+		return DefaultWorkers, nil
+	}
+
+	workers, err := strconv.Atoi(s)
+	if err != nil || workers <= 0 {
+		return 0, globals.ErrWrongDownloadWorkers
+	}
+
+	return workers, nil
+}
+
+// KlinesFromZips downloads the klines of the period for every symbol.
+func KlinesFromZips(symbols []string, timeframe string, start, end time.Time) error {
+	return Download(context.Background(), Config{
+		Symbols:   symbols,
+		Timeframe: timeframe,
+		Start:     start,
+		End:       end,
+	})
+}
+
+// Download gets the archives of the dataset for every symbol with a pool of
+// workers. Klines are stored archive by archive as they come in, other
+// datasets are read from the archives with the datasets package. Archives
+// that fail don't stop the others, and only theirs are missing from the
+// store. The first error is returned at the end. Cancelling the context
+// stops the download, what's been downloaded of the archives in flight is
+// picked up next time.
+//
 // Works better with full months.
 //
 // Endpoint formats:
 //
-// <base_url>/data/spot/monthly/klines/<symbol_in_uppercase>/<interval>/<symbol_in_uppercase>-<interval>-<year>-<month>.zip
-// <base_url>/data/spot/daily/klines/<symbol_in_uppercase>/<interval>/<symbol_in_uppercase>-<interval>-<year>-<month>-<day>.zip
-func KlinesCSVFromZips(symbols []string, timeframe string, start, end time.Time) error {
-	wg := &sync.WaitGroup{}
-	errChan := make(chan error)
-	errChanOuter := make(chan error)
-
-	for _, symbol := range symbols {
-		go func(symbol string) {
-			filepaths, urls := generateFilepathsAndURLs(symbol, timeframe, start, end)
-
-			for i := 0; i < len(filepaths) && i < len(urls); i++ {
-				wg.Add(1)
-				go func(f, url string) {
-					errChan <- downloadFile(f, url)
-					wg.Done()
-				}(filepaths[i], urls[i])
-			}
-			for i := 0; i < len(filepaths) && i < len(urls); i++ {
-				if err := <-errChan; err != nil {
-					errChanOuter <- err
-				}
-			}
-
-			wg.Wait()
-
-			// May be better to do this separately
-			err := extractKlinesToCSV(
-				filepaths,
-				getCSVPath(symbol, timeframe, start, end),
-			)
-			if err != nil {
-				errChanOuter <- err
-			}
-
-			for _, filepath := range filepaths {
-				err = os.Remove(filepath)
-				if err != nil {
-					errChanOuter <- err
-				}
-			}
+// <base_url>/data/<market>/monthly/<dataset>/<symbol_in_uppercase>/[<interval>/]<symbol_in_uppercase>-<interval_or_dataset>-<year>-<month>.zip
+// <base_url>/data/<market>/daily/<dataset>/<symbol_in_uppercase>/[<interval>/]<symbol_in_uppercase>-<interval_or_dataset>-<year>-<month>-<day>.zip
+//
+// Klines go to the candle store of the dataset, merged with what's already
+// there. Archives are kept, so they're only downloaded again if they turn out
+// to be broken.
+func Download(ctx context.Context, config Config) error {
+	if config.Progress != nil {
+		defer close(config.Progress)
+	}
+	d := config.Dataset
+	if d == (datasets.Dataset{}) {
+		d = datasets.SpotKlines
+	}
 
-			errChanOuter <- nil
-		}(symbol)
+	archives := []archive{}
+	for _, symbol := range config.Symbols {
+		archives = append(archives, generateArchives(d, symbol, config.Timeframe, config.Start, config.End)...)
 	}
 
-	for i := 0; i < len(symbols); i++ {
-		if err := <-errChanOuter; err != nil {
+	return fetchArchives(ctx, archives, config.Workers, config.Progress, func(a archive, err error) error {
+		if err != nil || !d.Klines() {
 			return err
 		}
-	}
 
-	return nil
+		return newSink(d, a.timeframe, config.Quality).storeZip(a.symbol, a.timeframe, a.path)
+	})
 }
 
-func generateFilepathsAndURLs(symbol, timeframe string, start, end time.Time) ([]string, []string) {
-	var filename, url string
+type archive struct {
+	symbol    string
+	timeframe string
+	path      string
+	url       string
+	// Period the archive covers
+	start time.Time
+	end   time.Time
+}
 
-	filepaths := []string{}
-	urls := []string{}
-	timepoint := start
+// fetchArchives downloads the archives on a pool of workers, handing every
+// one to finished once it's downloaded or failed. What finished returns is
+// what the archive is counted as, and the first error is returned at the end
+// along with how many more failed.
+func fetchArchives(ctx context.Context, archives []archive, workers int, out chan<- Progress, finished func(a archive, err error) error) error {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
 
-	for !timepoint.After(end) {
-		if timepoint.Day() != 1 || timepoint.Month() == end.Month() {
-			filename = getZipNameDaily(symbol, timeframe, timepoint)
-			url = getURLDaily(symbol, timeframe, filename)
-			timepoint = timepoint.AddDate(0, 0, 1)
-		} else {
-			filename = getZipNameMonthly(symbol, timeframe, timepoint)
-			url = getURLMonthly(symbol, timeframe, filename)
-			timepoint = timepoint.AddDate(0, 1, 0)
+	t := &tracker{
+		ctx:      ctx,
+		progress: Progress{Queued: len(archives)},
+		started:  time.Now(),
+		out:      out,
+	}
+	stopTicking := t.tick()
+	defer stopTicking()
+
+	queue := make(chan archive)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for a := range queue {
+				err := downloadFile(ctx, a.path, a.url, &t.bytes)
+				t.finish(filepath.Base(a.path), finished(a, err))
+			}
+		}()
+	}
+
+	for _, a := range archives {
+		select {
+		case queue <- a:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
+	}
+	close(queue)
+	wg.Wait()
 
-		filepaths = append(filepaths, globals.BacktestDataDir+filename)
-		urls = append(urls, url)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if more := t.progress.Failed - 1; more > 0 {
+		return fmt.Errorf("%w, and %d more archives failed", t.err, more)
 	}
 
-	return filepaths, urls
+	return t.err
 }
 
-func getZipNameDaily(symbol, timeframe string, timepoint time.Time) string {
-	return fmt.Sprintf("%s-%s-%s.zip",
-		symbol,
-		timeframe,
-		timepoint.Format("2006-01-02"),
-	)
+// tracker keeps count of the archives for the progress.
+type tracker struct {
+	// Snapshots aren't waited on once it's done
+	ctx      context.Context
+	progress Progress
+	started  time.Time
+	out      chan<- Progress
+	// Bytes downloaded, added to by the workers as they go
+	bytes int64
+	err   error
+	lock  sync.Mutex
 }
 
-func getZipNameMonthly(symbol, timeframe string, timepoint time.Time) string {
-	return fmt.Sprintf("%s-%s-%s.zip",
-		symbol,
-		timeframe,
-		timepoint.Format("2006-01"),
-	)
-}
+func (t *tracker) finish(file string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
-func getURLDaily(symbol, timeframe, filename string) string {
-	return fmt.Sprintf(
-		"%sdata/spot/daily/klines/%s/%s/%s",
-		globals.BacktestDataBaseURL,
-		symbol,
-		timeframe,
-		filename,
-	)
-}
+	if err != nil {
+		t.progress.Failed++
+		if t.err == nil {
+			t.err = err
+		}
+	} else {
+		t.progress.Done++
+	}
 
-func getURLMonthly(symbol, timeframe, filename string) string {
-	return fmt.Sprintf(
-		"%sdata/spot/daily/klines/%s/%s/%s",
-		globals.BacktestDataBaseURL,
-		symbol,
-		timeframe,
-		filename,
-	)
+	t.send(file, err)
 }
 
-func getCSVPath(symbol, timeframe string, start, end time.Time) string {
-	return fmt.Sprintf(
-		"%s%s_%s_%s_%s.csv",
-		globals.BacktestDataDir,
-		symbol,
-		timeframe,
-		start.Format("02-01-2006"),
-		end.Format("02-01-2006"),
-	)
-}
+// send has to be called with the lock held.
+func (t *tracker) send(file string, err error) {
+	if t.out == nil {
+		return
+	}
 
-func extractKlinesToCSV(srcs []string, dest string) error {
-	allRecords := [][]string{}
+	p := t.progress
+	p.File = file
+	p.Err = err
+	p.Bytes = atomic.LoadInt64(&t.bytes)
+	if finished := p.Finished(); finished > 0 {
+		elapsed := time.Since(t.started)
+		p.ETA = elapsed / time.Duration(finished) * time.Duration(p.Queued-finished)
+	}
 
-	for _, src := range srcs {
-		r, err := zip.OpenReader(src)
-		if err != nil {
-			return err
-		}
-		defer r.Close()
+	select {
+	case t.out <- p:
+	case <-t.ctx.Done():
+	}
+}
 
-		for _, f := range r.File {
-			rc, err := f.Open()
-			if err != nil {
-				return err
-			}
-			defer rc.Close()
+// tick sends snapshots in between archives, so the bytes keep moving.
+func (t *tracker) tick() func() {
+	if t.out == nil {
+		return func() {}
+	}
 
-			reader := csv.NewReader(rc)
-			records, err := reader.ReadAll()
-			if err != nil {
-				return err
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(ProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.lock.Lock()
+				t.send("", nil)
+				t.lock.Unlock()
+			case <-done:
+				return
+			case <-t.ctx.Done():
+				return
 			}
-
-			allRecords = append(allRecords, records...)
 		}
-	}
+	}()
 
-	os.MkdirAll(filepath.Dir(dest), 0755)
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
 
-	f, err := os.Create(dest)
-	if err != nil {
-		return err
+// generateArchives takes daily archives up to the first of a month, and
+// monthly ones from there, except for the month of the end.
+func generateArchives(d datasets.Dataset, symbol, interval string, start, end time.Time) []archive {
+	archives := []archive{}
+	timepoint := start
+	if d.MonthlyOnly() {
+		timepoint = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
 	}
-	defer f.Close()
 
-	w := csv.NewWriter(f)
-	for _, candle := range allRecords {
-		err := w.Write(candle)
-		if err != nil {
-			return err
+	for !timepoint.After(end) {
+		a := archive{symbol: symbol, timeframe: interval, start: timepoint}
+		monthly := d.MonthlyOnly() || (timepoint.Day() == 1 && timepoint.Month() != end.Month())
+		if monthly {
+			a.end = timepoint.AddDate(0, 1, 0)
+		} else {
+			a.end = timepoint.AddDate(0, 0, 1)
 		}
-	}
 
-	w.Flush()
+		filename := d.Filename(symbol, interval, timepoint, monthly)
+		a.path = d.Dir(symbol, interval) + filename
+		a.url = d.URL(symbol, interval, filename, monthly)
+		archives = append(archives, a)
+		timepoint = a.end
+	}
 
-	return nil
+	return archives
 }
 
-func downloadFile(dest string, url string) error {
-	os.MkdirAll(filepath.Dir(dest), 0755)
+// sink validates the klines of archives on their way to the store.
+type sink struct {
+	store   *candles.Store
+	checks  candles.Checks
+	quality func(candles.Quality)
+}
 
-	// Create the file
-	out, err := os.Create(dest)
-	if err != nil {
-		return err
+func newSink(d datasets.Dataset, timeframe string, quality func(candles.Quality)) sink {
+	checks := candles.DefaultChecks
+	checks.Step = globals.Durations[timeframe]
+	if d == datasets.MarkPriceKlines || d == datasets.IndexPriceKlines {
+		// There's no volume to them
+		checks.ZeroVolumeRun = 0
 	}
-	defer out.Close()
 
-	// Get the data
-	resp, err := http.Get(url)
+	return sink{d.Store(), checks, quality}
+}
+
+// Rows are parsed as they're read from the archive, so nothing but a month
+// of candles is held in memory.
+func (s sink) storeZip(symbol, timeframe, src string) error {
+	r, err := zip.OpenReader(src)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	defer r.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return globals.ErrCouldNotDownloadFile
-	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
+		v := candles.NewValidator(candles.NewReader(rc), filepath.Base(src)+"/"+f.Name, s.checks)
+		err = s.store.Write(symbol, timeframe, v)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(src), err)
+		}
+		if s.quality != nil {
+			s.quality(v.Quality())
+		}
 	}
 
 	return nil
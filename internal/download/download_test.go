@@ -1,16 +1,23 @@
 package download
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/datasets"
 	"github.com/ws396/autobinance/internal/globals"
 )
 
@@ -27,7 +34,7 @@ func TestDownload(t *testing.T) {
 	})
 }
 
-func TestKlinesCSVFromZips(t *testing.T) {
+func TestKlinesFromZips(t *testing.T) {
 	rootpath, _ := os.Getwd() // Might want to deal with paths some other way
 	rootpath += "/../../"
 	globals.BacktestDataDir = ""
@@ -40,65 +47,331 @@ func TestKlinesCSVFromZips(t *testing.T) {
 	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
 	end := time.Date(2022, 12, 21, 0, 0, 0, 0, time.UTC)
 
-	t.Run("downloads and extracts klines to csv from zip files", func(t *testing.T) {
-		mux := http.NewServeMux()
-
-		for _, s := range symbols {
-			filenames, urls := generateFilepathsAndURLs(s, timeframe, start, end)
-
-			for i := 0; i < len(filenames) && i < len(urls); i++ {
-				url := urls[i]
-				filename := filenames[i]
-				mux.HandleFunc(url, func(w http.ResponseWriter, r *http.Request) {
-					filepath := rootpath + globals.TestDataDir + "/test_" + filename
-					data, err := ioutil.ReadFile(filepath)
-					if err != nil {
-						t.Errorf("failed to read file %s", err)
-					}
-
-					http.ServeContent(w, r, filepath, time.Now(), bytes.NewReader(data))
-				})
-			}
-		}
-
-		ts := httptest.NewServer(mux)
+	t.Run("downloads and stores klines from zip files", func(t *testing.T) {
+		ts := serveArchives(t, rootpath, symbols, timeframe, start, end)
 		defer ts.Close()
 
 		globals.BacktestDataDir = t.TempDir() + "/"
 		globals.BacktestDataBaseURL = ts.URL + "/"
-		err := KlinesCSVFromZips(symbols, timeframe, start, end)
+		err := KlinesFromZips(symbols, timeframe, start, end)
 		if err != nil {
 			t.Errorf("failed to generate csv %s", err)
 		}
 
+		// Downloading again skips the archives and merges into what's stored
+		err = KlinesFromZips(symbols, timeframe, start, end)
+		if err != nil {
+			t.Errorf("failed to download again %s", err)
+		}
+
+		store := candles.DefaultStore()
 		for _, s := range symbols {
-			filename := filepath.Base(getCSVPath(s, timeframe, start, end))
-			filepath1 := globals.BacktestDataDir + filename
-			filepath2 := rootpath + globals.TestDataDir + "/test_" + filename
-			file1, err := os.Open(filepath1)
+			filename := fmt.Sprintf("test_%s_%s_%s_%s.csv", s, timeframe, start.Format("02-01-2006"), end.Format("02-01-2006"))
+			r, err := candles.Open(rootpath + globals.TestDataDir + filename)
 			if err != nil {
-				t.Error(err)
+				t.Fatal(err)
 			}
-			file2, err := os.Open(filepath2)
+			want, err := candles.Collect(candles.Klines(r))
+			r.Close()
 			if err != nil {
-				t.Error(err)
+				t.Fatal(err)
 			}
 
-			fileScanner1 := bufio.NewScanner(file1)
-			fileScanner2 := bufio.NewScanner(file2)
+			cursor, err := store.Range(s, timeframe, start, end.Add(24*time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := candles.Collect(candles.Klines(cursor))
+			cursor.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
 
-			for fileScanner1.Scan() && fileScanner2.Scan() {
-				got := fileScanner1.Text()
-				want := fileScanner2.Text()
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("wrong klines stored on symbol %s, got %v want %v", s, len(got), len(want))
+			}
 
-				if got != want {
-					t.Errorf("wrong csv file generated on symbol %s, got %v want %v", s, got, want)
-				}
+			index, err := store.Index(s, timeframe)
+			if err != nil || len(index) != 1 || index[0].Count != len(want) {
+				t.Errorf("wrong index on symbol %s, got %+v", s, index)
 			}
+		}
+	})
+}
+
+func TestKlines(t *testing.T) {
+	rootpath, _ := os.Getwd()
+	rootpath += "/../../"
+	symbols := []string{
+		"BTCBUSD",
+		"LTCBTC",
+	}
+	timeframe := "1m"
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2022, 12, 21, 0, 0, 0, 0, time.UTC)
+	globals.BacktestDataDir = ""
+	globals.BacktestDataBaseURL = "/"
+	ts := serveArchives(t, rootpath, symbols, timeframe, start, end)
+	defer ts.Close()
+	globals.BacktestDataBaseURL = ts.URL + "/"
+	backoff = time.Millisecond
+
+	var qualities []candles.Quality
+	lock := sync.Mutex{}
+	download := func(ctx context.Context, symbols []string) ([]Progress, error) {
+		qualities = nil
+		progress := make(chan Progress)
+		done := make(chan error)
+		go func() {
+			done <- Download(ctx, Config{
+				Symbols:   symbols,
+				Timeframe: timeframe,
+				Start:     start,
+				End:       end,
+				Workers:   2,
+				Progress:  progress,
+				Quality: func(q candles.Quality) {
+					lock.Lock()
+					qualities = append(qualities, q)
+					lock.Unlock()
+				},
+			})
+		}()
 
-			if fileScanner1.Scan() || fileScanner2.Scan() {
-				t.Errorf("wrong amount of rows generated on symbol %s", s)
+		events := []Progress{}
+		for p := range progress {
+			events = append(events, p)
+		}
+		return events, <-done
+	}
+
+	t.Run("reports progress of every archive", func(t *testing.T) {
+		globals.BacktestDataDir = t.TempDir() + "/"
+
+		events, err := download(context.Background(), symbols)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var files, size int
+		for _, s := range symbols {
+			archives := generateArchives(datasets.SpotKlines, s, timeframe, start, end)
+			files += len(archives)
+			for _, a := range archives {
+				info, _ := os.Stat(a.path)
+				size += int(info.Size())
+			}
+		}
+		last := events[len(events)-1]
+		if last.Queued != files || last.Done != files || last.Failed != 0 || last.Bytes != int64(size) || last.ETA != 0 {
+			t.Errorf("expected %v archives and %v bytes done, got %+v", files, size, last)
+		}
+		if len(qualities) != files {
+			t.Errorf("expected a quality report for every file, got %+v", qualities)
+		}
+		for _, q := range qualities {
+			if !q.Clean() || q.Rows == 0 || q.Valid != q.Rows {
+				t.Errorf("expected clean testdata, got %+v", q)
+			}
+		}
+		for _, s := range symbols {
+			if index, err := candles.DefaultStore().Index(s, timeframe); err != nil || len(index) == 0 {
+				t.Errorf("expected klines stored on symbol %s, got %v", s, err)
+			}
+		}
+	})
+
+	t.Run("stores the symbols that did not fail", func(t *testing.T) {
+		globals.BacktestDataDir = t.TempDir() + "/"
+
+		events, err := download(context.Background(), append([]string{"MISSING"}, symbols...))
+		if !errors.Is(err, globals.ErrCouldNotDownloadFile) {
+			t.Errorf("expected could not download error, got %v", err)
+		}
+
+		last := events[len(events)-1]
+		if last.Failed == 0 || last.Finished() != last.Queued {
+			t.Errorf("expected failed archives to be counted, got %+v", last)
+		}
+		for _, s := range symbols {
+			if index, err := candles.DefaultStore().Index(s, timeframe); err != nil || len(index) == 0 {
+				t.Errorf("expected klines stored on symbol %s, got %v", s, err)
 			}
 		}
 	})
+
+	t.Run("stops on cancel", func(t *testing.T) {
+		globals.BacktestDataDir = t.TempDir() + "/"
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := download(ctx, symbols)
+		if err != context.Canceled {
+			t.Errorf("expected the download to be cancelled, got %v", err)
+		}
+		if index, _ := candles.DefaultStore().Index(symbols[0], timeframe); len(index) != 0 {
+			t.Error("expected nothing stored after cancelling")
+		}
+	})
+
+	t.Run("stops on cancel when the progress isn't read", func(t *testing.T) {
+		globals.BacktestDataDir = t.TempDir() + "/"
+		ctx, cancel := context.WithCancel(context.Background())
+		progress := make(chan Progress)
+		done := make(chan error)
+		go func() {
+			done <- Download(ctx, Config{
+				Symbols:   symbols,
+				Timeframe: timeframe,
+				Start:     start,
+				End:       end,
+				Workers:   2,
+				Progress:  progress,
+			})
+		}()
+
+		// The reader goes away after the first snapshot
+		<-progress
+		cancel()
+		select {
+		case err := <-done:
+			if err != context.Canceled {
+				t.Errorf("expected the download to be cancelled, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the download to stop with nobody reading the progress")
+		}
+	})
+}
+
+func serveArchives(t *testing.T, rootpath string, symbols []string, timeframe string, start, end time.Time) *httptest.Server {
+	mux := http.NewServeMux()
+
+	for _, s := range symbols {
+		for _, a := range generateArchives(datasets.SpotKlines, s, timeframe, start, end) {
+			url := a.url
+			filename := filepath.Base(a.path)
+			mux.HandleFunc(url, func(w http.ResponseWriter, r *http.Request) {
+				filepath := rootpath + globals.TestDataDir + "/test_" + filename
+				data, err := ioutil.ReadFile(filepath)
+				if err != nil {
+					t.Errorf("failed to read file %s", err)
+				}
+
+				http.ServeContent(w, r, filepath, time.Now(), bytes.NewReader(data))
+			})
+			mux.HandleFunc(url+".CHECKSUM", func(w http.ResponseWriter, r *http.Request) {
+				data, err := ioutil.ReadFile(rootpath + globals.TestDataDir + "/test_" + filename)
+				if err != nil {
+					t.Errorf("failed to read file %s", err)
+				}
+
+				fmt.Fprintf(w, "%x  %s\n", sha256.Sum256(data), filename)
+			})
+		}
+	}
+
+	return httptest.NewServer(mux)
+}
+
+func TestDownloadFile(t *testing.T) {
+	rootpath, _ := os.Getwd()
+	data, err := ioutil.ReadFile(rootpath + "/../../" + globals.TestDataDir + "test_LTCBTC-1m-2022-12-20.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := fmt.Sprintf("%x  LTCBTC-1m-2022-12-20.zip\n", sha256.Sum256(data))
+	backoff = time.Millisecond
+
+	var requests, ranges, failures int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/archive.zip", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get("Range") != "" {
+			ranges++
+		}
+		http.ServeContent(w, r, "archive.zip", time.Now(), bytes.NewReader(data))
+	})
+	mux.HandleFunc("/archive.zip.CHECKSUM", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, checksum)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	dest := t.TempDir() + "/archive.zip"
+	reset := func() {
+		requests, ranges, failures = 0, 0, 0
+		os.Remove(dest)
+		os.Remove(dest + ".part")
+	}
+	valid := func() bool {
+		got, err := ioutil.ReadFile(dest)
+		return err == nil && bytes.Equal(got, data)
+	}
+
+	t.Run("resumes partial downloads", func(t *testing.T) {
+		reset()
+		ioutil.WriteFile(dest+".part", data[:len(data)/2], 0644)
+
+		err := downloadFile(context.Background(), dest, ts.URL+"/archive.zip", nil)
+		if err != nil || !valid() || ranges != 1 {
+			t.Errorf("expected the download to resume, got %v with %v range requests", err, ranges)
+		}
+		if _, err := os.Stat(dest + ".part"); err == nil {
+			t.Error("expected the part to be moved in place")
+		}
+	})
+
+	t.Run("skips valid archives", func(t *testing.T) {
+		requests = 0
+
+		err := downloadFile(context.Background(), dest, ts.URL+"/archive.zip", nil)
+		if err != nil || requests != 0 {
+			t.Errorf("expected the archive to be skipped, got %v after %v requests", err, requests)
+		}
+	})
+
+	t.Run("replaces broken archives", func(t *testing.T) {
+		ioutil.WriteFile(dest, data[:100], 0644)
+
+		err := downloadFile(context.Background(), dest, ts.URL+"/archive.zip", nil)
+		if err != nil || !valid() {
+			t.Errorf("expected the archive to be downloaded again, got %v", err)
+		}
+	})
+
+	t.Run("retries with backoff", func(t *testing.T) {
+		reset()
+		failures = 2
+
+		err := downloadFile(context.Background(), dest, ts.URL+"/archive.zip", nil)
+		if err != nil || !valid() || requests != 3 {
+			t.Errorf("expected the download to succeed on the third attempt, got %v after %v requests", err, requests)
+		}
+	})
+
+	t.Run("fails on checksum mismatches", func(t *testing.T) {
+		reset()
+		checksum = fmt.Sprintf("%x  archive.zip", sha256.Sum256([]byte("other")))
+
+		err := downloadFile(context.Background(), dest, ts.URL+"/archive.zip", nil)
+		if !errors.Is(err, globals.ErrChecksumMismatch) || requests != attempts {
+			t.Errorf("expected checksum mismatch after %v attempts, got %v after %v", attempts, err, requests)
+		}
+		if _, err := os.Stat(dest); err == nil {
+			t.Error("expected no archive to be left behind")
+		}
+	})
+
+	t.Run("gives up on missing archives", func(t *testing.T) {
+		err := downloadFile(context.Background(), dest, ts.URL+"/missing.zip", nil)
+		if !errors.Is(err, globals.ErrCouldNotDownloadFile) {
+			t.Errorf("expected could not download error, got %v", err)
+		}
+	})
 }
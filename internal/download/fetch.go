@@ -0,0 +1,199 @@
+package download
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+var (
+	// Attempts at every archive, waiting twice as long after every failure
+	attempts = 4
+	backoff  = 500 * time.Millisecond
+	client   = &http.Client{Timeout: 5 * time.Minute}
+)
+
+// downloadFile gets the archive unless there's a valid one already. Archives
+// are checked against the SHA256 checksum published next to them, and only
+// moved in place once they are, so an interrupted download never leaves a
+// broken archive behind. What was already downloaded of one is resumed.
+// Downloaded bytes are added to the counter as they come, if there's one.
+func downloadFile(ctx context.Context, dest string, url string, bytes *int64) error {
+	var err error
+	wait := backoff
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			wait *= 2
+		}
+
+		err = fetchVerified(ctx, dest, url, bytes)
+		if err == nil || errors.Is(err, globals.ErrCouldNotDownloadFile) || ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func fetchVerified(ctx context.Context, dest, url string, bytes *int64) error {
+	checksum, err := fetchChecksum(ctx, url+".CHECKSUM")
+	if err != nil {
+		return err
+	}
+
+	if sum, err := fileChecksum(dest); err == nil && sum == checksum {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	part := dest + ".part"
+	err = fetchPart(ctx, part, url, bytes)
+	if err != nil {
+		return err
+	}
+
+	sum, err := fileChecksum(part)
+	if err != nil {
+		return err
+	}
+	if sum != checksum {
+		os.Remove(part)
+		return fmt.Errorf("%w: %s", globals.ErrChecksumMismatch, filepath.Base(dest))
+	}
+
+	return os.Rename(part, dest)
+}
+
+// fetchPart downloads the rest of the file, asking only for what's missing
+// of it if a part is already there.
+func fetchPart(ctx context.Context, part, url string, bytes *int64) error {
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// The server sent all of it
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing left to get, the checksum tells whether the part is good
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", globals.ErrCouldNotDownloadFile, url)
+	default:
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	out, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var dst io.Writer = out
+	if bytes != nil {
+		dst = &counter{out, bytes}
+	}
+	_, err = io.Copy(dst, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+// Checksum files hold the hex digest followed by the archive name.
+func fetchChecksum(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: %s", globals.ErrCouldNotDownloadFile, url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(resp.Body, 1024)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: %s", globals.ErrChecksumMismatch, url)
+	}
+
+	return strings.ToLower(fields[0]), nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type counter struct {
+	io.Writer
+	bytes *int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	atomic.AddInt64(c.bytes, int64(n))
+	return n, err
+}
@@ -0,0 +1,393 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/clock"
+	"github.com/ws396/autobinance/internal/datasets"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+const day = 24 * time.Hour
+
+type SyncConfig struct {
+	Symbols    []string
+	Timeframes []string
+	// Where the history starts, it's synced up to now
+	Start    time.Time
+	Workers  int
+	Progress chan<- Progress
+	// Serves the candles of the days that aren't published in archives yet
+	Client binancew.ExchangeClient
+	// Real time if not set
+	Clock clock.Clock
+	// Gets the quality report of every file of klines as it's stored, from
+	// the workers
+	Quality func(candles.Quality)
+}
+
+// SyncReport tells what a sync did to a series and what's still missing
+// from it. Gaps are looked for between the first and the last stored
+// candle, so a symbol listed after the start has no gap there.
+type SyncReport struct {
+	Symbol     string
+	Timeframe  string
+	Archives   int
+	Backfilled int
+	First      time.Time
+	Last       time.Time
+	Gaps       []Gap
+}
+
+// Gap of candles from the open time of the first missing one up to the
+// next stored one.
+type Gap struct {
+	From    time.Time
+	To      time.Time
+	Candles int
+}
+
+type period struct {
+	start time.Time
+	end   time.Time
+}
+
+// Sync brings the stored klines of every symbol and timeframe up to date
+// from the start. Only the days that aren't fully stored are downloaded,
+// in monthly archives where a whole month is missing and daily ones
+// otherwise. Days that aren't in archives yet, like today, are backfilled
+// from the exchange. Once a sync has found nothing before the first
+// candle of a symbol listed after the start, the days before aren't asked
+// for again.
+func Sync(ctx context.Context, config SyncConfig) ([]SyncReport, error) {
+	if config.Progress != nil {
+		defer close(config.Progress)
+	}
+
+	c := config.Clock
+	if c == nil {
+		c = clock.Real{}
+	}
+	store := candles.DefaultStore()
+	now := c.Now().UTC()
+	today := now.Truncate(day)
+	start := config.Start.UTC().Truncate(day)
+
+	reports := []*SyncReport{}
+	archives := []archive{}
+	backfill := map[*SyncReport][]period{}
+	listings := map[*SyncReport]int64{}
+	for _, symbol := range config.Symbols {
+		for _, timeframe := range config.Timeframes {
+			step, ok := globals.Durations[timeframe]
+			if !ok {
+				return nil, globals.ErrWrongTimeframe
+			}
+
+			listed, err := store.Listed(symbol, timeframe)
+			if err != nil {
+				return nil, err
+			}
+			from := start
+			if l := time.UnixMilli(listed).UTC().Truncate(day); l.After(from) {
+				from = l
+			}
+
+			days, err := missingDays(store, symbol, timeframe, step, from, today, listed)
+			if err != nil {
+				return nil, err
+			}
+
+			r := &SyncReport{Symbol: symbol, Timeframe: timeframe}
+			reports = append(reports, r)
+			archives = append(archives, planArchives(symbol, timeframe, days, from, today)...)
+			backfill[r] = []period{{today, now}}
+			listings[r] = listed
+		}
+	}
+
+	report := func(symbol, timeframe string) *SyncReport {
+		for _, r := range reports {
+			if r.Symbol == symbol && r.Timeframe == timeframe {
+				return r
+			}
+		}
+		return nil
+	}
+	lock := sync.Mutex{}
+	failed := fetchArchives(ctx, archives, config.Workers, config.Progress, func(a archive, err error) error {
+		r := report(a.symbol, a.timeframe)
+		if errors.Is(err, globals.ErrCouldNotDownloadFile) {
+			// Not published yet
+			lock.Lock()
+			backfill[r] = append(backfill[r], period{a.start, a.end})
+			lock.Unlock()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = newSink(datasets.SpotKlines, a.timeframe, config.Quality).storeZip(a.symbol, a.timeframe, a.path)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		r.Archives++
+		lock.Unlock()
+
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	result := []SyncReport{}
+	for _, r := range reports {
+		step := globals.Durations[r.Timeframe]
+		for _, p := range mergePeriods(backfill[r]) {
+			n, err := backfillPeriod(ctx, config.Client, store, r.Symbol, r.Timeframe, p, now)
+			r.Backfilled += n
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err := inspect(store, r, step, start, now)
+		if err != nil {
+			return nil, err
+		}
+		// Every day before the first candle was asked for and had nothing
+		if first := r.First.UnixMilli(); failed == nil && r.First.After(start) && first != listings[r] {
+			err = store.SetListed(r.Symbol, r.Timeframe, first)
+			if err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, *r)
+	}
+
+	return result, failed
+}
+
+// missingDays lists the days in [start, end) that aren't fully stored. The
+// day of the listing only has to be stored from it. Months the index has as
+// full aren't read.
+func missingDays(store *candles.Store, symbol, timeframe string, step time.Duration, start, end time.Time, listed int64) ([]time.Time, error) {
+	index, err := store.Index(symbol, timeframe)
+	if err != nil {
+		return nil, err
+	}
+	perDay := int(day / step)
+	full := map[string]bool{}
+	for _, m := range index {
+		month, err := time.Parse("2006-01", m.Month)
+		if err != nil {
+			return nil, err
+		}
+		full[m.Month] = m.Count == int(month.AddDate(0, 1, 0).Sub(month)/day)*perDay
+	}
+
+	missing := []time.Time{}
+	month := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for ; month.Before(end); month = month.AddDate(0, 1, 0) {
+		from, to := month, month.AddDate(0, 1, 0)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if full[month.Format("2006-01")] {
+			continue
+		}
+
+		counts, err := countDays(store, symbol, timeframe, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for d := from; d.Before(to); d = d.Add(day) {
+			want := perDay
+			if d.UnixMilli() < listed {
+				want = int((d.Add(day).UnixMilli() - listed) / step.Milliseconds())
+			}
+			if counts[d.UnixMilli()] < want {
+				missing = append(missing, d)
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+func countDays(store *candles.Store, symbol, timeframe string, start, end time.Time) (map[int64]int, error) {
+	counts := map[int64]int{}
+	cursor, err := store.Range(symbol, timeframe, start, end)
+	if errors.Is(err, globals.ErrKlinesNotFound) {
+		return counts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	for {
+		c, err := cursor.Next()
+		if err == io.EOF {
+			return counts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		counts[c.OpenTime-c.OpenTime%day.Milliseconds()]++
+	}
+}
+
+// planArchives picks the archives for the missing days before today. Months
+// missing from the start through their end come in one archive, once over.
+func planArchives(symbol, timeframe string, days []time.Time, start, today time.Time) []archive {
+	byMonth := map[time.Time][]time.Time{}
+	months := []time.Time{}
+	for _, d := range days {
+		month := time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if _, ok := byMonth[month]; !ok {
+			months = append(months, month)
+		}
+		byMonth[month] = append(byMonth[month], d)
+	}
+
+	d := datasets.SpotKlines
+	currentMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	archives := []archive{}
+	for _, month := range months {
+		next := month.AddDate(0, 1, 0)
+		from := month
+		if from.Before(start) {
+			from = start
+		}
+
+		if month.Before(currentMonth) && len(byMonth[month]) == int(next.Sub(from)/day) {
+			filename := d.Filename(symbol, timeframe, month, true)
+			archives = append(archives, archive{
+				symbol:    symbol,
+				timeframe: timeframe,
+				path:      d.Dir(symbol, timeframe) + filename,
+				url:       d.URL(symbol, timeframe, filename, true),
+				start:     month,
+				end:       next,
+			})
+			continue
+		}
+
+		for _, t := range byMonth[month] {
+			if !t.Before(today) {
+				continue
+			}
+			filename := d.Filename(symbol, timeframe, t, false)
+			archives = append(archives, archive{
+				symbol:    symbol,
+				timeframe: timeframe,
+				path:      d.Dir(symbol, timeframe) + filename,
+				url:       d.URL(symbol, timeframe, filename, false),
+				start:     t,
+				end:       t.Add(day),
+			})
+		}
+	}
+
+	return archives
+}
+
+func mergePeriods(periods []period) []period {
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].start.Before(periods[j].start)
+	})
+
+	merged := []period{}
+	for _, p := range periods {
+		if n := len(merged); n > 0 && !p.start.After(merged[n-1].end) {
+			if p.end.After(merged[n-1].end) {
+				merged[n-1].end = p.end
+			}
+			continue
+		}
+		merged = append(merged, p)
+	}
+
+	return merged
+}
+
+// backfillPeriod pages through the klines of the period on the exchange,
+// storing the closed ones.
+func backfillPeriod(ctx context.Context, client binancew.ExchangeClient, store *candles.Store, symbol, timeframe string, p period, now time.Time) (int, error) {
+	if client == nil {
+		return 0, nil
+	}
+
+	count := 0
+	err := binancew.PageKlines(ctx, client, symbol, timeframe, p.start, p.end, func(klines []*binance.Kline) error {
+		closed := []*binance.Kline{}
+		for _, k := range klines {
+			if k.CloseTime < now.UnixMilli() {
+				closed = append(closed, k)
+			}
+		}
+		err := store.Write(symbol, timeframe, candles.FromSlice(closed))
+		if err != nil {
+			return err
+		}
+		count += len(closed)
+
+		return nil
+	})
+
+	return count, err
+}
+
+// inspect fills in what's stored of the series, with its gaps. Stored
+// candles are in time order without duplicates.
+func inspect(store *candles.Store, r *SyncReport, step time.Duration, start, end time.Time) error {
+	cursor, err := store.Range(r.Symbol, r.Timeframe, start, end)
+	if errors.Is(err, globals.ErrKlinesNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	var prev int64 = -1
+	for {
+		c, err := cursor.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if prev < 0 {
+			r.First = time.UnixMilli(c.OpenTime).UTC()
+		} else if missing := int((c.OpenTime-prev)/step.Milliseconds()) - 1; missing > 0 {
+			r.Gaps = append(r.Gaps, Gap{
+				From:    time.UnixMilli(prev).Add(step).UTC(),
+				To:      time.UnixMilli(c.OpenTime).UTC(),
+				Candles: missing,
+			})
+		}
+		prev = c.OpenTime
+	}
+	if prev >= 0 {
+		r.Last = time.UnixMilli(prev).UTC()
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package download
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/clock"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// exchange pages through its klines like the API, 500 at a time.
+type exchange struct {
+	binancew.ExchangeClient
+	klines []*binance.Kline
+	calls  int
+}
+
+func (e *exchange) GetKlinesByPeriod(symbol, timeframe string, start, end time.Time) ([]*binance.Kline, error) {
+	e.calls++
+	result := []*binance.Kline{}
+	for _, k := range e.klines {
+		if k.OpenTime >= start.UnixMilli() && k.OpenTime <= end.UnixMilli() && len(result) < 500 {
+			result = append(result, k)
+		}
+	}
+
+	return result, nil
+}
+
+func TestSync(t *testing.T) {
+	rootpath, _ := os.Getwd()
+	rootpath += "/../../"
+	symbol := "LTCBTC"
+	timeframe := "1m"
+	start := time.Date(2022, 12, 19, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2022, 12, 22, 12, 0, 30, 0, time.UTC)
+
+	globals.BacktestDataDir = ""
+	globals.BacktestDataBaseURL = "/"
+	// The 19th isn't published
+	ts := serveArchives(t, rootpath, []string{symbol}, timeframe, start.Add(day), start.Add(2*day))
+	defer ts.Close()
+	globals.BacktestDataBaseURL = ts.URL + "/"
+	globals.BacktestDataDir = t.TempDir() + "/"
+	backoff = time.Millisecond
+
+	ex := &exchange{}
+	for _, from := range []time.Time{start, start.Add(3 * day)} {
+		for i := 0; i < 1440; i++ {
+			// A hole today, and nothing after the candle that's forming
+			if (from != start && i >= 100 && i < 110) || from.Add(time.Duration(i)*time.Minute).After(now) {
+				continue
+			}
+			openTime := from.Add(time.Duration(i) * time.Minute).UnixMilli()
+			ex.klines = append(ex.klines, &binance.Kline{
+				OpenTime:  openTime,
+				CloseTime: openTime + time.Minute.Milliseconds() - 1,
+				Open:      "1", High: "1", Low: "1", Close: "1", Volume: strconv.Itoa(i),
+			})
+		}
+	}
+
+	// Returns the report and how many archives were asked for
+	sync := func(from time.Time) (SyncReport, int) {
+		progress := make(chan Progress)
+		queued := make(chan int)
+		go func() {
+			n := 0
+			for p := range progress {
+				n = p.Queued
+			}
+			queued <- n
+		}()
+
+		reports, err := Sync(context.Background(), SyncConfig{
+			Symbols:    []string{symbol},
+			Timeframes: []string{timeframe},
+			Start:      from,
+			Progress:   progress,
+			Client:     ex,
+			Clock:      clock.NewSimulated(now),
+		})
+		n := <-queued
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 1 {
+			t.Fatalf("expected a report, got %+v", reports)
+		}
+		return reports[0], n
+	}
+
+	t.Run("downloads archives and backfills the rest", func(t *testing.T) {
+		r, _ := sync(start)
+
+		if r.Archives != 2 || r.Backfilled != 1440+710 || ex.calls < 5 {
+			t.Errorf("expected 2 archives and paged backfills, got %+v after %v calls", r, ex.calls)
+		}
+		if !r.First.Equal(start) || !r.Last.Equal(now.Truncate(time.Minute).Add(-time.Minute)) {
+			t.Errorf("wrong series bounds, got %v %v", r.First, r.Last)
+		}
+		// Test archives only hold the first candles of their day
+		gaps := []Gap{
+			{start.Add(day + 3*time.Minute), start.Add(2 * day), 1437},
+			{start.Add(2*day + 3*time.Minute), start.Add(3 * day), 1437},
+			{start.Add(3*day + 100*time.Minute), start.Add(3*day + 110*time.Minute), 10},
+		}
+		if !reflect.DeepEqual(r.Gaps, gaps) {
+			t.Errorf("expected the holes to be reported, got %+v", r.Gaps)
+		}
+	})
+
+	t.Run("only fetches what's missing", func(t *testing.T) {
+		r, _ := sync(start)
+
+		// The days in the archives aren't full, so they're tried again
+		if r.Archives != 2 || r.Backfilled != 710 || len(r.Gaps) != 3 {
+			t.Errorf("expected only the incomplete days fetched again, got %+v", r)
+		}
+	})
+
+	t.Run("asks for the days before the listing once", func(t *testing.T) {
+		// Nothing on the 17th and 18th
+		r, first := sync(start.Add(-2 * day))
+		_, again := sync(start.Add(-2 * day))
+
+		if !r.First.Equal(start) || first != 4 || again != 2 {
+			t.Errorf("expected days before the listing to be left out once known, got %v then %v archives", first, again)
+		}
+	})
+}
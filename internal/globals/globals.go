@@ -36,17 +36,57 @@ var (
 		"1d":  24 * time.Hour,
 	}
 
-	ErrCouldNotDownloadFile  = errors.New("err: could not download file")
-	ErrEmptyOrderList        = errors.New("err: order list is empty")
-	ErrNotInSimulationMode   = errors.New("err: only available in simulation mode")
-	ErrOrderNotFound         = errors.New("err: order not found")
-	ErrStrategiesNotFound    = errors.New("err: no selected strategies found")
-	ErrSymbolsNotFound       = errors.New("err: no selected symbols found")
-	ErrTradingAlreadyRunning = errors.New("err: trading is already running")
-	ErrTradingNotRunning     = errors.New("err: trading is not running")
-	ErrWriterNotFound        = errors.New("err: writer not found")
-	ErrWrongArgumentAmount   = errors.New("err: wrong amount of arguments")
-	ErrWrongDateOrder        = errors.New("err: expected second date to be later than first")
-	ErrWrongStrategyName     = errors.New("err: entered wrong strategy names")
-	ErrWrongSymbol           = errors.New("err: entered wrong symbols")
+	ErrChecksumMismatch        = errors.New("err: downloaded file does not match its checksum")
+	ErrCouldNotDownloadFile    = errors.New("err: could not download file")
+	ErrDataGap                 = errors.New("err: missing candles in backtest data")
+	ErrDatasetNotFound         = errors.New("err: nothing stored of the dataset for the period")
+	ErrDownloadAlreadyRunning  = errors.New("err: a download is already running")
+	ErrEmptyOrderList          = errors.New("err: order list is empty")
+	ErrKlinesNotFound          = errors.New("err: no downloaded klines for the period, download them first")
+	ErrMalformedCandle         = errors.New("err: malformed candle in backtest data")
+	ErrMalformedRecord         = errors.New("err: malformed dataset record")
+	ErrNoSignalSource          = errors.New("err: strategy needs a signal source, which simulations don't have")
+	ErrNoTrades                = errors.New("err: no closed trades to work with")
+	ErrNotInSimulationMode     = errors.New("err: only available in simulation mode")
+	ErrOrderNotFound           = errors.New("err: order not found")
+	ErrPipeNotSupported        = errors.New("err: named pipes are not supported on this platform")
+	ErrRunNotFound             = errors.New("err: backtest run not found")
+	ErrSignalDuplicate         = errors.New("err: signal has already been received")
+	ErrSignalExpired           = errors.New("err: signal is expired or has no expiry")
+	ErrSignalMissingID         = errors.New("err: signal has no id")
+	ErrSignalTokenMissing      = errors.New("err: signal webhook token is not set")
+	ErrSignalTooLarge          = errors.New("err: signal size is over the max size")
+	ErrSignalWrongDecision     = errors.New("err: signal has wrong decision")
+	ErrSignalWrongSize         = errors.New("err: signal has negative size")
+	ErrSignalWrongSymbol       = errors.New("err: signal has wrong symbol")
+	ErrStrategiesNotFound      = errors.New("err: no selected strategies found")
+	ErrSymbolsNotFound         = errors.New("err: no selected symbols found")
+	ErrTradingAlreadyRunning   = errors.New("err: trading is already running")
+	ErrTradingNotRunning       = errors.New("err: trading is not running")
+	ErrWriterNotFound          = errors.New("err: writer not found")
+	ErrWrongArgumentAmount     = errors.New("err: wrong amount of arguments")
+	ErrWrongBacktestOption     = errors.New("err: expected backtest options like ffill sl:2 tp:4 limit:0.5 path:nearest tf:1h lean")
+	ErrWrongDataset            = errors.New("err: expected a dataset like spot/aggTrades, futures/um/klines or futures/um/fundingRate")
+	ErrWrongDateOrder          = errors.New("err: expected second date to be later than first")
+	ErrWrongDownloadWorkers    = errors.New("err: expected a positive amount of download workers")
+	ErrWrongFeeOverride        = errors.New("err: expected fee overrides like BTCUSDT:0.1:0.1")
+	ErrWrongGapPolicy          = errors.New("err: expected gap policy to be one of skip, ffill, halt")
+	ErrWrongMonteCarloMethod   = errors.New("err: expected monte carlo method to be one of shuffle, bootstrap, skip")
+	ErrWrongMonteCarloRuns     = errors.New("err: expected a positive amount of monte carlo runs")
+	ErrWrongObjective          = errors.New("err: entered unknown optimization objective")
+	ErrWrongOptimizationMethod = errors.New("err: expected optimization method to be grid or random")
+	ErrWrongParamName          = errors.New("err: strategy has no param with that name")
+	ErrWrongParamRange         = errors.New("err: expected param range like window=5:20:5")
+	ErrWrongParamValue         = errors.New("err: param is out of the bounds of the strategy")
+	ErrWrongPathMode           = errors.New("err: expected intrabar path to be one of nearest, ohlc, olhc")
+	ErrWrongReplaySpeed        = errors.New("err: expected replay speed like 1x, 60x or max")
+	ErrWrongRunIDs             = errors.New("err: expected two backtest run ids like 3 5")
+	ErrWrongScenario           = errors.New("err: expected scenario like 01-01-2022 31-03-2022 stress 42, with one of gbm, jumps, regimes, stress")
+	ErrWrongSignalMaxSize      = errors.New("err: expected SIGNALS_MAX_SIZE to be a positive number")
+	ErrWrongSkipChance         = errors.New("err: expected the chance to skip a trade to be between 0 and 1")
+	ErrWrongSlippageModel      = errors.New("err: expected slippage model like bps:5, range:10 or volume:0.1")
+	ErrWrongStrategyName       = errors.New("err: entered wrong strategy names")
+	ErrWrongSymbol             = errors.New("err: entered wrong symbols")
+	ErrWrongTimeframe          = errors.New("err: expected timeframe to be a multiple of the base one, like 5m 1h 4h")
+	ErrWrongWalkForwardWindows = errors.New("err: expected in-sample/out-of-sample windows like 14d/7d that fit in the period")
 )
@@ -0,0 +1,225 @@
+package montecarlo
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+)
+
+const (
+	DefaultRuns       = 1000
+	DefaultSkipChance = 0.1
+	// Percent of the starting capital lost at which an account counts as ruined
+	DefaultRuinLevel = 50
+)
+
+type Method string
+
+const (
+	// Same trades in a different order
+	Shuffle Method = "shuffle"
+	// Trades drawn with replacement, so some repeat and some are left out
+	Bootstrap Method = "bootstrap"
+	// Every trade is missed with a chance, as if orders didn't get filled
+	Skip Method = "skip"
+)
+
+type Config struct {
+	Method  Method
+	Runs    int
+	Seed    int64
+	Capital float64
+	// Chance of missing a trade in skip runs, DefaultSkipChance if not set
+	SkipChance *float64
+	RuinLevel  float64
+}
+
+type Result struct {
+	Method          Method              `json:"method"`
+	Runs            int                 `json:"runs"`
+	Seed            int64               `json:"seed"`
+	Trades          int                 `json:"trades"`
+	FinalEquity     storage.Percentiles `json:"finalEquity"`
+	MaxDrawdown     storage.Percentiles `json:"maxDrawdown"`
+	RuinProbability float64             `json:"ruinProbability"`
+}
+
+func ParseMethod(s string) (Method, error) {
+	switch m := Method(s); m {
+	case Shuffle, Bootstrap, Skip:
+		return m, nil
+	default:
+		return "", globals.ErrWrongMonteCarloMethod
+	}
+}
+
+// Simulate resamples the profits of the trades many times and measures
+// every resulting equity path.
+func Simulate(trips []analysis.RoundTrip, config Config) (Result, error) {
+	if len(trips) == 0 {
+		return Result{}, globals.ErrNoTrades
+	}
+	if config.Runs < 0 {
+		return Result{}, globals.ErrWrongMonteCarloRuns
+	}
+	if config.Runs == 0 {
+		config.Runs = DefaultRuns
+	}
+	if config.Capital <= 0 {
+		config.Capital = globals.BuyAmount
+	}
+	skipChance := DefaultSkipChance
+	if config.SkipChance != nil {
+		skipChance = *config.SkipChance
+	}
+	// Written so that NaN is rejected too
+	if !(skipChance >= 0 && skipChance <= 1) {
+		return Result{}, globals.ErrWrongSkipChance
+	}
+	if config.RuinLevel <= 0 {
+		config.RuinLevel = DefaultRuinLevel
+	}
+
+	var resample func(rnd *rand.Rand, profits []float64) []float64
+	switch config.Method {
+	case Shuffle, "":
+		config.Method = Shuffle
+		resample = shuffle
+	case Bootstrap:
+		resample = bootstrap
+	case Skip:
+		resample = func(rnd *rand.Rand, profits []float64) []float64 {
+			return skip(rnd, profits, skipChance)
+		}
+	default:
+		return Result{}, globals.ErrWrongMonteCarloMethod
+	}
+
+	profits := make([]float64, len(trips))
+	for i, rt := range trips {
+		profits[i] = rt.Profit
+	}
+
+	rnd := rand.New(rand.NewSource(config.Seed))
+	ruinEquity := config.Capital * (1 - config.RuinLevel/100)
+	finals := make([]float64, config.Runs)
+	drawdowns := make([]float64, config.Runs)
+	var ruined int
+
+	for i := 0; i < config.Runs; i++ {
+		final, drawdown, lowest := walk(resample(rnd, profits), config.Capital)
+		finals[i] = final
+		drawdowns[i] = drawdown
+		if lowest <= ruinEquity {
+			ruined++
+		}
+	}
+
+	return Result{
+		Method:          config.Method,
+		Runs:            config.Runs,
+		Seed:            config.Seed,
+		Trades:          len(trips),
+		FinalEquity:     percentiles(finals),
+		MaxDrawdown:     percentiles(drawdowns),
+		RuinProbability: float64(ruined) / float64(config.Runs),
+	}, nil
+}
+
+// Record prepares the result to be stored along with the backtest it came
+// from, runID being the stored run of the backtest.
+func Record(result Result, config backtest.Config, runID uint) *storage.MonteCarloResult {
+	return &storage.MonteCarloResult{
+		RunID:           runID,
+		Method:          string(result.Method),
+		Runs:            result.Runs,
+		Seed:            result.Seed,
+		Trades:          result.Trades,
+		Strategies:      strings.Join(config.Strategies, " "),
+		Symbols:         strings.Join(config.Symbols, " "),
+		FinalEquity:     result.FinalEquity,
+		MaxDrawdown:     result.MaxDrawdown,
+		RuinProbability: result.RuinProbability,
+		Timeframe:       config.Timeframe,
+		Start:           config.Start,
+		End:             config.End,
+		CreatedAt:       time.Now(),
+	}
+}
+
+// walk returns the final equity, the max drawdown in percent and the lowest equity of the path.
+func walk(profits []float64, capital float64) (float64, float64, float64) {
+	equity, peak, lowest := capital, capital, capital
+	var drawdown float64
+
+	for _, p := range profits {
+		equity += p
+		peak = math.Max(peak, equity)
+		lowest = math.Min(lowest, equity)
+		if peak > 0 {
+			drawdown = math.Max(drawdown, (peak-equity)/peak*100)
+		}
+	}
+
+	return equity, drawdown, lowest
+}
+
+func shuffle(rnd *rand.Rand, profits []float64) []float64 {
+	result := make([]float64, len(profits))
+	copy(result, profits)
+	rnd.Shuffle(len(result), func(i, j int) {
+		result[i], result[j] = result[j], result[i]
+	})
+
+	return result
+}
+
+func bootstrap(rnd *rand.Rand, profits []float64) []float64 {
+	result := make([]float64, len(profits))
+	for i := range result {
+		result[i] = profits[rnd.Intn(len(profits))]
+	}
+
+	return result
+}
+
+func skip(rnd *rand.Rand, profits []float64, chance float64) []float64 {
+	result := []float64{}
+	for _, p := range profits {
+		if rnd.Float64() >= chance {
+			result = append(result, p)
+		}
+	}
+
+	return result
+}
+
+// percentiles uses the nearest rank on a sorted copy of the values.
+func percentiles(values []float64) storage.Percentiles {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	at := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+
+	return storage.Percentiles{
+		P5:  at(5),
+		P25: at(25),
+		P50: at(50),
+		P75: at(75),
+		P95: at(95),
+	}
+}
@@ -0,0 +1,121 @@
+package montecarlo_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/montecarlo"
+)
+
+func trips(profits ...float64) []analysis.RoundTrip {
+	result := make([]analysis.RoundTrip, len(profits))
+	for i, p := range profits {
+		result[i] = analysis.RoundTrip{Profit: p}
+	}
+
+	return result
+}
+
+func TestSimulate(t *testing.T) {
+	ts := trips(10, -20, 5, -5, 15, -30, 25)
+
+	t.Run("shuffling keeps the final equity", func(t *testing.T) {
+		got, err := montecarlo.Simulate(ts, montecarlo.Config{Method: montecarlo.Shuffle, Runs: 200, Capital: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.FinalEquity.P5 != 100 || got.FinalEquity.P95 != 100 {
+			t.Errorf("expected final equity of 100 on every run, got %+v", got.FinalEquity)
+		}
+		if got.MaxDrawdown.P5 > got.MaxDrawdown.P50 || got.MaxDrawdown.P50 > got.MaxDrawdown.P95 {
+			t.Errorf("expected ordered percentiles, got %+v", got.MaxDrawdown)
+		}
+	})
+
+	t.Run("same seed gives the same result", func(t *testing.T) {
+		config := montecarlo.Config{Method: montecarlo.Bootstrap, Runs: 200, Capital: 100, Seed: 7}
+		a, _ := montecarlo.Simulate(ts, config)
+		b, _ := montecarlo.Simulate(ts, config)
+
+		if a != b {
+			t.Errorf("expected equal results, got %+v and %+v", a, b)
+		}
+	})
+
+	t.Run("counts ruined runs", func(t *testing.T) {
+		got, err := montecarlo.Simulate(trips(-60, 10), montecarlo.Config{Method: montecarlo.Shuffle, Runs: 100, Capital: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if math.Abs(got.RuinProbability-1) > 1e-9 {
+			t.Errorf("expected every run to be ruined, got %v", got.RuinProbability)
+		}
+	})
+
+	t.Run("skipping every trade keeps the capital", func(t *testing.T) {
+		all := 1.0
+		got, err := montecarlo.Simulate(ts, montecarlo.Config{Method: montecarlo.Skip, Runs: 50, Capital: 100, SkipChance: &all})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.FinalEquity.P50 != 100 || got.MaxDrawdown.P95 != 0 {
+			t.Errorf("expected untouched capital, got %+v", got)
+		}
+	})
+
+	t.Run("skips no trade at a zero chance", func(t *testing.T) {
+		none := 0.0
+		got, err := montecarlo.Simulate(ts, montecarlo.Config{Method: montecarlo.Skip, Runs: 50, Capital: 100, SkipChance: &none})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var total float64
+		for _, rt := range ts {
+			total += rt.Profit
+		}
+		if got.FinalEquity.P5 != 100+total || got.FinalEquity.P95 != 100+total {
+			t.Errorf("expected every run to take all trades, got %+v", got.FinalEquity)
+		}
+	})
+
+	t.Run("records the run the trades came from", func(t *testing.T) {
+		got, err := montecarlo.Simulate(ts, montecarlo.Config{Runs: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r := montecarlo.Record(got, backtest.Config{}, 7); r.RunID != 7 {
+			t.Errorf("expected run id to be recorded, got %v", r.RunID)
+		}
+	})
+
+	t.Run("rejects empty trades and unknown methods", func(t *testing.T) {
+		if _, err := montecarlo.Simulate(nil, montecarlo.Config{}); err == nil {
+			t.Error("expected error for no trades")
+		}
+		if _, err := montecarlo.Simulate(ts, montecarlo.Config{Method: "random"}); err == nil {
+			t.Error("expected error for unknown method")
+		}
+	})
+
+	t.Run("rejects skip chances out of range and negative runs", func(t *testing.T) {
+		for _, chance := range []float64{-0.1, 1.5, math.NaN()} {
+			chance := chance
+			_, err := montecarlo.Simulate(ts, montecarlo.Config{Method: montecarlo.Skip, SkipChance: &chance})
+			if err != globals.ErrWrongSkipChance {
+				t.Errorf("expected wrong skip chance error for %v, got %v", chance, err)
+			}
+		}
+		_, err := montecarlo.Simulate(ts, montecarlo.Config{Runs: -5})
+		if err != globals.ErrWrongMonteCarloRuns {
+			t.Errorf("expected wrong runs error, got %v", err)
+		}
+	})
+}
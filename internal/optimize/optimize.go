@@ -0,0 +1,286 @@
+package optimize
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/strategies"
+)
+
+type Method string
+
+const (
+	Grid   Method = "grid"
+	Random Method = "random"
+)
+
+// DefaultSamples random search takes when no amount is given.
+const DefaultSamples = 50
+
+// Range of a single param, ex. "window=5:20:5". Random search snaps samples
+// to the step if one is set.
+type Range struct {
+	Name string  `json:"name"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Step float64 `json:"step"`
+}
+
+type Metrics struct {
+	ProfitUSD   float64 `json:"profitUSD"`
+	Sharpe      float64 `json:"sharpe"`
+	MaxDrawdown float64 `json:"maxDrawdown"`
+	Trades      int     `json:"trades"`
+}
+
+// Trial is a single backtest of the strategy with a set of params.
+type Trial struct {
+	Params      strategies.Params `json:"params"`
+	Fingerprint string            `json:"fingerprint"`
+	Metrics     Metrics           `json:"metrics"`
+	Score       float64           `json:"score"`
+}
+
+type Config struct {
+	Backtest backtest.Config
+	Strategy string
+	Ranges   []Range
+	Method   Method
+	// Defaults to DefaultSamples
+	Samples   int
+	Seed      int64
+	Objective string
+	// Defaults to the amount of CPU cores
+	Workers int
+}
+
+// Score ranks trials, higher is better.
+type Score func(Metrics) float64
+
+var Scores = map[string]Score{
+	"profit": func(m Metrics) float64 {
+		return m.ProfitUSD
+	},
+	"sharpe": func(m Metrics) float64 {
+		return m.Sharpe
+	},
+	"drawdown": func(m Metrics) float64 {
+		return -m.MaxDrawdown
+	},
+}
+
+// AddScore registers a custom objective, which can then be picked by name.
+func AddScore(name string, score Score) {
+	Scores[name] = score
+}
+
+func ParseRange(s string) (Range, error) {
+	nameValues := strings.Split(s, "=")
+	if len(nameValues) != 2 {
+		return Range{}, globals.ErrWrongParamRange
+	}
+
+	values := strings.Split(nameValues[1], ":")
+	if len(values) != 3 {
+		return Range{}, globals.ErrWrongParamRange
+	}
+
+	nums := [3]float64{}
+	for i, v := range values {
+		var err error
+		nums[i], err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return Range{}, err
+		}
+	}
+
+	r := Range{nameValues[0], nums[0], nums[1], nums[2]}
+	if r.Max < r.Min || r.Step < 0 {
+		return Range{}, globals.ErrWrongParamRange
+	}
+
+	return r, nil
+}
+
+// Optimize backtests every candidate in parallel and returns the trials
+// sorted from the best score to the worst.
+func Optimize(config Config, feed map[string][]*binance.Kline) ([]Trial, error) {
+	score, ok := Scores[config.Objective]
+	if !ok {
+		return nil, globals.ErrWrongObjective
+	}
+	info, ok := strategies.StrategiesInfo[config.Strategy]
+	if !ok {
+		return nil, globals.ErrWrongStrategyName
+	}
+	for _, r := range config.Ranges {
+		if _, ok := info.Params[r.Name]; !ok {
+			return nil, fmt.Errorf("%w: %s", globals.ErrWrongParamName, r.Name)
+		}
+	}
+
+	var candidates []strategies.Params
+	switch config.Method {
+	case Grid, "":
+		candidates = grid(config.Ranges)
+	case Random:
+		samples := config.Samples
+		if samples <= 0 {
+			samples = DefaultSamples
+		}
+		candidates = random(config.Ranges, samples, config.Seed)
+	default:
+		return nil, globals.ErrWrongOptimizationMethod
+	}
+
+	// Out of bounds params could take down the workers
+	windowSize := config.Backtest.WindowSize
+	if windowSize == 0 {
+		windowSize = backtest.DefaultWindowSize
+	}
+	for _, c := range candidates {
+		err := info.WithParams(c).CheckParams(windowSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	trials := make([]Trial, len(candidates))
+	errs := make([]error, len(candidates))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				trials[i], errs[i] = runTrial(config, feed, candidates[i], score)
+			}
+		}()
+	}
+
+	for i := range candidates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Ties keep the candidate order, so results are reproducible
+	sort.SliceStable(trials, func(i, j int) bool {
+		return trials[i].Score > trials[j].Score
+	})
+
+	return trials, nil
+}
+
+func runTrial(config Config, feed map[string][]*binance.Kline, params strategies.Params, score Score) (Trial, error) {
+	btConfig := config.Backtest
+	btConfig.Strategies = []string{config.Strategy}
+	btConfig.Params = map[string]strategies.Params{config.Strategy: params}
+
+	result, err := backtest.NewEngine(btConfig, feed).Run()
+	if err != nil {
+		return Trial{}, err
+	}
+
+	metrics := Evaluate(result, config.Backtest.Start)
+
+	return Trial{
+		Params:      params,
+		Fingerprint: strategies.StrategiesInfo[config.Strategy].WithParams(params).Fingerprint(),
+		Metrics:     metrics,
+		Score:       score(metrics),
+	}, nil
+}
+
+// Evaluate measures the whole backtest, across all of its symbols.
+func Evaluate(result *backtest.Result, start time.Time) Metrics {
+	trips := analysis.RoundTrips(result.Orders)
+	curve := analysis.EquityCurve(trips, globals.BuyAmount, start)
+	drawdown, _ := analysis.MaxDrawdown(curve)
+
+	var profit float64
+	for _, rt := range trips {
+		profit += rt.Profit
+	}
+
+	return Metrics{
+		ProfitUSD:   profit,
+		Sharpe:      analysis.Sharpe(analysis.Returns(trips)),
+		MaxDrawdown: drawdown,
+		Trades:      len(trips),
+	}
+}
+
+func grid(ranges []Range) []strategies.Params {
+	candidates := []strategies.Params{{}}
+
+	for _, r := range ranges {
+		values := []float64{r.Min}
+		if r.Step > 0 {
+			values = []float64{}
+			// Rounding avoids losing the last value to float error
+			n := int(math.Floor((r.Max-r.Min)/r.Step + 1e-9))
+			for i := 0; i <= n; i++ {
+				values = append(values, r.Min+float64(i)*r.Step)
+			}
+		}
+
+		next := []strategies.Params{}
+		for _, c := range candidates {
+			for _, v := range values {
+				p := strategies.Params{}
+				for k, cv := range c {
+					p[k] = cv
+				}
+				p[r.Name] = v
+				next = append(next, p)
+			}
+		}
+		candidates = next
+	}
+
+	return candidates
+}
+
+func random(ranges []Range, samples int, seed int64) []strategies.Params {
+	rnd := rand.New(rand.NewSource(seed))
+	candidates := make([]strategies.Params, samples)
+
+	for i := range candidates {
+		p := strategies.Params{}
+		for _, r := range ranges {
+			v := r.Min + rnd.Float64()*(r.Max-r.Min)
+			if r.Step > 0 {
+				v = r.Min + math.Floor((v-r.Min)/r.Step)*r.Step
+			}
+			p[r.Name] = v
+		}
+		candidates[i] = p
+	}
+
+	return candidates
+}
@@ -0,0 +1,214 @@
+package optimize
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestParseRange(t *testing.T) {
+	got, err := ParseRange("window=5:20:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Range{"window", 5, 20, 5}
+	if got != want {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	for _, s := range []string{"window", "window=5:20", "window=20:5:1", "window=a:b:c"} {
+		if _, err := ParseRange(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestGrid(t *testing.T) {
+	got := grid([]Range{{"window", 5, 20, 5}, {"lookback", 1, 3, 1}})
+
+	if len(got) != 12 {
+		t.Fatalf("expected 12 candidates, got %v", len(got))
+	}
+	if got[11]["window"] != 20 || got[11]["lookback"] != 3 {
+		t.Errorf("expected last candidate to hit both maximums, got %v", got[11])
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	feed := map[string][]*binance.Kline{
+		"LTCBTC": testutil.MockKlines(start, testutil.WaveCloses(400, 50)...),
+	}
+	config := Config{
+		Backtest: backtest.Config{
+			Start:      start,
+			End:        start.Add(400 * time.Minute),
+			Symbols:    []string{"LTCBTC"},
+			Timeframe:  "1m",
+			WindowSize: 30,
+		},
+		Strategy:  "example",
+		Ranges:    []Range{{"window", 5, 20, 5}, {"lookback", 1, 3, 1}},
+		Objective: "profit",
+	}
+
+	t.Run("ranks trials by objective", func(t *testing.T) {
+		trials, err := Optimize(config, feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(trials) != 12 {
+			t.Fatalf("expected 12 trials, got %v", len(trials))
+		}
+		for i := 1; i < len(trials); i++ {
+			if trials[i].Score > trials[i-1].Score {
+				t.Fatalf("trials are not sorted by score, got %v", trials)
+			}
+		}
+	})
+
+	t.Run("gives the same results on any amount of workers", func(t *testing.T) {
+		config.Workers = 1
+		want, err := Optimize(config, feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config.Workers = 8
+		got, err := Optimize(config, feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("rejects params the strategy doesn't have", func(t *testing.T) {
+		config := config
+		config.Ranges = []Range{{"widnow", 5, 20, 5}}
+
+		_, err := Optimize(config, feed)
+		if !errors.Is(err, globals.ErrWrongParamName) {
+			t.Errorf("expected wrong param name error, got %v", err)
+		}
+	})
+
+	t.Run("rejects params out of the bounds of the strategy", func(t *testing.T) {
+		for _, r := range []Range{{"lookback", -3, 0, 1}, {"lookback", 1, 30, 1}, {"window", 0, 5, 5}} {
+			config := config
+			config.Ranges = []Range{r}
+
+			_, err := Optimize(config, feed)
+			if !errors.Is(err, globals.ErrWrongParamValue) {
+				t.Errorf("expected wrong param value error on %v, got %v", r, err)
+			}
+		}
+	})
+
+	t.Run("takes default samples when random search has no amount", func(t *testing.T) {
+		config := config
+		config.Ranges = []Range{{"window", 5, 20, 0}}
+		config.Method = Random
+		config.Seed = 42
+
+		trials, err := Optimize(config, feed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(trials) != DefaultSamples {
+			t.Errorf("expected %v trials, got %v", DefaultSamples, len(trials))
+		}
+		if results := Results(config, trials); results[0].Seed != 42 {
+			t.Errorf("expected seed to be stored, got %v", results[0].Seed)
+		}
+	})
+
+	t.Run("samples reproducible random candidates", func(t *testing.T) {
+		config.Method = Random
+		config.Samples = 5
+		config.Seed = 42
+
+		got := random(config.Ranges, config.Samples, config.Seed)
+		want := random(config.Ranges, config.Samples, config.Seed)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+
+		for _, p := range got {
+			if p["window"] < 5 || p["window"] > 20 || int(p["window"])%5 != 0 {
+				t.Errorf("sample out of range or off step, got %v", p)
+			}
+		}
+	})
+}
+
+func TestWalkForward(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	feed := map[string][]*binance.Kline{
+		"LTCBTC": testutil.MockKlines(start, testutil.WaveCloses(600, 50)...),
+	}
+	config := WalkForwardConfig{
+		Optimize: Config{
+			Backtest: backtest.Config{
+				Start:      start,
+				End:        start.Add(600 * time.Minute),
+				Symbols:    []string{"LTCBTC"},
+				Timeframe:  "1m",
+				WindowSize: 30,
+			},
+			Strategy:  "example",
+			Ranges:    []Range{{"window", 5, 20, 5}},
+			Objective: "profit",
+		},
+		InSample:    200 * time.Minute,
+		OutOfSample: 100 * time.Minute,
+	}
+
+	result, err := WalkForward(config, feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Windows) != 4 {
+		t.Fatalf("expected 4 windows, got %v", len(result.Windows))
+	}
+
+	var trades int
+	for i, w := range result.Windows {
+		if i > 0 && !w.OutOfSampleStart.Equal(result.Windows[i-1].OutOfSampleEnd) {
+			t.Errorf("expected out-of-sample windows to follow each other, got %v", result.Windows)
+		}
+		for _, rt := range w.trips {
+			if rt.Entry.Before(w.OutOfSampleStart) || rt.Exit.After(w.OutOfSampleEnd) {
+				t.Errorf("trade %v is outside of its out-of-sample window", rt)
+			}
+		}
+		trades += w.OutOfSample.Trades
+	}
+
+	if result.OutOfSample.Trades != trades {
+		t.Errorf("expected %v stitched trades, got %v", trades, result.OutOfSample.Trades)
+	}
+	if len(result.Equity) != trades+1 {
+		t.Errorf("expected an equity point per trade, got %v", len(result.Equity))
+	}
+	if len(result.Stability) != 1 || result.Stability[0].Name != "window" {
+		t.Errorf("expected stability of the window param, got %v", result.Stability)
+	}
+
+	config.InSample = 600 * time.Minute
+	if _, err := WalkForward(config, feed); err == nil {
+		t.Error("expected error for windows longer than the period")
+	}
+}
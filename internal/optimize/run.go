@@ -0,0 +1,141 @@
+package optimize
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/util"
+)
+
+var datePattern = regexp.MustCompile(`^\d{2}-\d{2}-\d{4}$`)
+
+// Run optimizes a strategy over the downloaded data of the selected symbols.
+// Input looks like "example window=5:20:5 lookback=1:3:1 01-02-2021 30-03-2021 sharpe random:50",
+// where the method is optional and defaults to grid. Random search takes
+// DefaultSamples if no amount is given.
+func Run(input string, settings map[string]storage.Setting) ([]storage.OptimizationResult, error) {
+	if !globals.SimulationMode {
+		return nil, globals.ErrNotInSimulationMode
+	}
+	if len(settings["selected_symbols"].ValueArr) == 0 {
+		return nil, globals.ErrSymbolsNotFound
+	}
+
+	config, err := parseInput(input)
+	if err != nil {
+		return nil, err
+	}
+	config.Backtest.Symbols = settings["selected_symbols"].ValueArr
+	config.Backtest.Fees, config.Backtest.Slippage, err = backtest.CostsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := backtest.LoadFeed(config.Backtest.Symbols, config.Backtest.Start, config.Backtest.End)
+	if err != nil {
+		return nil, err
+	}
+
+	trials, err := Optimize(config, feed)
+	if err != nil {
+		return nil, err
+	}
+
+	return Results(config, trials), nil
+}
+
+func parseInput(input string) (Config, error) {
+	args := strings.Split(input, " ")
+	if len(args) < 4 {
+		return Config{}, globals.ErrWrongArgumentAmount
+	}
+
+	config := Config{
+		Strategy: args[0],
+		Method:   Grid,
+	}
+	dates := []string{}
+	rest := []string{}
+	for _, arg := range args[1:] {
+		switch {
+		case strings.Contains(arg, "="):
+			r, err := ParseRange(arg)
+			if err != nil {
+				return Config{}, err
+			}
+			config.Ranges = append(config.Ranges, r)
+		case datePattern.MatchString(arg):
+			dates = append(dates, arg)
+		default:
+			rest = append(rest, arg)
+		}
+	}
+
+	start, end, err := util.ExtractTimepoints(strings.Join(dates, " "))
+	if err != nil {
+		return Config{}, err
+	}
+	config.Backtest = backtest.Config{
+		Start:     start,
+		End:       end,
+		Timeframe: globals.Timeframe,
+	}
+
+	if len(rest) == 0 || len(rest) > 2 {
+		return Config{}, globals.ErrWrongArgumentAmount
+	}
+	config.Objective = rest[0]
+
+	if len(rest) == 2 {
+		method := strings.Split(rest[1], ":")
+		config.Method = Method(method[0])
+		if len(method) == 2 {
+			config.Samples, err = strconv.Atoi(method[1])
+			if err != nil {
+				return Config{}, err
+			}
+		}
+	}
+	if config.Method == Random {
+		config.Seed = time.Now().UnixNano()
+	}
+
+	return config, nil
+}
+
+// Results turns ranked trials into rows of a single batch, ready to be stored.
+func Results(config Config, trials []Trial) []storage.OptimizationResult {
+	createdAt := time.Now()
+	batch := fmt.Sprintf("%s-%d", config.Strategy, createdAt.UnixNano())
+	results := make([]storage.OptimizationResult, len(trials))
+
+	for i, t := range trials {
+		results[i] = storage.OptimizationResult{
+			Batch:       batch,
+			Rank:        i + 1,
+			Strategy:    config.Strategy,
+			Fingerprint: t.Fingerprint,
+			Params:      t.Params,
+			Objective:   config.Objective,
+			Seed:        config.Seed,
+			Score:       t.Score,
+			ProfitUSD:   t.Metrics.ProfitUSD,
+			Sharpe:      t.Metrics.Sharpe,
+			MaxDrawdown: t.Metrics.MaxDrawdown,
+			Trades:      t.Metrics.Trades,
+			Symbols:     strings.Join(config.Backtest.Symbols, " "),
+			Timeframe:   config.Backtest.Timeframe,
+			Start:       config.Backtest.Start,
+			End:         config.Backtest.End,
+			CreatedAt:   createdAt,
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,251 @@
+package optimize
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/strategies"
+)
+
+var windowsPattern = regexp.MustCompile(`^(\d+)d/(\d+)d$`)
+
+type WalkForwardConfig struct {
+	// Backtest.Start and Backtest.End span the whole period
+	Optimize    Config
+	InSample    time.Duration
+	OutOfSample time.Duration
+	// Defaults to OutOfSample, so out-of-sample windows follow each other
+	Step time.Duration
+}
+
+// Window is optimized on its in-sample part, and the best params are then
+// evaluated on the out-of-sample part that follows.
+type Window struct {
+	InSampleStart    time.Time                   `json:"inSampleStart"`
+	OutOfSampleStart time.Time                   `json:"outOfSampleStart"`
+	OutOfSampleEnd   time.Time                   `json:"outOfSampleEnd"`
+	Params           strategies.Params           `json:"params"`
+	Fingerprint      string                      `json:"fingerprint"`
+	InSample         Metrics                     `json:"inSample"`
+	OutOfSample      Metrics                     `json:"outOfSample"`
+	Analyses         map[string]storage.Analysis `json:"-"`
+	trips            []analysis.RoundTrip
+}
+
+// ParamStability shows how much the best value of a param moved between windows.
+type ParamStability struct {
+	Name   string  `json:"name"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+}
+
+type WalkForwardResult struct {
+	Windows []Window `json:"windows"`
+	// Out-of-sample trades of every window stitched together
+	Equity      []analysis.EquityPoint `json:"equity"`
+	OutOfSample Metrics                `json:"outOfSample"`
+	Stability   []ParamStability       `json:"stability"`
+	// Out-of-sample profit relative to in-sample profit
+	Efficiency float64 `json:"efficiency"`
+}
+
+func WalkForward(config WalkForwardConfig, feed map[string][]*binance.Kline) (*WalkForwardResult, error) {
+	if config.InSample <= 0 || config.OutOfSample <= 0 {
+		return nil, globals.ErrWrongWalkForwardWindows
+	}
+	if config.Step <= 0 {
+		config.Step = config.OutOfSample
+	}
+
+	period := config.Optimize.Backtest
+	result := &WalkForwardResult{}
+	var isProfit, oosProfit float64
+
+	for isStart := period.Start; !isStart.Add(config.InSample + config.OutOfSample).After(period.End); isStart = isStart.Add(config.Step) {
+		w, err := runWindow(config, feed, isStart)
+		if err != nil {
+			return nil, err
+		}
+
+		isProfit += w.InSample.ProfitUSD
+		oosProfit += w.OutOfSample.ProfitUSD
+		result.Windows = append(result.Windows, w)
+	}
+
+	if len(result.Windows) == 0 {
+		return nil, globals.ErrWrongWalkForwardWindows
+	}
+
+	trips := []analysis.RoundTrip{}
+	for _, w := range result.Windows {
+		trips = append(trips, w.trips...)
+	}
+
+	result.Equity = analysis.EquityCurve(trips, globals.BuyAmount, result.Windows[0].OutOfSampleStart)
+	drawdown, _ := analysis.MaxDrawdown(result.Equity)
+	result.OutOfSample = Metrics{
+		ProfitUSD:   oosProfit,
+		Sharpe:      analysis.Sharpe(analysis.Returns(trips)),
+		MaxDrawdown: drawdown,
+		Trades:      len(trips),
+	}
+	result.Stability = stability(result.Windows)
+	if isProfit != 0 {
+		result.Efficiency = oosProfit / isProfit
+	}
+
+	return result, nil
+}
+
+func runWindow(config WalkForwardConfig, feed map[string][]*binance.Kline, isStart time.Time) (Window, error) {
+	oosStart := isStart.Add(config.InSample)
+	oosEnd := oosStart.Add(config.OutOfSample)
+
+	isConfig := config.Optimize
+	isConfig.Backtest.Start = isStart
+	isConfig.Backtest.End = oosStart
+	trials, err := Optimize(isConfig, sliceFeed(feed, isStart, oosStart, 0))
+	if err != nil {
+		return Window{}, err
+	}
+	if len(trials) == 0 {
+		return Window{}, globals.ErrWrongParamRange
+	}
+	best := trials[0]
+
+	// Candles before the out-of-sample start only warm the strategy up
+	oosConfig := config.Optimize.Backtest
+	oosConfig.Start = oosStart
+	oosConfig.End = oosEnd
+	oosConfig.Strategies = []string{config.Optimize.Strategy}
+	oosConfig.Params = map[string]strategies.Params{config.Optimize.Strategy: best.Params}
+	warmup := time.Duration(oosConfig.WindowSize) * globals.Durations[oosConfig.Timeframe]
+	if oosConfig.WindowSize == 0 {
+		warmup = time.Duration(backtest.DefaultWindowSize) * globals.Durations[oosConfig.Timeframe]
+	}
+
+	oos, err := backtest.NewEngine(oosConfig, sliceFeed(feed, oosStart, oosEnd, warmup)).Run()
+	if err != nil {
+		return Window{}, err
+	}
+
+	return Window{
+		InSampleStart:    isStart,
+		OutOfSampleStart: oosStart,
+		OutOfSampleEnd:   oosEnd,
+		Params:           best.Params,
+		Fingerprint:      best.Fingerprint,
+		InSample:         best.Metrics,
+		OutOfSample:      Evaluate(oos, oosStart),
+		Analyses:         oos.Analyses,
+		trips:            analysis.RoundTrips(oos.Orders),
+	}, nil
+}
+
+// sliceFeed keeps candles that open within [start-warmup, end).
+func sliceFeed(feed map[string][]*binance.Kline, start, end time.Time, warmup time.Duration) map[string][]*binance.Kline {
+	from := start.Add(-warmup).UnixMilli()
+	to := end.UnixMilli()
+	sliced := map[string][]*binance.Kline{}
+
+	for symbol, klines := range feed {
+		i := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= from })
+		j := sort.Search(len(klines), func(i int) bool { return klines[i].OpenTime >= to })
+		sliced[symbol] = klines[i:j]
+	}
+
+	return sliced
+}
+
+func stability(windows []Window) []ParamStability {
+	values := map[string][]float64{}
+	for _, w := range windows {
+		for k, v := range w.Params {
+			values[k] = append(values[k], v)
+		}
+	}
+
+	names := []string{}
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	result := []ParamStability{}
+	for _, k := range names {
+		vs := values[k]
+		ps := ParamStability{Name: k, Mean: analysis.Mean(vs), Min: vs[0], Max: vs[0]}
+
+		var sum float64
+		for _, v := range vs {
+			sum += (v - ps.Mean) * (v - ps.Mean)
+			ps.Min = math.Min(ps.Min, v)
+			ps.Max = math.Max(ps.Max, v)
+		}
+		ps.StdDev = math.Sqrt(sum / float64(len(vs)))
+
+		result = append(result, ps)
+	}
+
+	return result
+}
+
+// RunWalkForward takes the same input as Run, plus the in-sample and
+// out-of-sample window lengths in days, ex. "... sharpe 14d/7d".
+func RunWalkForward(input string, settings map[string]storage.Setting) (*WalkForwardResult, error) {
+	if !globals.SimulationMode {
+		return nil, globals.ErrNotInSimulationMode
+	}
+	if len(settings["selected_symbols"].ValueArr) == 0 {
+		return nil, globals.ErrSymbolsNotFound
+	}
+
+	args := []string{}
+	var inSample, outOfSample time.Duration
+	for _, arg := range strings.Split(input, " ") {
+		m := windowsPattern.FindStringSubmatch(arg)
+		if m == nil {
+			args = append(args, arg)
+			continue
+		}
+
+		isDays, _ := strconv.Atoi(m[1])
+		oosDays, _ := strconv.Atoi(m[2])
+		inSample = time.Duration(isDays) * 24 * time.Hour
+		outOfSample = time.Duration(oosDays) * 24 * time.Hour
+	}
+
+	config, err := parseInput(strings.Join(args, " "))
+	if err != nil {
+		return nil, err
+	}
+	config.Backtest.Symbols = settings["selected_symbols"].ValueArr
+	config.Backtest.Fees, config.Backtest.Slippage, err = backtest.CostsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	// The end date is inclusive in downloaded data
+	config.Backtest.End = config.Backtest.End.Add(24 * time.Hour)
+
+	feed, err := backtest.LoadFeed(config.Backtest.Symbols, config.Backtest.Start, config.Backtest.End.Add(-24*time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	return WalkForward(WalkForwardConfig{
+		Optimize:    config,
+		InSample:    inSample,
+		OutOfSample: outOfSample,
+	}, feed)
+}
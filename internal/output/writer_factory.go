@@ -4,9 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"sync/atomic"
 
-	"github.com/ws396/autobinance/internal/binancew"
 	"github.com/ws396/autobinance/internal/globals"
 	"github.com/ws396/autobinance/internal/storage"
 	"github.com/ws396/autobinance/internal/strategies"
@@ -145,9 +143,6 @@ type StubWriter struct {
 }
 
 func (p *StubWriter) WriteToLog(orders []*storage.Order) error {
-	// Should probably be somewhere else...
-	atomic.AddInt64(&binancew.BacktestIndex, 1)
-
 	return nil
 }
 
@@ -159,6 +154,7 @@ func orderToMap(data *storage.Order) map[string]string {
 	dataMap["Decision"] = data.Decision
 	dataMap["Strategy"] = data.Strategy
 	dataMap["Successful"] = fmt.Sprint(data.Successful)
+	dataMap["Trace"] = data.Trace.String()
 
 	return dataMap
 }
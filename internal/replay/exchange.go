@@ -0,0 +1,179 @@
+package replay
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+// Amount of klines the exchange serves at once by default
+const klinesLimit = 500
+
+// exchange serves the replayed klines up to the current tick only, and fills
+// orders right away at their price with the backtest costs on top.
+type exchange struct {
+	binancew.ExchangeClient
+	symbols  []string
+	sources  map[string]candles.KlineIterator
+	fees     backtest.Fees
+	slippage backtest.Slippage
+	// Revealed klines and the next one of every symbol
+	windows map[string][]*binance.Kline
+	next    map[string]*binance.Kline
+	orders  map[string][]*binance.Order
+	lock    sync.RWMutex
+}
+
+func newExchange(symbols []string, sources map[string]candles.KlineIterator, fees backtest.Fees, slippage backtest.Slippage) (*exchange, error) {
+	e := &exchange{
+		ExchangeClient: binancew.NewExtClientSim("", ""),
+		symbols:        symbols,
+		sources:        sources,
+		fees:           fees,
+		slippage:       slippage,
+		windows:        map[string][]*binance.Kline{},
+		next:           map[string]*binance.Kline{},
+		orders:         map[string][]*binance.Order{},
+	}
+
+	for _, s := range symbols {
+		k, err := nextKline(sources[s])
+		if err != nil {
+			return nil, err
+		}
+		e.next[s] = k
+	}
+
+	return e, nil
+}
+
+// reveal hands out every kline closed by the time. It returns the close
+// time of the next kline to come, zero once they ran out.
+func (e *exchange) reveal(now time.Time) (time.Time, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	step := globals.Durations[globals.Timeframe]
+	var upcoming time.Time
+	for _, s := range e.symbols {
+		for e.next[s] != nil && !closeTime(e.next[s], step).After(now) {
+			window := append(e.windows[s], e.next[s])
+			if len(window) > klinesLimit {
+				window = window[len(window)-klinesLimit:]
+			}
+			e.windows[s] = window
+
+			var err error
+			e.next[s], err = nextKline(e.sources[s])
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+
+		if k := e.next[s]; k != nil && (upcoming.IsZero() || closeTime(k, step).Before(upcoming)) {
+			upcoming = closeTime(k, step)
+		}
+	}
+
+	return upcoming, nil
+}
+
+func (e *exchange) GetKlines(symbol, timeframe string) ([]*binance.Kline, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if timeframe != globals.Timeframe {
+		return nil, globals.ErrWrongTimeframe
+	}
+
+	return append([]*binance.Kline{}, e.windows[symbol]...), nil
+}
+
+func (e *exchange) GetKlinesByPeriod(symbol, timeframe string, start, end time.Time) ([]*binance.Kline, error) {
+	klines, err := e.GetKlines(symbol, timeframe)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*binance.Kline{}
+	for _, k := range klines {
+		if k.OpenTime >= start.UnixMilli() && k.OpenTime <= end.UnixMilli() {
+			result = append(result, k)
+		}
+	}
+
+	return result, nil
+}
+
+func (e *exchange) CreateOrder(symbol, quantity, price string, side binance.SideType) (*binance.CreateOrderResponse, error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	qty, _ := strconv.ParseFloat(quantity, 64)
+	fill, _ := strconv.ParseFloat(price, 64)
+	if window := e.windows[symbol]; e.slippage != nil && len(window) != 0 {
+		slip := e.slippage.Slip(fill, qty, window[len(window)-1])
+		if side == binance.SideType(globals.Sell) {
+			slip = -slip
+		}
+		fill += slip
+	}
+	fillPrice := strconv.FormatFloat(fill, 'f', -1, 64)
+
+	e.orders[symbol] = append(e.orders[symbol], &binance.Order{
+		Symbol:           symbol,
+		OrderID:          int64(len(e.orders[symbol]) + 1),
+		Price:            fillPrice,
+		OrigQuantity:     quantity,
+		ExecutedQuantity: quantity,
+		Status:           binance.OrderStatusTypeFilled,
+		Type:             binance.OrderTypeLimit,
+		Side:             side,
+	})
+
+	return &binance.CreateOrderResponse{
+		Symbol:           symbol,
+		Side:             side,
+		Type:             binance.OrderTypeLimit,
+		Price:            price,
+		OrigQuantity:     quantity,
+		ExecutedQuantity: quantity,
+		Status:           binance.OrderStatusTypeFilled,
+		Fills: []*binance.Fill{{
+			Price:      fillPrice,
+			Quantity:   quantity,
+			Commission: strconv.FormatFloat(e.fees.Fee(symbol, fill*qty, false), 'f', -1, 64),
+		}},
+	}, nil
+}
+
+func (e *exchange) GetOrders(symbol string) ([]*binance.Order, error) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return append([]*binance.Order{}, e.orders[symbol]...), nil
+}
+
+func (e *exchange) GetAllSymbols() []string {
+	return e.symbols
+}
+
+func closeTime(k *binance.Kline, step time.Duration) time.Time {
+	return time.UnixMilli(k.OpenTime).Add(step)
+}
+
+func nextKline(it candles.KlineIterator) (*binance.Kline, error) {
+	k, err := it.Next()
+	if err == io.EOF {
+		return nil, nil
+	}
+
+	return k, err
+}
@@ -0,0 +1,263 @@
+package replay
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/clock"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/output"
+	"github.com/ws396/autobinance/internal/signals"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/trader"
+	"github.com/ws396/autobinance/internal/util"
+)
+
+// MaxSpeed replays candles as fast as the session gets through them.
+const MaxSpeed = 0
+
+// Replay feeds stored klines through a live trading session. Every candle
+// close becomes a tick of the session, paced by a clock running Speed times
+// faster than real time. The next tick only comes once the session has
+// written the orders of the last one, so it never sees candles ahead of time.
+type Replay struct {
+	Clock    *clock.Simulated
+	Speed    float64
+	exchange *exchange
+	sources  map[string]candles.KlineIterator
+	// Close time of the first kline to replay
+	next     time.Time
+	ticks    chan time.Time
+	written  chan struct{}
+	resume   chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	trader   *trader.Trader
+}
+
+// New replays the klines of the symbols from the start. Klines from before it
+// are there from the first tick, like the history the exchange serves.
+func New(symbols []string, start, end time.Time, speed float64) (*Replay, error) {
+	if len(symbols) == 0 {
+		return nil, globals.ErrSymbolsNotFound
+	}
+
+	fees, slippage, err := backtest.CostsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	step := globals.Durations[globals.Timeframe]
+	store := candles.DefaultStore()
+	sources := map[string]candles.KlineIterator{}
+	for _, s := range symbols {
+		// The end date is inclusive, like in downloads
+		cursor, err := store.Range(s, globals.Timeframe, start.Add(-klinesLimit*step), end.Add(24*time.Hour))
+		if err != nil {
+			closeSources(sources)
+			return nil, err
+		}
+		sources[s] = candles.Klines(cursor)
+	}
+
+	return newReplay(symbols, sources, start, speed, fees, slippage)
+}
+
+func newReplay(symbols []string, sources map[string]candles.KlineIterator, start time.Time, speed float64, fees backtest.Fees, slippage backtest.Slippage) (*Replay, error) {
+	ex, err := newExchange(symbols, sources, fees, slippage)
+	if err != nil {
+		closeSources(sources)
+		return nil, err
+	}
+	next, err := ex.reveal(start)
+	if err == nil && next.IsZero() {
+		err = globals.ErrKlinesNotFound
+	}
+	if err != nil {
+		closeSources(sources)
+		return nil, err
+	}
+
+	return &Replay{
+		Clock:    clock.NewSimulated(start),
+		Speed:    speed,
+		exchange: ex,
+		sources:  sources,
+		next:     next,
+		ticks:    make(chan time.Time),
+		written:  make(chan struct{}),
+		resume:   make(chan struct{}),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// ParseSpeed takes speeds like 1x, 60x or max.
+func ParseSpeed(s string) (float64, error) {
+	if s == "max" {
+		return MaxSpeed, nil
+	}
+
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || !strings.HasSuffix(s, "x") || speed <= 0 {
+		return 0, globals.ErrWrongReplaySpeed
+	}
+
+	return speed, nil
+}
+
+// FromInput takes the period and the speed, ex. "01-02-2021 03-02-2021 60x".
+func FromInput(input string, symbols []string) (*Replay, error) {
+	args := strings.Split(input, " ")
+	if len(args) != 3 {
+		return nil, globals.ErrWrongArgumentAmount
+	}
+
+	start, end, err := util.ExtractTimepoints(strings.Join(args[:2], " "))
+	if err != nil {
+		return nil, err
+	}
+	speed, err := ParseSpeed(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return New(symbols, start, end, speed)
+}
+
+// Attach points the trader at the replay: its exchange, clock and ticks,
+// orders kept in memory so that rehearsals stay out of the trade history and
+// a signal queue of its own, so live signals aren't taken by the rehearsal.
+// The returned func puts the trader back, once the session is over.
+func (r *Replay) Attach(t *trader.Trader) func() {
+	exchangeClient, storageClient, tickerChan, c, queue := t.ExchangeClient, t.StorageClient, t.TickerChan, t.Clock, t.Signals
+
+	t.ExchangeClient = r.exchange
+	t.StorageClient = &replayStorage{t.StorageClient, storage.NewInMemoryClient()}
+	t.TickerChan = r.ticks
+	t.Clock = r.Clock
+	t.Signals = signals.NewQueue()
+	r.trader = t
+
+	return func() {
+		t.ExchangeClient, t.StorageClient, t.TickerChan, t.Clock, t.Signals = exchangeClient, storageClient, tickerChan, c, queue
+	}
+}
+
+// Writer lets the replay know whenever the session is done with a tick.
+func (r *Replay) Writer(w output.Writer) output.Writer {
+	return &writer{w, r}
+}
+
+// Run ticks the session until the klines run out or the replay is stopped,
+// and stops the session at the end.
+func (r *Replay) Run() error {
+	defer closeSources(r.sources)
+
+	next := r.next
+	for {
+		waited := time.Now()
+		now := next
+		var err error
+		next, err = r.exchange.reveal(now)
+		r.Clock.Set(now)
+
+		select {
+		case r.ticks <- now:
+		case <-r.stop:
+			return nil
+		}
+		select {
+		case <-r.written:
+		case <-r.stop:
+			return nil
+		}
+
+		// The session only gets going again once it's been told to stop
+		last := err != nil || next.IsZero()
+		if last && r.trader != nil {
+			r.trader.StopTradingSession()
+		}
+		select {
+		case r.resume <- struct{}{}:
+		case <-r.stop:
+			return nil
+		}
+
+		if last {
+			// Wakes the session up to notice it's stopped
+			select {
+			case r.ticks <- now:
+			case <-r.stop:
+			}
+			return err
+		}
+
+		if r.Speed == MaxSpeed {
+			continue
+		}
+		pause := time.Duration(float64(next.Sub(now))/r.Speed) - time.Since(waited)
+		select {
+		case <-time.After(pause):
+		case <-r.stop:
+			return nil
+		}
+	}
+}
+
+// Stop ends the replay early, after the session stopped.
+func (r *Replay) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+type writer struct {
+	output.Writer
+	replay *Replay
+}
+
+func (w *writer) WriteToLog(orders []*storage.Order) error {
+	err := w.Writer.WriteToLog(orders)
+
+	select {
+	case w.replay.written <- struct{}{}:
+	case <-w.replay.stop:
+		return err
+	}
+	select {
+	case <-w.replay.resume:
+	case <-w.replay.stop:
+	}
+
+	return err
+}
+
+// replayStorage keeps orders apart from the ones of live trading.
+type replayStorage struct {
+	storage.StorageClient
+	orders *storage.InMemoryClient
+}
+
+func (s *replayStorage) GetAllOrders() ([]storage.Order, error) {
+	return s.orders.GetAllOrders()
+}
+
+func (s *replayStorage) GetLastOrder(strategy, symbol string) (*storage.Order, error) {
+	return s.orders.GetLastOrder(strategy, symbol)
+}
+
+func (s *replayStorage) StoreOrder(order *storage.Order) error {
+	return s.orders.StoreOrder(order)
+}
+
+func closeSources(sources map[string]candles.KlineIterator) {
+	for _, it := range sources {
+		if c, ok := it.(interface{ Close() error }); ok {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package replay_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/output"
+	"github.com/ws396/autobinance/internal/replay"
+	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/synthetic"
+	"github.com/ws396/autobinance/internal/trader"
+)
+
+func TestReplay(t *testing.T) {
+	globals.BacktestDataDir = t.TempDir() + "/"
+	day := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	symbols := []string{"LTCBTC", "ETHBTC"}
+
+	closes := map[string]map[int64]float64{}
+	for i, s := range symbols {
+		config := synthetic.Scenarios["regimes"]
+		config.Start = day
+		config.Candles = 24 * 60
+		config.Seed = int64(i)
+		klines := synthetic.Generate(config)
+
+		closes[s] = map[int64]float64{}
+		for _, k := range klines {
+			closes[s][k.CloseTime+1], _ = strconv.ParseFloat(k.Close, 64)
+		}
+		err := candles.DefaultStore().Write(s, globals.Timeframe, candles.FromSlice(klines))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session := func(r *replay.Replay, live *trader.Trader) (ticks int, orders []storage.Order) {
+		restore := r.Attach(live)
+		replayStorage := live.StorageClient
+
+		errChan := live.StartTradingSession(r.Writer(&output.StubWriter{}))
+		done := make(chan error)
+		go func() {
+			done <- r.Run()
+		}()
+		for err := range errChan {
+			if err != nil {
+				t.Fatal(err)
+			}
+			ticks++
+		}
+		r.Stop()
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		restore()
+
+		orders, err := replayStorage.GetAllOrders()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ticks, orders
+	}
+
+	t.Run("trades stored klines through the live session", func(t *testing.T) {
+		live := mockTrader(symbols)
+		start := day.Add(18 * time.Hour)
+		r, err := replay.New(symbols, start, day, replay.MaxSpeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ticks, orders := session(r, live)
+
+		if ticks != 6*60 {
+			t.Errorf("expected a tick on every candle close, got %v", ticks)
+		}
+		if len(orders) == 0 {
+			t.Fatal("expected the replay to produce orders")
+		}
+		for _, o := range orders {
+			if o.CreatedAt.Before(start) || o.CreatedAt.Second() != 0 {
+				t.Fatalf("order is not stamped with replay time, got %v", o.CreatedAt)
+			}
+			// Decisions are made on the last closed candle
+			if close := closes[o.Symbol][o.CreatedAt.UnixMilli()]; math.Abs(close-o.Price) > close*1e-9 {
+				t.Fatalf("order is not priced at the candle closing at its time, got %+v", o)
+			}
+		}
+
+		liveOrders, _ := live.StorageClient.GetAllOrders()
+		if len(liveOrders) != 0 || live.TradingRunning || live.Clock != nil {
+			t.Error("expected the trader to be put back without replay orders")
+		}
+	})
+
+	t.Run("paces ticks by speed", func(t *testing.T) {
+		start := day.Add(23*time.Hour + 40*time.Minute)
+		// A minute every 10ms
+		r, err := replay.New(symbols, start, day, 6000)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		began := time.Now()
+		ticks, _ := session(r, mockTrader(symbols))
+
+		if elapsed := time.Since(began); ticks != 20 || elapsed < 190*time.Millisecond {
+			t.Errorf("expected 20 ticks over 200ms, got %v over %v", ticks, elapsed)
+		}
+	})
+
+	t.Run("parses speeds", func(t *testing.T) {
+		for s, want := range map[string]float64{"1x": 1, "60x": 60, "max": replay.MaxSpeed} {
+			got, err := replay.ParseSpeed(s)
+			if err != nil || got != want {
+				t.Errorf("wrong speed for %v, got %v", s, got)
+			}
+		}
+		for _, s := range []string{"60", "0x", "fast"} {
+			if _, err := replay.ParseSpeed(s); err != globals.ErrWrongReplaySpeed {
+				t.Errorf("expected wrong speed error for %v, got %v", s, err)
+			}
+		}
+	})
+}
+
+func mockTrader(symbols []string) *trader.Trader {
+	return &trader.Trader{
+		StorageClient: storage.NewInMemoryClient(),
+		Settings: map[string]storage.Setting{
+			"selected_symbols": {
+				Name:     "selected_symbols",
+				ValueArr: symbols,
+			},
+			"selected_strategies": {
+				Name:     "selected_strategies",
+				ValueArr: []string{"example"},
+			},
+		},
+	}
+}
@@ -0,0 +1,253 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/analysis"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+)
+
+// Charts get slow in the browser past this many points per line
+const MaxPoints = 5000
+
+var (
+	Dir = "reports/"
+
+	//go:embed report.html.tmpl
+	page     string
+	pageTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+		"money":   func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+		"percent": func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) + "%" },
+		"date":    func(t time.Time) string { return t.Format("02-01-2006 15:04") },
+		"mul":     func(a, b float64) float64 { return a * b },
+		"deref":   func(v *float64) float64 { return *v },
+		"heat":    heat,
+		"analyses": func(portfolio storage.Analysis, analyses []storage.Analysis) []storage.Analysis {
+			return append([]storage.Analysis{portfolio}, analyses...)
+		},
+	}).Parse(page))
+)
+
+type Point struct {
+	T int64   `json:"t"`
+	V float64 `json:"v"`
+}
+
+type Marker struct {
+	T        int64   `json:"t"`
+	Price    float64 `json:"price"`
+	Side     string  `json:"side"`
+	Strategy string  `json:"strategy"`
+}
+
+type Chart struct {
+	Symbol   string             `json:"symbol"`
+	Prices   []Point            `json:"prices"`
+	Markers  []Marker           `json:"markers"`
+	Overlays map[string][]Point `json:"overlays"`
+}
+
+type MonthRow struct {
+	Year int
+	// Return of every month in percent, nil when nothing was closed in it
+	Months [12]*float64
+}
+
+type Report struct {
+	Start     time.Time
+	End       time.Time
+	Charts    []Chart
+	Equity    []Point
+	Drawdown  []Point
+	Trades    []analysis.RoundTrip
+	Monthly   []MonthRow
+	Portfolio storage.Analysis
+	Analyses  []storage.Analysis
+	Ambiguous int
+}
+
+// Build gathers everything the page shows from the result of a backtest.
+func Build(result *backtest.Result) Report {
+	start, end := result.Config.Start, result.Config.End
+	trips := analysis.RoundTrips(result.Orders)
+	curve := analysis.EquityCurve(trips, globals.BuyAmount, start)
+
+	r := Report{
+		Start:     start,
+		End:       end,
+		Trades:    trips,
+		Monthly:   monthly(curve),
+		Portfolio: result.Portfolio,
+		Ambiguous: result.AmbiguousFills,
+	}
+
+	var peak float64
+	for _, p := range curve {
+		peak = math.Max(peak, p.Equity)
+		r.Equity = append(r.Equity, Point{p.Time.UnixMilli(), p.Equity})
+		r.Drawdown = append(r.Drawdown, Point{p.Time.UnixMilli(), -(peak - p.Equity) / peak * 100})
+	}
+
+	for _, a := range result.Analyses {
+		r.Analyses = append(r.Analyses, a)
+	}
+	sort.Slice(r.Analyses, func(i, j int) bool {
+		return r.Analyses[i].Strategy+r.Analyses[i].Symbol < r.Analyses[j].Strategy+r.Analyses[j].Symbol
+	})
+
+	symbols := []string{}
+	for s := range result.Klines {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	for _, s := range symbols {
+		r.Charts = append(r.Charts, chart(s, result.Klines[s], result.Orders))
+	}
+
+	return r
+}
+
+func chart(symbol string, klines []*binance.Kline, orders []storage.Order) Chart {
+	c := Chart{Symbol: symbol, Overlays: map[string][]Point{}}
+
+	prices := make([]Point, len(klines))
+	for i, k := range klines {
+		v, _ := strconv.ParseFloat(k.Close, 64)
+		prices[i] = Point{k.CloseTime + 1, v}
+	}
+	c.Prices = downsample(prices)
+
+	for _, o := range orders {
+		if o.Symbol != symbol {
+			continue
+		}
+		if o.Successful {
+			c.Markers = append(c.Markers, Marker{o.CreatedAt.UnixMilli(), o.Price, o.Decision, o.Strategy})
+		}
+
+		// Indicators that aren't numbers can't be drawn
+		for name, value := range o.Indicators {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			k := o.Strategy + " " + name
+			c.Overlays[k] = append(c.Overlays[k], Point{o.CreatedAt.UnixMilli(), v})
+		}
+	}
+
+	for k, points := range c.Overlays {
+		c.Overlays[k] = downsample(points)
+	}
+
+	return c
+}
+
+// downsample keeps every n-th point, and always the last one.
+func downsample(points []Point) []Point {
+	if len(points) <= MaxPoints {
+		return points
+	}
+
+	step := int(math.Ceil(float64(len(points)) / MaxPoints))
+	result := []Point{}
+	for i := 0; i < len(points); i += step {
+		result = append(result, points[i])
+	}
+	if result[len(result)-1] != points[len(points)-1] {
+		result = append(result, points[len(points)-1])
+	}
+
+	return result
+}
+
+// monthly returns are relative to the equity at the start of every month.
+func monthly(curve []analysis.EquityPoint) []MonthRow {
+	if len(curve) < 2 {
+		return nil
+	}
+
+	type month struct{ year, month int }
+	profits := map[month]float64{}
+	opening := map[month]float64{}
+	for i := 1; i < len(curve); i++ {
+		m := month{curve[i].Time.Year(), int(curve[i].Time.Month())}
+		if _, ok := opening[m]; !ok {
+			opening[m] = curve[i-1].Equity
+		}
+		profits[m] += curve[i].Equity - curve[i-1].Equity
+	}
+
+	rows := []MonthRow{}
+	for y := curve[0].Time.Year(); y <= curve[len(curve)-1].Time.Year(); y++ {
+		row := MonthRow{Year: y}
+		for m := 1; m <= 12; m++ {
+			k := month{y, m}
+			if _, ok := profits[k]; !ok {
+				continue
+			}
+			v := profits[k] / opening[k] * 100
+			row.Months[m-1] = &v
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+// heat colors a monthly return, green for gains and red for losses, fully
+// saturated at 10%.
+func heat(v *float64) template.CSS {
+	if v == nil {
+		return ""
+	}
+
+	alpha := math.Min(math.Abs(*v)/10, 1)
+	if *v >= 0 {
+		return template.CSS(fmt.Sprintf("background: rgba(44, 160, 44, %.2f)", alpha))
+	}
+	return template.CSS(fmt.Sprintf("background: rgba(214, 39, 40, %.2f)", alpha))
+}
+
+func Write(w io.Writer, r Report) error {
+	return pageTmpl.Execute(w, r)
+}
+
+// WriteFile saves the report of the backtest into Dir and returns its path.
+func WriteFile(result *backtest.Result) (string, error) {
+	err := os.MkdirAll(Dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(Dir, fmt.Sprintf(
+		"backtest_%s_%s_%d.html",
+		result.Config.Start.Format("02-01-2006"),
+		result.Config.End.Format("02-01-2006"),
+		time.Now().Unix(),
+	))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	err = Write(f, Build(result))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Abs(path)
+}
@@ -0,0 +1,68 @@
+package report_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/report"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestReport(t *testing.T) {
+	start := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	feed := map[string][]*binance.Kline{
+		"LTCBTC": testutil.MockKlines(start, testutil.WaveCloses(300, 40)...),
+	}
+	result, err := backtest.NewEngine(backtest.Config{
+		Start:             start,
+		End:               start.Add(300 * time.Minute),
+		Symbols:           []string{"LTCBTC"},
+		Strategies:        []string{"example"},
+		Timeframe:         "1m",
+		WindowSize:        20,
+		RecordEvaluations: true,
+	}, feed).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := report.Build(result)
+
+	if len(r.Charts) != 1 || len(r.Charts[0].Prices) != 300 {
+		t.Fatalf("expected a chart of every candle, got %v", len(r.Charts))
+	}
+	if len(r.Charts[0].Markers) == 0 || len(r.Charts[0].Overlays) == 0 {
+		t.Error("expected the chart to have markers and indicator overlays")
+	}
+	if len(r.Equity) != len(r.Trades)+1 || len(r.Drawdown) != len(r.Equity) {
+		t.Errorf("expected an equity and drawdown point per trade, got %v %v", len(r.Equity), len(r.Drawdown))
+	}
+
+	buf := &bytes.Buffer{}
+	err = report.Write(buf, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := buf.String()
+	if strings.Contains(page, "<script src") || strings.Contains(page, "<link") {
+		t.Error("expected the report to be self-contained")
+	}
+	if !strings.Contains(page, `"symbol":"LTCBTC"`) {
+		t.Error("expected chart data to be embedded in the page")
+	}
+
+	report.Dir = t.TempDir()
+	path, err := report.WriteFile(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected report at %v, got %v", path, err)
+	}
+}
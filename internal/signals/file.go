@@ -0,0 +1,133 @@
+package signals
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// WatchFile polls a JSON-lines file and pushes every newly appended signal to
+// the queue. Signals already in the file when it starts were handled before
+// a restart, so only the line still being written is read from what's there.
+// A truncated file is read again from the start.
+func WatchFile(ctx context.Context, path string, q *Queue, interval time.Duration) chan error {
+	errChan := make(chan error)
+	offset, startErr := lastLine(path)
+
+	go func() {
+		defer close(errChan)
+
+		if startErr != nil && !sendErr(ctx, errChan, startErr) {
+			return
+		}
+		var partial []byte
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			var err error
+			offset, partial, err = readNewLines(path, offset, partial, q)
+			if err != nil && !sendErr(ctx, errChan, err) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return errChan
+}
+
+// lastLine is the offset of the line at the end of the file, which is
+// empty unless it's still being written.
+func lastLine(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return int64(bytes.LastIndexByte(data, '\n') + 1), nil
+}
+
+func readNewLines(path string, offset int64, partial []byte, q *Queue) (int64, []byte, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil, nil
+	} else if err != nil {
+		return offset, partial, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return offset, partial, err
+	}
+	if info.Size() < offset {
+		offset, partial = 0, nil
+	}
+
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return offset, partial, err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return offset, partial, err
+	}
+	offset += int64(len(data))
+
+	data = append(partial, data...)
+	last := bytes.LastIndexByte(data, '\n')
+	if last == -1 {
+		return offset, data, nil
+	}
+
+	var errs []error
+	scanner := bufio.NewScanner(bytes.NewReader(data[:last]))
+	for scanner.Scan() {
+		if err := pushLine(scanner.Bytes(), q); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	partial = append([]byte{}, data[last+1:]...)
+	if len(errs) != 0 {
+		return offset, partial, errs[0]
+	}
+
+	return offset, partial, nil
+}
+
+func pushLine(line []byte, q *Queue) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	s, err := ParseSignal(line)
+	if err != nil {
+		return err
+	}
+
+	return q.Push(s, time.Now())
+}
+
+func sendErr(ctx context.Context, errChan chan error, err error) bool {
+	select {
+	case errChan <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
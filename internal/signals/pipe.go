@@ -0,0 +1,50 @@
+package signals
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+)
+
+// ReadPipe reads JSON-lines signals from a named pipe, creating it if needed.
+// The pipe is opened for reading and writing, so writers may come and go
+// without the reader seeing EOF.
+func ReadPipe(ctx context.Context, path string, q *Queue) chan error {
+	errChan := make(chan error)
+
+	go func() {
+		defer close(errChan)
+
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if err := mkfifo(path); err != nil {
+				sendErr(ctx, errChan, err)
+				return
+			}
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			sendErr(ctx, errChan, err)
+			return
+		}
+
+		go func() {
+			<-ctx.Done()
+			f.Close()
+		}()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if err := pushLine(scanner.Bytes(), q); err != nil && !sendErr(ctx, errChan, err) {
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			sendErr(ctx, errChan, err)
+		}
+	}()
+
+	return errChan
+}
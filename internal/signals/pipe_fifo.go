@@ -0,0 +1,9 @@
+//go:build !windows
+
+package signals
+
+import "syscall"
+
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
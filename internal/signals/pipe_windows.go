@@ -0,0 +1,7 @@
+package signals
+
+import "github.com/ws396/autobinance/internal/globals"
+
+func mkfifo(path string) error {
+	return globals.ErrPipeNotSupported
+}
@@ -0,0 +1,121 @@
+package signals
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+var symbolPattern = regexp.MustCompile("^[A-Z0-9]{2,20}$")
+
+// MaxSize is the largest size a signal can ask for, no limit if not set.
+var MaxSize float64
+
+// Signal is a trading decision produced outside of autobinance.
+// Size is an optional base asset quantity for buys, zero means default sizing.
+// Buys never go over the default sizing, a size only makes them smaller.
+type Signal struct {
+	ID        string    `json:"id"`
+	Symbol    string    `json:"symbol"`
+	Decision  string    `json:"decision"`
+	Size      float64   `json:"size,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (s Signal) Validate(now time.Time) error {
+	if s.ID == "" {
+		return globals.ErrSignalMissingID
+	}
+	if !symbolPattern.MatchString(s.Symbol) {
+		return globals.ErrSignalWrongSymbol
+	}
+	if s.Decision != globals.Buy && s.Decision != globals.Sell && s.Decision != globals.Hold {
+		return globals.ErrSignalWrongDecision
+	}
+	if s.Size < 0 {
+		return globals.ErrSignalWrongSize
+	}
+	if MaxSize > 0 && s.Size > MaxSize {
+		return globals.ErrSignalTooLarge
+	}
+	if s.ExpiresAt.IsZero() || !s.ExpiresAt.After(now) {
+		return globals.ErrSignalExpired
+	}
+
+	return nil
+}
+
+func ParseSignal(data []byte) (Signal, error) {
+	var s Signal
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return Signal{}, err
+	}
+
+	s.Symbol = strings.ToUpper(strings.TrimSpace(s.Symbol))
+	s.Decision = strings.ToUpper(strings.TrimSpace(s.Decision))
+
+	return s, nil
+}
+
+// Queue keeps the latest pending signal per symbol. IDs are remembered until
+// their signal expires, so a resent signal is rejected instead of traded twice.
+type Queue struct {
+	pending map[string]Signal
+	seen    map[string]time.Time
+	lock    sync.Mutex
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		pending: map[string]Signal{},
+		seen:    map[string]time.Time{},
+	}
+}
+
+func (q *Queue) Push(s Signal, now time.Time) error {
+	err := s.Validate(now)
+	if err != nil {
+		return err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for id, expiresAt := range q.seen {
+		if !expiresAt.After(now) {
+			delete(q.seen, id)
+		}
+	}
+
+	if _, ok := q.seen[s.ID]; ok {
+		return globals.ErrSignalDuplicate
+	}
+
+	q.seen[s.ID] = s.ExpiresAt
+	q.pending[s.Symbol] = s
+
+	return nil
+}
+
+// Take removes and returns the pending signal for the symbol, if it hasn't expired yet.
+func (q *Queue) Take(symbol string, now time.Time) (Signal, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	s, ok := q.pending[symbol]
+	if !ok {
+		return Signal{}, false
+	}
+
+	delete(q.pending, symbol)
+	if !s.ExpiresAt.After(now) {
+		return Signal{}, false
+	}
+
+	return s, true
+}
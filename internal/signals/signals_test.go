@@ -0,0 +1,192 @@
+package signals
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+func TestQueue(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	t.Run("rejects invalid signals", func(t *testing.T) {
+		q := NewQueue()
+		cases := map[Signal]error{
+			{Symbol: "LTCBTC", Decision: globals.Buy, ExpiresAt: now.Add(time.Minute)}:                    globals.ErrSignalMissingID,
+			{ID: "1", Symbol: "ltc btc", Decision: globals.Buy, ExpiresAt: now.Add(time.Minute)}:          globals.ErrSignalWrongSymbol,
+			{ID: "1", Symbol: "LTCBTC", Decision: "SHORT", ExpiresAt: now.Add(time.Minute)}:               globals.ErrSignalWrongDecision,
+			{ID: "1", Symbol: "LTCBTC", Decision: globals.Buy, Size: -1, ExpiresAt: now.Add(time.Minute)}: globals.ErrSignalWrongSize,
+			{ID: "1", Symbol: "LTCBTC", Decision: globals.Buy, ExpiresAt: now}:                            globals.ErrSignalExpired,
+		}
+
+		for s, want := range cases {
+			got := q.Push(s, now)
+			if !errors.Is(got, want) {
+				t.Errorf("wrong validation result for %v, got %v want %v", s, got, want)
+			}
+		}
+	})
+
+	t.Run("rejects signals over the max size", func(t *testing.T) {
+		MaxSize = 1
+		defer func() { MaxSize = 0 }()
+
+		q := NewQueue()
+		s := Signal{ID: "1", Symbol: "LTCBTC", Decision: globals.Buy, Size: 2, ExpiresAt: now.Add(time.Minute)}
+		if err := q.Push(s, now); !errors.Is(err, globals.ErrSignalTooLarge) {
+			t.Errorf("expected too large error, got %v", err)
+		}
+	})
+
+	t.Run("deduplicates signals by id", func(t *testing.T) {
+		q := NewQueue()
+		s := Signal{ID: "1", Symbol: "LTCBTC", Decision: globals.Buy, ExpiresAt: now.Add(time.Minute)}
+
+		if err := q.Push(s, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Push(s, now); !errors.Is(err, globals.ErrSignalDuplicate) {
+			t.Errorf("expected duplicate error, got %v", err)
+		}
+	})
+
+	t.Run("drops signals that expired while pending", func(t *testing.T) {
+		q := NewQueue()
+		s := Signal{ID: "1", Symbol: "LTCBTC", Decision: globals.Buy, ExpiresAt: now.Add(time.Minute)}
+		q.Push(s, now)
+
+		if _, ok := q.Take("LTCBTC", now.Add(2*time.Minute)); ok {
+			t.Error("expected expired signal to be dropped")
+		}
+	})
+
+	t.Run("takes pending signal once", func(t *testing.T) {
+		q := NewQueue()
+		s := Signal{ID: "1", Symbol: "LTCBTC", Decision: globals.Sell, ExpiresAt: now.Add(time.Minute)}
+		q.Push(s, now)
+
+		got, ok := q.Take("LTCBTC", now)
+		if !ok || got != s {
+			t.Errorf("got %v want %v", got, s)
+		}
+		if _, ok := q.Take("LTCBTC", now); ok {
+			t.Error("expected signal to be taken only once")
+		}
+	})
+}
+
+func TestWebhook(t *testing.T) {
+	q := NewQueue()
+	ts := httptest.NewServer(NewWebhookHandler(q, "secret"))
+	defer ts.Close()
+
+	body := `{"id":"a1","symbol":"ltcbtc","decision":"buy","size":2,"expiresAt":"` +
+		time.Now().Add(time.Hour).Format(time.RFC3339) + `"}`
+
+	post := func(auth string) int {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader(body))
+		req.Header.Set("Authorization", auth)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	if got := post("Bearer wrong"); got != http.StatusUnauthorized {
+		t.Errorf("got %v want %v", got, http.StatusUnauthorized)
+	}
+	// The scheme is required
+	if got := post("secret"); got != http.StatusUnauthorized {
+		t.Errorf("got %v want %v", got, http.StatusUnauthorized)
+	}
+	if got := post("Bearer secret"); got != http.StatusAccepted {
+		t.Errorf("got %v want %v", got, http.StatusAccepted)
+	}
+	if got := post("Bearer secret"); got != http.StatusConflict {
+		t.Errorf("got %v want %v", got, http.StatusConflict)
+	}
+
+	s, ok := q.Take("LTCBTC", time.Now())
+	if !ok || s.Decision != globals.Buy || s.Size != 2 {
+		t.Errorf("wrong signal queued, got %v", s)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signals.jsonl")
+	expiresAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	line := func(id, symbol string) string {
+		return `{"id":"` + id + `","symbol":"` + symbol + `","decision":"SELL","expiresAt":"` + expiresAt + `"}`
+	}
+	watch := func(ctx context.Context, q *Queue) {
+		errChan := WatchFile(ctx, path, q, 10*time.Millisecond)
+		go func() {
+			for range errChan {
+			}
+		}()
+	}
+	wait := func(q *Queue, symbol string) (Signal, bool) {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if s, ok := q.Take(symbol, time.Now()); ok {
+				return s, true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return Signal{}, false
+	}
+
+	// The first line was there before, the second one is still being written
+	first := line("f1", "ETHBTC")
+	err := os.WriteFile(path, []byte(line("f0", "LTCBTC")+"\n"+first[:10]), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := NewQueue()
+	watch(ctx, q)
+
+	t.Run("reads the line being written once it's terminated", func(t *testing.T) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(first[10:] + "\n")
+		f.Close()
+
+		if s, ok := wait(q, "ETHBTC"); !ok || s.ID != "f1" {
+			t.Errorf("got %v want f1", s.ID)
+		}
+	})
+
+	t.Run("skips the signals that were there before it started", func(t *testing.T) {
+		if _, ok := q.Take("LTCBTC", time.Now()); ok {
+			t.Error("expected the signals from before to be skipped")
+		}
+	})
+
+	t.Run("doesn't queue signals again after a restart", func(t *testing.T) {
+		cancel()
+		q := NewQueue()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watch(ctx, q)
+
+		if s, ok := wait(q, "ETHBTC"); ok {
+			t.Errorf("expected no signal after restarting, got %v", s)
+		}
+	})
+}
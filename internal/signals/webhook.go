@@ -0,0 +1,66 @@
+package signals
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+const maxBodySize = 1 << 16
+
+// NewWebhookHandler accepts signals as JSON objects posted with an
+// "Authorization: Bearer <token>" header.
+func NewWebhookHandler(q *Queue, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		got := strings.TrimPrefix(auth, "Bearer ")
+		if token == "" || got == auth || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s, err := ParseSignal(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = q.Push(s, time.Now())
+		if errors.Is(err, globals.ErrSignalDuplicate) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func ListenWebhook(addr, token string, q *Queue) error {
+	if token == "" {
+		return globals.ErrSignalTokenMissing
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/signals", NewWebhookHandler(q, token))
+
+	return http.ListenAndServe(addr, mux)
+}
@@ -56,6 +56,9 @@ func (c *GORMClient) AutoMigrateAll() {
 	c.AutoMigrateOrders()
 	c.AutoMigrateSettings()
 	c.AutoMigrateAnalyses()
+	c.AutoMigrateOptimizationResults()
+	c.AutoMigrateMonteCarloResults()
+	c.AutoMigrateBacktestRuns()
 }
 
 func (c *GORMClient) AutoMigrateOrders() {
@@ -83,10 +86,25 @@ func (c *GORMClient) AutoMigrateAnalyses() {
 	c.AutoMigrate(&Analysis{})
 }
 
+func (c *GORMClient) AutoMigrateOptimizationResults() {
+	c.AutoMigrate(&OptimizationResult{})
+}
+
+func (c *GORMClient) AutoMigrateMonteCarloResults() {
+	c.AutoMigrate(&MonteCarloResult{})
+}
+
+func (c *GORMClient) AutoMigrateBacktestRuns() {
+	c.AutoMigrate(&BacktestRun{})
+}
+
 func (c *GORMClient) DropAll() {
 	c.Migrator().DropTable(&Setting{})
 	c.Migrator().DropTable(&Order{})
 	c.Migrator().DropTable(&Analysis{})
+	c.Migrator().DropTable(&OptimizationResult{})
+	c.Migrator().DropTable(&MonteCarloResult{})
+	c.Migrator().DropTable(&BacktestRun{})
 }
 
 func (c *GORMClient) GetAllSettings() (map[string]Setting, error) {
@@ -148,7 +166,7 @@ func (c *GORMClient) StoreSetting(name, value string) error {
 
 func (c *GORMClient) GetAllOrders() ([]Order, error) {
 	var foundOrders []Order
-	r := c.Find(&foundOrders)
+	r := c.Find(&foundOrders, "run_id = ?", 0)
 	if r.Error != nil {
 		return nil, r.Error
 	}
@@ -158,7 +176,7 @@ func (c *GORMClient) GetAllOrders() ([]Order, error) {
 
 func (c *GORMClient) GetLastOrder(strategy, symbol string) (*Order, error) {
 	var foundOrder Order
-	r := c.Last(&foundOrder, "strategy = ? AND symbol = ?", strategy, symbol)
+	r := c.Last(&foundOrder, "strategy = ? AND symbol = ? AND successful = ? AND run_id = ?", strategy, symbol, true, 0)
 	if r.Error != nil {
 		if errors.Is(r.Error, gorm.ErrRecordNotFound) {
 			return nil, globals.ErrOrderNotFound
@@ -191,3 +209,127 @@ func (c *GORMClient) StoreAnalyses(analyses map[string]Analysis) error {
 
 	return nil
 }
+
+func (c *GORMClient) GetAnalysesByFingerprint(fingerprint string) ([]Analysis, error) {
+	var foundAnalyses []Analysis
+	r := c.Find(&foundAnalyses, "fingerprint = ?", fingerprint)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return foundAnalyses, nil
+}
+
+func (c *GORMClient) StoreOptimizationResults(results []OptimizationResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	r := c.Create(&results)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
+
+func (c *GORMClient) GetOptimizationResults(batch string) ([]OptimizationResult, error) {
+	var foundResults []OptimizationResult
+	r := c.Order("rank").Find(&foundResults, "batch = ?", batch)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return foundResults, nil
+}
+
+func (c *GORMClient) StoreMonteCarloResult(result *MonteCarloResult) error {
+	r := c.Create(result)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	return nil
+}
+
+// StoreBacktestRun saves the run along with its orders and analyses, which
+// get linked to it.
+func (c *GORMClient) StoreBacktestRun(run *BacktestRun, orders []Order, analyses map[string]Analysis) error {
+	return c.Transaction(func(tx *gorm.DB) error {
+		r := tx.Create(run)
+		if r.Error != nil {
+			return r.Error
+		}
+
+		if len(orders) != 0 {
+			linked := make([]Order, len(orders))
+			for i, o := range orders {
+				o.RunID = run.ID
+				linked[i] = o
+			}
+
+			r = tx.CreateInBatches(linked, 500)
+			if r.Error != nil {
+				return r.Error
+			}
+		}
+
+		if len(analyses) != 0 {
+			linked := []Analysis{}
+			for _, a := range analyses {
+				a.RunID = run.ID
+				linked = append(linked, a)
+			}
+
+			r = tx.Create(&linked)
+			if r.Error != nil {
+				return r.Error
+			}
+		}
+
+		return nil
+	})
+}
+
+func (c *GORMClient) GetBacktestRuns() ([]BacktestRun, error) {
+	var foundRuns []BacktestRun
+	r := c.Order("id DESC").Find(&foundRuns)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return foundRuns, nil
+}
+
+func (c *GORMClient) GetBacktestRun(id uint) (*BacktestRun, error) {
+	var foundRun BacktestRun
+	r := c.First(&foundRun, id)
+	if r.Error != nil {
+		if errors.Is(r.Error, gorm.ErrRecordNotFound) {
+			return nil, globals.ErrRunNotFound
+		}
+		return nil, r.Error
+	}
+
+	return &foundRun, nil
+}
+
+func (c *GORMClient) GetOrdersByRun(id uint) ([]Order, error) {
+	var foundOrders []Order
+	r := c.Order("id").Find(&foundOrders, "run_id = ?", id)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return foundOrders, nil
+}
+
+func (c *GORMClient) GetAnalysesByRun(id uint) ([]Analysis, error) {
+	var foundAnalyses []Analysis
+	r := c.Find(&foundAnalyses, "run_id = ?", id)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return foundAnalyses, nil
+}
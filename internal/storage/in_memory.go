@@ -8,10 +8,13 @@ import (
 )
 
 type InMemoryClient struct {
-	orders   []Order
-	settings map[string]Setting
-	analyses []Analysis
-	lock     sync.RWMutex
+	orders              []Order
+	settings            map[string]Setting
+	analyses            []Analysis
+	optimizationResults []OptimizationResult
+	monteCarloResults   []MonteCarloResult
+	backtestRuns        []BacktestRun
+	lock                sync.RWMutex
 }
 
 func NewInMemoryClient() *InMemoryClient {
@@ -19,6 +22,9 @@ func NewInMemoryClient() *InMemoryClient {
 		[]Order{},
 		map[string]Setting{},
 		[]Analysis{},
+		[]OptimizationResult{},
+		[]MonteCarloResult{},
+		[]BacktestRun{},
 		sync.RWMutex{},
 	}
 }
@@ -27,6 +33,9 @@ func (c *InMemoryClient) AutoMigrateAll() {
 	c.AutoMigrateOrders()
 	c.AutoMigrateSettings()
 	c.AutoMigrateAnalyses()
+	c.AutoMigrateOptimizationResults()
+	c.AutoMigrateMonteCarloResults()
+	c.AutoMigrateBacktestRuns()
 }
 
 func (c *InMemoryClient) AutoMigrateOrders() {
@@ -46,12 +55,24 @@ func (c *InMemoryClient) AutoMigrateSettings() {
 func (c *InMemoryClient) AutoMigrateAnalyses() {
 }
 
+func (c *InMemoryClient) AutoMigrateOptimizationResults() {
+}
+
+func (c *InMemoryClient) AutoMigrateMonteCarloResults() {
+}
+
+func (c *InMemoryClient) AutoMigrateBacktestRuns() {
+}
+
 func (c *InMemoryClient) DropAll() {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	c.orders = []Order{}
 	c.settings = map[string]Setting{}
 	c.analyses = []Analysis{}
+	c.optimizationResults = []OptimizationResult{}
+	c.monteCarloResults = []MonteCarloResult{}
+	c.backtestRuns = []BacktestRun{}
 }
 
 func (c *InMemoryClient) GetAllSettings() (map[string]Setting, error) {
@@ -92,15 +113,21 @@ func (c *InMemoryClient) StoreSetting(name, value string) error {
 func (c *InMemoryClient) GetAllOrders() ([]Order, error) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
+	foundOrders := []Order{}
+	for _, o := range c.orders {
+		if o.RunID == 0 {
+			foundOrders = append(foundOrders, o)
+		}
+	}
 
-	return c.orders, nil
+	return foundOrders, nil
 }
 
 func (c *InMemoryClient) GetLastOrder(strategy, symbol string) (*Order, error) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	for i := len(c.orders) - 1; i >= 0; i-- {
-		if c.orders[i].Strategy == strategy && c.orders[i].Symbol == symbol {
+		if c.orders[i].Strategy == strategy && c.orders[i].Symbol == symbol && c.orders[i].Successful && c.orders[i].RunID == 0 {
 			return &c.orders[i], nil
 		}
 	}
@@ -125,3 +152,112 @@ func (c *InMemoryClient) StoreAnalyses(analyses map[string]Analysis) error {
 
 	return nil
 }
+
+func (c *InMemoryClient) GetAnalysesByFingerprint(fingerprint string) ([]Analysis, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	foundAnalyses := []Analysis{}
+	for _, a := range c.analyses {
+		if a.Fingerprint == fingerprint {
+			foundAnalyses = append(foundAnalyses, a)
+		}
+	}
+
+	return foundAnalyses, nil
+}
+
+func (c *InMemoryClient) StoreOptimizationResults(results []OptimizationResult) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.optimizationResults = append(c.optimizationResults, results...)
+
+	return nil
+}
+
+func (c *InMemoryClient) GetOptimizationResults(batch string) ([]OptimizationResult, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	foundResults := []OptimizationResult{}
+	for _, r := range c.optimizationResults {
+		if r.Batch == batch {
+			foundResults = append(foundResults, r)
+		}
+	}
+
+	return foundResults, nil
+}
+
+func (c *InMemoryClient) StoreMonteCarloResult(result *MonteCarloResult) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.monteCarloResults = append(c.monteCarloResults, *result)
+
+	return nil
+}
+
+func (c *InMemoryClient) StoreBacktestRun(run *BacktestRun, orders []Order, analyses map[string]Analysis) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	run.ID = uint(len(c.backtestRuns) + 1)
+	c.backtestRuns = append(c.backtestRuns, *run)
+	for _, o := range orders {
+		o.RunID = run.ID
+		c.orders = append(c.orders, o)
+	}
+	for _, a := range analyses {
+		a.RunID = run.ID
+		c.analyses = append(c.analyses, a)
+	}
+
+	return nil
+}
+
+func (c *InMemoryClient) GetBacktestRuns() ([]BacktestRun, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	foundRuns := []BacktestRun{}
+	for i := len(c.backtestRuns) - 1; i >= 0; i-- {
+		foundRuns = append(foundRuns, c.backtestRuns[i])
+	}
+
+	return foundRuns, nil
+}
+
+func (c *InMemoryClient) GetBacktestRun(id uint) (*BacktestRun, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for i := range c.backtestRuns {
+		if c.backtestRuns[i].ID == id {
+			run := c.backtestRuns[i]
+			return &run, nil
+		}
+	}
+
+	return nil, globals.ErrRunNotFound
+}
+
+func (c *InMemoryClient) GetOrdersByRun(id uint) ([]Order, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	foundOrders := []Order{}
+	for _, o := range c.orders {
+		if o.RunID == id {
+			foundOrders = append(foundOrders, o)
+		}
+	}
+
+	return foundOrders, nil
+}
+
+func (c *InMemoryClient) GetAnalysesByRun(id uint) ([]Analysis, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	foundAnalyses := []Analysis{}
+	for _, a := range c.analyses {
+		if a.RunID == id {
+			foundAnalyses = append(foundAnalyses, a)
+		}
+	}
+
+	return foundAnalyses, nil
+}
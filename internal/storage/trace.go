@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Trace records how a strategy came to its decision, rule by rule.
+type Trace []RuleTrace
+
+type RuleTrace struct {
+	Rule       string      `json:"rule"`
+	Conditions []Condition `json:"conditions"`
+	Result     bool        `json:"result"`
+}
+
+type Condition struct {
+	Name   string            `json:"name"`
+	Inputs map[string]string `json:"inputs"`
+	Result bool              `json:"result"`
+}
+
+// NewRuleTrace is satisfied only if every one of its conditions is.
+func NewRuleTrace(rule string, conditions ...Condition) RuleTrace {
+	result := len(conditions) != 0
+	for _, c := range conditions {
+		result = result && c.Result
+	}
+
+	return RuleTrace{rule, conditions, result}
+}
+
+func (t Trace) String() string {
+	rules := []string{}
+	for _, r := range t {
+		conditions := []string{}
+		for _, c := range r.Conditions {
+			keys := []string{}
+			for k := range c.Inputs {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			inputs := []string{}
+			for _, k := range keys {
+				inputs = append(inputs, k+"="+c.Inputs[k])
+			}
+
+			conditions = append(conditions, fmt.Sprintf(
+				"%s: %v (%s)", c.Name, c.Result, strings.Join(inputs, ", "),
+			))
+		}
+
+		rules = append(rules, fmt.Sprintf(
+			"%s: %v [%s]", r.Rule, r.Result, strings.Join(conditions, "; "),
+		))
+	}
+
+	return strings.Join(rules, "\n")
+}
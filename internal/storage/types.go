@@ -2,17 +2,27 @@ package storage
 
 import "time"
 
+// Order is priced at its fills when the exchange reports them. Fee is the
+// commission of the fills, and Slippage is the cost of filling away from the
+// price the decision was made at. Ambiguous backtest fills came from candles
+// that reached more than one resting order.
 type Order struct {
-	ID         uint              `json:"id" gorm:"primary_key;auto_increment"`
-	Strategy   string            `json:"strategy"`
-	Symbol     string            `json:"symbol"`
-	Decision   string            `json:"decision"`
-	Quantity   float64           `json:"quantity"`
-	Price      float64           `json:"price"`
-	Indicators map[string]string `json:"indicators" gorm:"serializer:json"`
-	Timeframe  string            `json:"timeframe"`
-	Successful bool              `json:"successful"`
-	CreatedAt  time.Time         `json:"createdAt"`
+	ID          uint              `json:"id" gorm:"primary_key;auto_increment"`
+	Strategy    string            `json:"strategy"`
+	Fingerprint string            `json:"fingerprint" gorm:"index"`
+	Symbol      string            `json:"symbol"`
+	Decision    string            `json:"decision"`
+	Quantity    float64           `json:"quantity"`
+	Price       float64           `json:"price"`
+	Fee         float64           `json:"fee"`
+	Slippage    float64           `json:"slippage"`
+	Ambiguous   bool              `json:"ambiguous"`
+	Indicators  map[string]string `json:"indicators" gorm:"serializer:json"`
+	Trace       Trace             `json:"trace" gorm:"serializer:json"`
+	Timeframe   string            `json:"timeframe"`
+	Successful  bool              `json:"successful"`
+	RunID       uint              `json:"runID" gorm:"index"`
+	CreatedAt   time.Time         `json:"createdAt"`
 }
 
 type Setting struct {
@@ -22,26 +32,146 @@ type Setting struct {
 }
 
 type Analysis struct {
-	ID              uint      `json:"id" gorm:"primary_key;auto_increment"`
-	Strategy        string    `json:"strategy" validate:"required"`
-	Symbol          string    `json:"symbol" validate:"required"`
-	Buys            uint      `json:"buys"`
-	Sells           uint      `json:"sells"`
-	SuccessfulSells uint      `json:"successfulSells"`
-	ProfitUSD       float64   `json:"profitUSD"`
-	SuccessRate     float64   `json:"successRate"`
-	Timeframe       string    `json:"timeframe"`
-	Start           time.Time `json:"start"`
-	End             time.Time `json:"end"`
-	CreatedAt       time.Time `json:"createdAt"`
+	ID              uint         `json:"id" gorm:"primary_key;auto_increment"`
+	Strategy        string       `json:"strategy" validate:"required"`
+	Fingerprint     string       `json:"fingerprint" gorm:"index"`
+	Symbol          string       `json:"symbol" validate:"required"`
+	Buys            uint         `json:"buys"`
+	Sells           uint         `json:"sells"`
+	SuccessfulSells uint         `json:"successfulSells"`
+	ProfitUSD       float64      `json:"profitUSD"`
+	SuccessRate     float64      `json:"successRate"`
+	Fees            float64      `json:"fees"`
+	Slippage        float64      `json:"slippage"`
+	Metrics         Metrics      `json:"metrics" gorm:"embedded"`
+	Benchmarks      []Comparison `json:"benchmarks" gorm:"serializer:json"`
+	Timeframe       string       `json:"timeframe"`
+	Start           time.Time    `json:"start"`
+	End             time.Time    `json:"end"`
+	RunID           uint         `json:"runID" gorm:"index"`
+	CreatedAt       time.Time    `json:"createdAt"`
 	//UpdatedAt       time.Time `json:"updatedAt"`
 }
 
+// Metrics of the closed trades. Ratios that can't be computed, like the
+// profit factor without losing trades, are left at 0.
+type Metrics struct {
+	Trades              int           `json:"trades"`
+	MaxDrawdown         float64       `json:"maxDrawdown"`
+	MaxDrawdownDuration time.Duration `json:"maxDrawdownDuration"`
+	Sharpe              float64       `json:"sharpe"`
+	Sortino             float64       `json:"sortino"`
+	Calmar              float64       `json:"calmar"`
+	ProfitFactor        float64       `json:"profitFactor"`
+	Expectancy          float64       `json:"expectancy"`
+	AvgWin              float64       `json:"avgWin"`
+	AvgLoss             float64       `json:"avgLoss"`
+	// Percent of the period spent in a position
+	Exposure            float64 `json:"exposure"`
+	LongestLosingStreak int     `json:"longestLosingStreak"`
+}
+
+// Comparison of an analysis against a benchmark held over the same candles,
+// either buy-and-hold of its symbol, the basket of symbols or the benchmark symbol.
+// Returns are in percent. Alpha and beta come from the returns of every
+// candle and aren't annualized.
+type Comparison struct {
+	Benchmark    string  `json:"benchmark"`
+	Return       float64 `json:"return"`
+	ExcessReturn float64 `json:"excessReturn"`
+	Alpha        float64 `json:"alpha"`
+	Beta         float64 `json:"beta"`
+	Correlation  float64 `json:"correlation"`
+}
+
+// OptimizationResult is a single ranked trial of a parameter optimization batch.
+type OptimizationResult struct {
+	ID          uint               `json:"id" gorm:"primary_key;auto_increment"`
+	Batch       string             `json:"batch" gorm:"index"`
+	Rank        int                `json:"rank"`
+	Strategy    string             `json:"strategy"`
+	Fingerprint string             `json:"fingerprint" gorm:"index"`
+	Params      map[string]float64 `json:"params" gorm:"serializer:json"`
+	Objective   string             `json:"objective"`
+	// Random search draws the same candidates again from it, zero on grids
+	Seed        int64     `json:"seed"`
+	Score       float64   `json:"score"`
+	ProfitUSD   float64   `json:"profitUSD"`
+	Sharpe      float64   `json:"sharpe"`
+	MaxDrawdown float64   `json:"maxDrawdown"`
+	Trades      int       `json:"trades"`
+	Symbols     string    `json:"symbols"`
+	Timeframe   string    `json:"timeframe"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type Percentiles struct {
+	P5  float64 `json:"p5"`
+	P25 float64 `json:"p25"`
+	P50 float64 `json:"p50"`
+	P75 float64 `json:"p75"`
+	P95 float64 `json:"p95"`
+}
+
+// MonteCarloResult holds the distributions of many resampled runs of a
+// backtest's trades. RunID is the stored run of the backtest.
+type MonteCarloResult struct {
+	ID              uint        `json:"id" gorm:"primary_key;auto_increment"`
+	RunID           uint        `json:"runID" gorm:"index"`
+	Method          string      `json:"method"`
+	Runs            int         `json:"runs"`
+	Seed            int64       `json:"seed"`
+	Trades          int         `json:"trades"`
+	Strategies      string      `json:"strategies"`
+	Symbols         string      `json:"symbols"`
+	FinalEquity     Percentiles `json:"finalEquity" gorm:"embedded;embeddedPrefix:final_equity_"`
+	MaxDrawdown     Percentiles `json:"maxDrawdown" gorm:"embedded;embeddedPrefix:max_drawdown_"`
+	RuinProbability float64     `json:"ruinProbability"`
+	Timeframe       string      `json:"timeframe"`
+	Start           time.Time   `json:"start"`
+	End             time.Time   `json:"end"`
+	CreatedAt       time.Time   `json:"createdAt"`
+}
+
+// BacktestRun records how a backtest was run, so that its orders and
+// analyses can be told apart from other runs over the same period. Orders
+// and analyses of live trading have a RunID of 0.
+type BacktestRun struct {
+	ID         uint   `json:"id" gorm:"primary_key;auto_increment"`
+	Symbols    string `json:"symbols"`
+	Strategies string `json:"strategies"`
+	// Params every strategy ran with, overrides included
+	Params       map[string]map[string]float64 `json:"params" gorm:"serializer:json"`
+	Fingerprints map[string]string             `json:"fingerprints" gorm:"serializer:json"`
+	Timeframes   map[string]string             `json:"timeframes" gorm:"serializer:json"`
+	// VCS revision of the build, empty when it's unknown
+	Revision    string        `json:"revision"`
+	Fees        string        `json:"fees"`
+	Slippage    string        `json:"slippage"`
+	GapPolicy   string        `json:"gapPolicy"`
+	StopLoss    float64       `json:"stopLoss"`
+	TakeProfit  float64       `json:"takeProfit"`
+	LimitOffset float64       `json:"limitOffset"`
+	Path        string        `json:"path"`
+	Trades      int           `json:"trades"`
+	ProfitUSD   float64       `json:"profitUSD"`
+	Duration    time.Duration `json:"duration"`
+	Timeframe   string        `json:"timeframe"`
+	Start       time.Time     `json:"start"`
+	End         time.Time     `json:"end"`
+	CreatedAt   time.Time     `json:"createdAt"`
+}
+
 type StorageClient interface {
 	AutoMigrateAll()
 	AutoMigrateOrders()
 	AutoMigrateSettings()
 	AutoMigrateAnalyses()
+	AutoMigrateOptimizationResults()
+	AutoMigrateMonteCarloResults()
+	AutoMigrateBacktestRuns()
 	DropAll()
 	GetAllSettings() (map[string]Setting, error)
 	GetSetting(name string) (Setting, error)
@@ -51,4 +181,13 @@ type StorageClient interface {
 	GetLastOrder(strategy, symbol string) (*Order, error)
 	StoreOrder(order *Order) error
 	StoreAnalyses(analyses map[string]Analysis) error
+	GetAnalysesByFingerprint(fingerprint string) ([]Analysis, error)
+	StoreOptimizationResults(results []OptimizationResult) error
+	GetOptimizationResults(batch string) ([]OptimizationResult, error)
+	StoreMonteCarloResult(result *MonteCarloResult) error
+	StoreBacktestRun(run *BacktestRun, orders []Order, analyses map[string]Analysis) error
+	GetBacktestRuns() ([]BacktestRun, error)
+	GetBacktestRun(id uint) (*BacktestRun, error)
+	GetOrdersByRun(id uint) ([]Order, error)
+	GetAnalysesByRun(id uint) ([]Analysis, error)
 }
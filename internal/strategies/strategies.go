@@ -1,38 +1,338 @@
 package strategies
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
 	"github.com/sdcoffey/techan"
+	"github.com/ws396/autobinance/internal/datasets"
 	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/signals"
+	"github.com/ws396/autobinance/internal/storage"
 )
 
 var (
 	StrategiesInfo = map[string]StrategyInfo{}
 )
 
+// StrategyInfo describes either a Go handler or, if External is set, a strategy
+// whose decisions come from the signal queue of the bundle.
 type StrategyInfo struct {
-	Handler  func(*techan.TimeSeries) (string, map[string]string)
-	Datakeys []string
+	Handler Handler
+	// Set instead of Handler by strategies that look at several timeframes
+	BundleHandler BundleHandler
+	Datakeys      []string
+	External      bool
+	// Version should be bumped whenever the handler logic changes
+	Version string
+	Params  Params
+	// Timeframe the series is built at, resampled from the base timeframe.
+	// Empty runs the strategy on the base timeframe
+	Timeframe string
+	// Higher timeframes handed to the BundleHandler next to the main one
+	Timeframes []string
+	// Bounds of the params, they're taken as they are if not set
+	ParamsCheck ParamsCheck
+	// Datasets handed to the strategy next to the klines, see SetDatasets
+	Datasets []datasets.Dataset
+}
+
+// Params are the tunable numbers of a strategy, such as indicator windows.
+type Params map[string]float64
+
+// ParamsCheck returns an error if the params can't run on windows of the
+// size, ex. a lookback that goes past the candles the strategy gets.
+type ParamsCheck func(params Params, windowSize int) error
+
+// Handler returns a decision, the indicator values worth logging and a trace of
+// the rules that were checked to arrive at the decision.
+type Handler func(*techan.TimeSeries, Params) (string, map[string]string, storage.Trace)
+
+// BundleHandler is a Handler that gets the series of every declared timeframe.
+type BundleHandler func(Bundle, Params) (string, map[string]string, storage.Trace)
+
+// Bundle holds the series of a symbol at every timeframe a strategy declared,
+// cut at the same moment. Higher timeframes only hold closed candles, so
+// they never show what happens after the last candle of Series. They may
+// hold fewer candles than Series while warming up.
+type Bundle struct {
+	// Main timeframe, which the strategy is evaluated on every close of
+	Series *techan.TimeSeries
+	Higher map[string]*techan.TimeSeries
+	// Pending signals of external strategies, which simulations don't have
+	Signals *signals.Queue
+	// Signals are checked for expiry against it, real time if not set
+	Now time.Time
+	// Aggregated trades inside the last candle of Series and the funding
+	// rates settled by its end, for strategies that declared them. Only
+	// backtests have them, from the downloaded archives
+	Trades  []datasets.AggTrade
+	Funding []datasets.FundingRate
+}
+
+// Evaluation is the outcome of a single strategy run. Size is only set by
+// external strategies, zero means default sizing.
+type Evaluation struct {
+	Decision    string
+	Indicators  map[string]string
+	Trace       storage.Trace
+	Size        float64
+	Fingerprint string
 }
 
 // Add error handling?
-func AddStrategyInfo(strategy string, handler func(*techan.TimeSeries) (string, map[string]string), datakeys []string) {
-	datakeys = append(datakeys, "Current price",
+func AddStrategyInfo(strategy, version string, params Params, handler Handler, datakeys []string) {
+	StrategiesInfo[strategy] = StrategyInfo{
+		Handler:  handler,
+		Datakeys: withCommonDatakeys(datakeys),
+		Version:  version,
+		Params:   params,
+	}
+}
+
+// AddBundleStrategyInfo registers a strategy that also looks at the higher
+// timeframes, ex. to confirm an entry with the trend of 1h candles.
+func AddBundleStrategyInfo(strategy, version string, params Params, timeframes []string, handler BundleHandler, datakeys []string) {
+	StrategiesInfo[strategy] = StrategyInfo{
+		BundleHandler: handler,
+		Datakeys:      withCommonDatakeys(datakeys),
+		Version:       version,
+		Params:        params,
+		Timeframes:    timeframes,
+	}
+}
+
+func AddExternalStrategyInfo(strategy, version string) {
+	StrategiesInfo[strategy] = StrategyInfo{
+		External: true,
+		Version:  version,
+		Datakeys: withCommonDatakeys([]string{
+			"Signal ID",
+			"Signal size",
+			"Signal expires at",
+		}),
+	}
+}
+
+// SetTimeframe makes the strategy run on candles of the timeframe instead of the base ones.
+func SetTimeframe(strategy, timeframe string) error {
+	info, ok := StrategiesInfo[strategy]
+	if !ok {
+		return globals.ErrWrongStrategyName
+	}
+	if _, ok := globals.Durations[timeframe]; !ok {
+		return globals.ErrWrongTimeframe
+	}
+
+	info.Timeframe = timeframe
+	StrategiesInfo[strategy] = info
+
+	return nil
+}
+
+// SetParamsCheck declares the bounds of the params of the strategy, which
+// tuned params are checked against before running.
+func SetParamsCheck(strategy string, check ParamsCheck) error {
+	info, ok := StrategiesInfo[strategy]
+	if !ok {
+		return globals.ErrWrongStrategyName
+	}
+
+	info.ParamsCheck = check
+	StrategiesInfo[strategy] = info
+
+	return nil
+}
+
+// SetDatasets hands the strategy the data of the datasets in backtests: the
+// trades of an aggTrades dataset, of either market, and funding rates.
+func SetDatasets(strategy string, ds ...datasets.Dataset) error {
+	info, ok := StrategiesInfo[strategy]
+	if !ok {
+		return globals.ErrWrongStrategyName
+	}
+
+	trades := 0
+	for _, d := range ds {
+		switch d {
+		case datasets.SpotAggTrades, datasets.FuturesAggTrades:
+			trades++
+		case datasets.FundingRates:
+		default:
+			return globals.ErrWrongDataset
+		}
+	}
+	if trades > 1 {
+		return globals.ErrWrongDataset
+	}
+
+	info.Datasets = ds
+	StrategiesInfo[strategy] = info
+
+	return nil
+}
+
+// AggTrades is the aggTrades dataset the strategy reads, if any.
+func (si StrategyInfo) AggTrades() (datasets.Dataset, bool) {
+	for _, d := range si.Datasets {
+		if d != datasets.FundingRates {
+			return d, true
+		}
+	}
+
+	return datasets.Dataset{}, false
+}
+
+// Funding tells whether the strategy reads funding rates.
+func (si StrategyInfo) Funding() bool {
+	for _, d := range si.Datasets {
+		if d == datasets.FundingRates {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withCommonDatakeys(datakeys []string) []string {
+	// Copied so that the slice of the caller is never written to
+	return append(append([]string{}, datakeys...), "Current price",
 		"Created at",
 		"Symbol",
 		"Decision",
 		"Strategy",
 		"Successful",
+		"Trace",
 	)
+}
+
+// Overrides replace the registered params of the strategy, nil runs it with defaults.
+func RunStrategy(strategy, symbol string, bundle Bundle, overrides Params) (Evaluation, error) {
+	info, ok := StrategiesInfo[strategy]
+	if !ok {
+		return Evaluation{}, globals.ErrWrongStrategyName
+	}
+	info = info.WithParams(overrides)
+
+	var eval Evaluation
+	switch {
+	case info.External:
+		if bundle.Signals == nil {
+			return Evaluation{}, globals.ErrNoSignalSource
+		}
+		now := bundle.Now
+		if now.IsZero() {
+			now = time.Now()
+		}
+		eval = runExternal(bundle.Signals, symbol, now)
+	case info.BundleHandler != nil:
+		decision, indicators, trace := info.BundleHandler(bundle, info.Params)
+		eval = Evaluation{
+			Decision:   decision,
+			Indicators: indicators,
+			Trace:      trace,
+		}
+	default:
+		decision, indicators, trace := info.Handler(bundle.Series, info.Params)
+		eval = Evaluation{
+			Decision:   decision,
+			Indicators: indicators,
+			Trace:      trace,
+		}
+	}
 
-	StrategiesInfo[strategy] = StrategyInfo{handler, datakeys}
+	eval.Fingerprint = info.Fingerprint()
+
+	return eval, nil
 }
 
-func RunStrategy(strategy string, series *techan.TimeSeries) (string, map[string]string, error) {
-	if _, ok := StrategiesInfo[strategy]; !ok {
-		return "", nil, globals.ErrWrongStrategyName
+func (si StrategyInfo) WithParams(overrides Params) StrategyInfo {
+	if len(overrides) == 0 {
+		return si
 	}
 
-	decision, indicators := StrategiesInfo[strategy].Handler(series)
+	params := Params{}
+	for k, v := range si.Params {
+		params[k] = v
+	}
+	for k, v := range overrides {
+		params[k] = v
+	}
+	si.Params = params
 
-	return decision, indicators, nil
+	return si
+}
+
+// CheckParams checks the params against the bounds of the strategy.
+func (si StrategyInfo) CheckParams(windowSize int) error {
+	if si.ParamsCheck == nil {
+		return nil
+	}
+
+	return si.ParamsCheck(si.Params, windowSize)
+}
+
+// Fingerprint ties orders and analyses to the exact logic that produced them,
+// ex. "1.0.0-3f2a9c1b7d4e".
+func (si StrategyInfo) Fingerprint() string {
+	params := si.Params
+	if params == nil {
+		params = Params{}
+	}
+
+	// Map keys are sorted by encoding/json, so the hash is stable
+	j, _ := json.Marshal(params)
+	sum := sha256.Sum256(j)
+
+	return si.Version + "-" + hex.EncodeToString(sum[:6])
+}
+
+func Fingerprint(strategy string) (string, error) {
+	info, ok := StrategiesInfo[strategy]
+	if !ok {
+		return "", globals.ErrWrongStrategyName
+	}
+
+	return info.Fingerprint(), nil
+}
+
+func runExternal(source *signals.Queue, symbol string, now time.Time) Evaluation {
+	s, ok := source.Take(symbol, now)
+	if !ok {
+		return Evaluation{
+			Decision:   globals.Hold,
+			Indicators: map[string]string{},
+			Trace: storage.Trace{
+				storage.NewRuleTrace("signal", storage.Condition{
+					Name:   "pending signal",
+					Inputs: map[string]string{"Symbol": symbol},
+					Result: false,
+				}),
+			},
+		}
+	}
+
+	return Evaluation{
+		Decision: s.Decision,
+		Indicators: map[string]string{
+			"Signal ID":         s.ID,
+			"Signal size":       strconv.FormatFloat(s.Size, 'f', -1, 64),
+			"Signal expires at": s.ExpiresAt.Format("02-01-2006 15:04:05"),
+		},
+		Trace: storage.Trace{
+			storage.NewRuleTrace("signal", storage.Condition{
+				Name: "pending signal",
+				Inputs: map[string]string{
+					"Symbol":   symbol,
+					"ID":       s.ID,
+					"Decision": s.Decision,
+				},
+				Result: true,
+			}),
+		},
+		Size: s.Size,
+	}
 }
@@ -1,69 +1,109 @@
 package strategies
 
 import (
+	"fmt"
+
 	"github.com/sdcoffey/techan"
 	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
 )
 
 func init() {
-	AddStrategyInfo("example", StrategyExample, []string{
+	AddStrategyInfo("example", "1.0.0", Params{
+		"window":   10,
+		"lookback": 2,
+	}, StrategyExample, []string{
 		"SMA0",
 		"SMA1",
 	})
+	SetParamsCheck("example", checkExampleParams)
+}
+
+// checkExampleParams keeps the SMA and the lookback inside the window.
+func checkExampleParams(params Params, windowSize int) error {
+	if window := params["window"]; window < 1 || window > float64(windowSize) {
+		return fmt.Errorf("%w: window has to be from 1 to %d", globals.ErrWrongParamValue, windowSize)
+	}
+	if lookback := params["lookback"]; lookback < 0 || lookback >= float64(windowSize) {
+		return fmt.Errorf("%w: lookback has to be from 0 to %d", globals.ErrWrongParamValue, windowSize-1)
+	}
+
+	return nil
 }
 
 type buyRuleExample struct {
-	SMA10  techan.Indicator
-	series *techan.TimeSeries
+	SMA      techan.Indicator
+	lookback int
+	series   *techan.TimeSeries
 }
 
-func (r buyRuleExample) IsSatisfied() bool {
+func (r buyRuleExample) Evaluate() storage.RuleTrace {
 	l := len(r.series.Candles)
 
-	a0 := r.SMA10.Calculate(l - 3)
-	a1 := r.SMA10.Calculate(l - 1)
-	if !(r.series.LastCandle().ClosePrice.GT(a1) && a1.GT(a0)) {
-		return false
-	}
+	price := r.series.LastCandle().ClosePrice
+	a0 := r.SMA.Calculate(l - 1 - r.lookback)
+	a1 := r.SMA.Calculate(l - 1)
 
-	return true
+	return storage.NewRuleTrace("buy",
+		storage.Condition{
+			Name:   "price above SMA1",
+			Inputs: map[string]string{"Price": price.String(), "SMA1": a1.String()},
+			Result: price.GT(a1),
+		},
+		storage.Condition{
+			Name:   "SMA rising",
+			Inputs: map[string]string{"SMA0": a0.String(), "SMA1": a1.String()},
+			Result: a1.GT(a0),
+		},
+	)
 }
 
 type sellRuleExample struct {
-	SMA10  techan.Indicator
-	series *techan.TimeSeries
+	SMA      techan.Indicator
+	lookback int
+	series   *techan.TimeSeries
 }
 
-func (r sellRuleExample) IsSatisfied() bool {
+func (r sellRuleExample) Evaluate() storage.RuleTrace {
 	l := len(r.series.Candles)
 
-	a0 := r.SMA10.Calculate(l - 3)
-	a1 := r.SMA10.Calculate(l - 1)
-	if !(r.series.LastCandle().ClosePrice.LT(a1) && a1.LT(a0)) {
-		return false
-	}
+	price := r.series.LastCandle().ClosePrice
+	a0 := r.SMA.Calculate(l - 1 - r.lookback)
+	a1 := r.SMA.Calculate(l - 1)
 
-	return true
+	return storage.NewRuleTrace("sell",
+		storage.Condition{
+			Name:   "price below SMA1",
+			Inputs: map[string]string{"Price": price.String(), "SMA1": a1.String()},
+			Result: price.LT(a1),
+		},
+		storage.Condition{
+			Name:   "SMA falling",
+			Inputs: map[string]string{"SMA0": a0.String(), "SMA1": a1.String()},
+			Result: a1.LT(a0),
+		},
+	)
 }
 
-func StrategyExample(series *techan.TimeSeries) (string, map[string]string) {
+func StrategyExample(series *techan.TimeSeries, params Params) (string, map[string]string, storage.Trace) {
+	lookback := int(params["lookback"])
 	closePrices := techan.NewClosePriceIndicator(series)
-	SMA10 := techan.NewSimpleMovingAverage(closePrices, 10)
+	SMA := techan.NewSimpleMovingAverage(closePrices, int(params["window"]))
 
-	buyRule := buyRuleExample{SMA10, series}
-	sellRule := sellRuleExample{SMA10, series}
+	buyTrace := buyRuleExample{SMA, lookback, series}.Evaluate()
+	sellTrace := sellRuleExample{SMA, lookback, series}.Evaluate()
 
 	result := globals.Hold
-	if buyRule.IsSatisfied() {
+	if buyTrace.Result {
 		result = globals.Buy
-	} else if sellRule.IsSatisfied() {
+	} else if sellTrace.Result {
 		result = globals.Sell
 	}
 
 	indicators := map[string]string{
-		"SMA0": SMA10.Calculate(len(series.Candles) - 3).String(),
-		"SMA1": SMA10.Calculate(len(series.Candles) - 1).String(),
+		"SMA0": SMA.Calculate(len(series.Candles) - 1 - lookback).String(),
+		"SMA1": SMA.Calculate(len(series.Candles) - 1).String(),
 	}
 
-	return result, indicators
+	return result, indicators, storage.Trace{buyTrace, sellTrace}
 }
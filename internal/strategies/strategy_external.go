@@ -0,0 +1,11 @@
+package strategies
+
+import "github.com/ws396/autobinance/internal/signals"
+
+// Signals feeds the "external" strategy of live sessions. Sources are started
+// by the CLI.
+var Signals = signals.NewQueue()
+
+func init() {
+	AddExternalStrategyInfo("external", "1.0.0")
+}
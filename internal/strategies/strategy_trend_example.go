@@ -0,0 +1,81 @@
+package strategies
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/sdcoffey/techan"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/storage"
+)
+
+const trendTimeframe = "15m"
+
+func init() {
+	AddBundleStrategyInfo("example_trend", "1.0.0", Params{
+		"window":       10,
+		"lookback":     2,
+		"trend_window": 5,
+	}, []string{trendTimeframe}, StrategyTrendExample, []string{
+		"SMA0",
+		"SMA1",
+		"Trend SMA",
+	})
+	SetParamsCheck("example_trend", func(params Params, windowSize int) error {
+		if params["trend_window"] < 1 {
+			return fmt.Errorf("%w: trend_window has to be at least 1", globals.ErrWrongParamValue)
+		}
+
+		return checkExampleParams(params, windowSize)
+	})
+}
+
+// trendRuleExample holds while the higher timeframe closes above its SMA.
+type trendRuleExample struct {
+	series *techan.TimeSeries
+	window int
+}
+
+func (r trendRuleExample) Evaluate() storage.RuleTrace {
+	var candles int
+	if r.series != nil {
+		candles = len(r.series.Candles)
+	}
+	if candles < r.window {
+		return storage.NewRuleTrace("trend", storage.Condition{
+			Name:   "enough " + trendTimeframe + " candles",
+			Inputs: map[string]string{"Candles": strconv.Itoa(candles)},
+			Result: false,
+		})
+	}
+
+	price := r.series.LastCandle().ClosePrice
+	sma := techan.NewSimpleMovingAverage(techan.NewClosePriceIndicator(r.series), r.window).Calculate(len(r.series.Candles) - 1)
+
+	return storage.NewRuleTrace("trend", storage.Condition{
+		Name:   trendTimeframe + " price above trend SMA",
+		Inputs: map[string]string{"Price": price.String(), "Trend SMA": sma.String()},
+		Result: price.GT(sma),
+	})
+}
+
+// StrategyTrendExample is the example strategy, except it only buys while
+// the 15m candles trend up.
+func StrategyTrendExample(bundle Bundle, params Params) (string, map[string]string, storage.Trace) {
+	_, indicators, trace := StrategyExample(bundle.Series, params)
+	buyTrace, sellTrace := trace[0], trace[1]
+	trendTrace := trendRuleExample{bundle.Higher[trendTimeframe], int(params["trend_window"])}.Evaluate()
+
+	result := globals.Hold
+	if buyTrace.Result && trendTrace.Result {
+		result = globals.Buy
+	} else if sellTrace.Result {
+		result = globals.Sell
+	}
+
+	if c := trendTrace.Conditions[0]; c.Inputs["Trend SMA"] != "" {
+		indicators["Trend SMA"] = c.Inputs["Trend SMA"]
+	}
+
+	return result, indicators, storage.Trace{buyTrace, trendTrace, sellTrace}
+}
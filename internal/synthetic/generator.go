@@ -0,0 +1,251 @@
+package synthetic
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+)
+
+const (
+	year = 365 * 24 * time.Hour
+	// Price steps inside every candle, which its high and low come from
+	substeps = 4
+	// Share of a flash crash that's won back while recovering
+	rebound = 0.7
+)
+
+type Config struct {
+	Timeframe string
+	Start     time.Time
+	// Amount of candles, the ones left out as gaps included
+	Candles int
+	Seed    int64
+	Price   float64
+	// Average volume of a candle
+	Volume float64
+	// Yearly drift and volatility of the log price, unless there are regimes
+	Drift      float64
+	Volatility float64
+	Regimes    []Regime
+	Jumps      Jumps
+	Crashes    Crashes
+	Gaps       Gaps
+	Spikes     Spikes
+}
+
+// Regime is a market state with its own drift and volatility. The market
+// stays in one for Duration on average before switching to another one.
+type Regime struct {
+	Name       string
+	Drift      float64
+	Volatility float64
+	Duration   time.Duration
+}
+
+// Jumps happen Rate times a year, with log sizes around Mean.
+type Jumps struct {
+	Rate   float64
+	Mean   float64
+	StdDev float64
+}
+
+// Crashes drop the price by Depth percent inside a single candle, and win
+// most of it back over Recovery candles.
+type Crashes struct {
+	Count    int
+	Depth    float64
+	Recovery int
+}
+
+// Gaps leave Length candles out of the data, while the price moves on.
+type Gaps struct {
+	Count  int
+	Length int
+}
+
+// Spikes multiply the volume of a candle by Factor.
+type Spikes struct {
+	Count  int
+	Factor float64
+}
+
+// Generator produces the candles of a config one at a time, so datasets of
+// any size can be written to the candle store. The same seed gives the same
+// candles.
+type Generator struct {
+	config    Config
+	rand      *rand.Rand
+	step      time.Duration
+	dt        float64
+	i         int
+	price     float64
+	regime    int
+	crashes   map[int]bool
+	gaps      map[int]bool
+	spikes    map[int]bool
+	recovery  int
+	recovered float64
+}
+
+func NewGenerator(config Config) *Generator {
+	if config.Timeframe == "" {
+		config.Timeframe = globals.Timeframe
+	}
+	if config.Price == 0 {
+		config.Price = 100
+	}
+	if config.Volume == 0 {
+		config.Volume = 100
+	}
+	if config.Spikes.Factor == 0 {
+		config.Spikes.Factor = 10
+	}
+
+	step := globals.Durations[config.Timeframe]
+	g := &Generator{
+		config: config,
+		rand:   rand.New(rand.NewSource(config.Seed)),
+		step:   step,
+		dt:     float64(step) / float64(year),
+		price:  config.Price,
+	}
+
+	g.crashes = g.pick(config.Crashes.Count, 1)
+	g.gaps = g.pick(config.Gaps.Count, config.Gaps.Length)
+	g.spikes = g.pick(config.Spikes.Count, 1)
+	if len(config.Regimes) != 0 {
+		g.regime = g.rand.Intn(len(config.Regimes))
+	}
+
+	return g
+}
+
+// Generate returns all of the candles of the config as klines.
+func Generate(config Config) []*binance.Kline {
+	klines, _ := candles.Collect(candles.Klines(NewGenerator(config)))
+	return klines
+}
+
+// pick spreads count events of length candles over the dataset. The first
+// candle is never picked, so there's always a price to start from.
+func (g *Generator) pick(count, length int) map[int]bool {
+	picked := map[int]bool{}
+	if g.config.Candles < 2 {
+		return picked
+	}
+
+	for n := 0; n < count; n++ {
+		start := 1 + g.rand.Intn(g.config.Candles-1)
+		for i := start; i < start+length && i < g.config.Candles; i++ {
+			picked[i] = true
+		}
+	}
+
+	return picked
+}
+
+func (g *Generator) Next() (candles.Candle, error) {
+	for g.i < g.config.Candles {
+		i := g.i
+		g.i++
+
+		c := g.candle(i)
+		if g.gaps[i] {
+			continue
+		}
+
+		return c, nil
+	}
+
+	return candles.Candle{}, io.EOF
+}
+
+func (g *Generator) candle(i int) candles.Candle {
+	drift, volatility := g.config.Drift, g.config.Volatility
+	if regimes := g.config.Regimes; len(regimes) != 0 {
+		r := regimes[g.regime]
+		drift, volatility = r.Drift, r.Volatility
+		if len(regimes) > 1 && g.rand.Float64() < float64(g.step)/float64(r.Duration) {
+			g.regime = (g.regime + 1 + g.rand.Intn(len(regimes)-1)) % len(regimes)
+		}
+	}
+
+	open := g.price
+	high, low := open, open
+	dt := g.dt / substeps
+
+	jumpAt := -1
+	if j := g.config.Jumps; j.Rate > 0 && g.rand.Float64() < j.Rate*g.dt {
+		jumpAt = g.rand.Intn(substeps)
+	}
+	crash := g.crashes[i]
+	if crash && g.config.Crashes.Depth > 0 {
+		drop := -math.Log(1 - g.config.Crashes.Depth/100)
+		g.recovery = g.config.Crashes.Recovery
+		if g.recovery == 0 {
+			g.recovery = 1
+		}
+		g.recovered = drop * rebound / float64(g.recovery)
+	}
+
+	var move float64
+	for s := 0; s < substeps; s++ {
+		r := (drift-volatility*volatility/2)*dt + volatility*math.Sqrt(dt)*g.rand.NormFloat64()
+		if s == jumpAt {
+			r += g.config.Jumps.Mean + g.config.Jumps.StdDev*g.rand.NormFloat64()
+		}
+		if g.recovery > 0 && !crash {
+			r += g.recovered / substeps
+		}
+		// The crash bottoms out mid candle and bounces a little by the close
+		if crash && s == substeps/2 {
+			r -= g.recovered * float64(g.recovery) / rebound
+		}
+		move += math.Abs(r)
+
+		g.price *= math.Exp(r)
+		high = math.Max(high, g.price)
+		low = math.Min(low, g.price)
+	}
+	if g.recovery > 0 && !crash {
+		g.recovery--
+	}
+
+	// Volume follows how much the price moved, on top of some noise
+	volume := g.config.Volume * math.Exp(0.5*g.rand.NormFloat64()-0.125)
+	if volatility > 0 {
+		volume *= (1 + move/(volatility*math.Sqrt(g.dt))) / 2
+	}
+	if g.spikes[i] || crash {
+		volume *= g.config.Spikes.Factor
+	}
+
+	close := round(g.price)
+	openTime := g.config.Start.Add(time.Duration(i) * g.step).UnixMilli()
+	volume = round(volume)
+	takerShare := 0.4 + 0.2*g.rand.Float64()
+
+	return candles.Candle{
+		OpenTime:      openTime,
+		Open:          round(open),
+		High:          round(high),
+		Low:           round(low),
+		Close:         close,
+		Volume:        volume,
+		CloseTime:     openTime + g.step.Milliseconds() - 1,
+		QuoteVolume:   round(volume * close),
+		Trades:        int64(volume) + 1,
+		TakerBuyBase:  round(volume * takerShare),
+		TakerBuyQuote: round(volume * takerShare * close),
+	}
+}
+
+// Binance quotes 8 decimal places.
+func round(v float64) float64 {
+	return math.Round(v*1e8) / 1e8
+}
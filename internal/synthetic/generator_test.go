@@ -0,0 +1,87 @@
+package synthetic_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/backtest"
+	"github.com/ws396/autobinance/internal/synthetic"
+)
+
+func TestGenerator(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	config := synthetic.Scenarios["stress"]
+	config.Start = start
+	config.Candles = 3 * 24 * 60
+	config.Seed = 42
+
+	klines := synthetic.Generate(config)
+	parse := func(s string) float64 {
+		v, _ := strconv.ParseFloat(s, 64)
+		return v
+	}
+
+	t.Run("is reproducible with the same seed", func(t *testing.T) {
+		if !reflect.DeepEqual(klines, synthetic.Generate(config)) {
+			t.Error("expected the same klines for the same seed")
+		}
+
+		other := config
+		other.Seed = 43
+		if reflect.DeepEqual(klines, synthetic.Generate(other)) {
+			t.Error("expected different klines for another seed")
+		}
+	})
+
+	t.Run("produces consistent candles", func(t *testing.T) {
+		for i, k := range klines {
+			open, high, low, close := parse(k.Open), parse(k.High), parse(k.Low), parse(k.Close)
+			if high < open || high < close || low > open || low > close || low <= 0 {
+				t.Fatalf("inconsistent candle %+v", k)
+			}
+			if k.CloseTime != k.OpenTime+time.Minute.Milliseconds()-1 || (i > 0 && k.OpenTime <= klines[i-1].OpenTime) {
+				t.Fatalf("wrong candle times %+v", k)
+			}
+		}
+	})
+
+	t.Run("injects gaps, crashes and volume spikes", func(t *testing.T) {
+		// Gaps may overlap
+		if missing := config.Candles - len(klines); missing == 0 || missing > config.Gaps.Count*config.Gaps.Length {
+			t.Errorf("expected up to %v missing candles, got %v", config.Gaps.Count*config.Gaps.Length, missing)
+		}
+
+		var crashes, spikes int
+		for _, k := range klines {
+			if (parse(k.Open)-parse(k.Low))/parse(k.Open) > config.Crashes.Depth/200 {
+				crashes++
+			}
+			if parse(k.Volume) > config.Volume*config.Spikes.Factor/2 {
+				spikes++
+			}
+		}
+		if crashes == 0 || spikes == 0 {
+			t.Errorf("expected crashes and spikes, got %v and %v", crashes, spikes)
+		}
+	})
+
+	t.Run("feeds backtests", func(t *testing.T) {
+		result, err := backtest.NewEngine(backtest.Config{
+			Start:      start,
+			End:        start.Add(time.Duration(config.Candles) * time.Minute),
+			Symbols:    []string{"SYNTH"},
+			Strategies: []string{"example"},
+			WindowSize: 20,
+		}, map[string][]*binance.Kline{"SYNTH": klines}).Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(result.Orders) == 0 || result.Coverage["SYNTH"].Gaps == 0 {
+			t.Errorf("expected orders and gaps in the backtest, got %v orders and %+v", len(result.Orders), result.Coverage["SYNTH"])
+		}
+	})
+}
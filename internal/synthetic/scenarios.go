@@ -0,0 +1,97 @@
+package synthetic
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/util"
+)
+
+// Scenarios are ready made configs, the period and seed are filled in when
+// they're generated.
+var Scenarios = map[string]Config{
+	"gbm": {
+		Volatility: 0.6,
+	},
+	"jumps": {
+		Volatility: 0.5,
+		Jumps:      Jumps{Rate: 50, Mean: -0.01, StdDev: 0.03},
+	},
+	"regimes": {
+		Regimes: []Regime{
+			{Name: "bull", Drift: 1.5, Volatility: 0.4, Duration: 5 * 24 * time.Hour},
+			{Name: "bear", Drift: -1.5, Volatility: 0.7, Duration: 3 * 24 * time.Hour},
+			{Name: "sideways", Drift: 0, Volatility: 0.2, Duration: 4 * 24 * time.Hour},
+		},
+	},
+	"stress": {
+		Regimes: []Regime{
+			{Name: "calm", Drift: 0, Volatility: 0.3, Duration: 2 * 24 * time.Hour},
+			{Name: "panic", Drift: -3, Volatility: 1.5, Duration: 12 * time.Hour},
+		},
+		Jumps:   Jumps{Rate: 100, Mean: -0.02, StdDev: 0.05},
+		Crashes: Crashes{Count: 3, Depth: 20, Recovery: 60},
+		Gaps:    Gaps{Count: 3, Length: 30},
+		Spikes:  Spikes{Count: 20, Factor: 15},
+	},
+}
+
+// Prefix of the symbols synthetic klines are stored under, so they never
+// take the place of downloaded ones.
+const Prefix = "SYN_"
+
+// Symbol the synthetic klines of a symbol are stored under, ex. SYN_BTCUSDT.
+func Symbol(symbol string) string {
+	if strings.HasPrefix(symbol, Prefix) {
+		return symbol
+	}
+
+	return Prefix + symbol
+}
+
+// StoreScenario generates the klines of a scenario for the symbols and writes
+// them to the candle store under their synthetic symbols, for backtests to
+// select. Input is the period, the scenario and the seed,
+// ex. "01-01-2022 31-03-2022 stress 42".
+func StoreScenario(input string, symbols []string) error {
+	if len(symbols) == 0 {
+		return globals.ErrSymbolsNotFound
+	}
+
+	args := strings.Split(input, " ")
+	if len(args) != 4 {
+		return globals.ErrWrongScenario
+	}
+	start, end, err := util.ExtractTimepoints(strings.Join(args[:2], " "))
+	if err != nil {
+		return err
+	}
+	config, ok := Scenarios[args[2]]
+	if !ok {
+		return globals.ErrWrongScenario
+	}
+	seed, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return globals.ErrWrongScenario
+	}
+
+	// The end date is inclusive, like in downloads
+	config.Timeframe = globals.Timeframe
+	config.Start = start
+	config.Candles = int(end.Add(24*time.Hour).Sub(start) / globals.Durations[config.Timeframe])
+
+	store := candles.DefaultStore()
+	for i, s := range symbols {
+		// Every symbol gets its own path
+		config.Seed = seed + int64(i)
+		err := store.Write(Symbol(s), config.Timeframe, NewGenerator(config))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
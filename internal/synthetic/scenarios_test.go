@@ -0,0 +1,54 @@
+package synthetic_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/ws396/autobinance/internal/candles"
+	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/synthetic"
+)
+
+func TestStoreScenario(t *testing.T) {
+	dir := globals.BacktestDataDir
+	defer func() { globals.BacktestDataDir = dir }()
+	globals.BacktestDataDir = t.TempDir() + "/"
+
+	store := candles.DefaultStore()
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	downloaded := []*binance.Kline{{
+		OpenTime:  start,
+		CloseTime: start + time.Minute.Milliseconds() - 1,
+		Open:      "100",
+		High:      "100",
+		Low:       "100",
+		Close:     "100",
+		Volume:    "1",
+	}}
+	err := store.Write("BTCUSDT", globals.Timeframe, candles.FromSlice(downloaded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := store.Index("BTCUSDT", globals.Timeframe)
+
+	err = synthetic.StoreScenario("01-01-2022 01-01-2022 gbm 42", []string{"BTCUSDT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("leaves downloaded klines alone", func(t *testing.T) {
+		after, _ := store.Index("BTCUSDT", globals.Timeframe)
+		if !reflect.DeepEqual(before, after) {
+			t.Errorf("expected the downloaded klines untouched, got %+v", after)
+		}
+	})
+
+	t.Run("stores under the synthetic symbol", func(t *testing.T) {
+		index, err := store.Index("SYN_BTCUSDT", globals.Timeframe)
+		if err != nil || len(index) != 1 || index[0].Count != 24*60 {
+			t.Errorf("expected a day of synthetic klines, got %+v, %v", index, err)
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package techanext
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// PartialPolicy decides what happens to buckets that aren't fully covered by
+// base candles, like the first one when the data starts mid-bucket, one cut
+// short by missing candles, or the one still forming at the end.
+type PartialPolicy string
+
+const (
+	// DropPartial keeps complete buckets only
+	DropPartial PartialPolicy = "drop"
+	// KeepLast also keeps the bucket still forming at the end, the way
+	// the exchange serves its latest candle
+	KeepLast PartialPolicy = "keep last"
+	// KeepPartial keeps every bucket
+	KeepPartial PartialPolicy = "keep"
+)
+
+// BucketStart is the open time of the candle of the timeframe that t falls
+// into. Buckets are aligned to the Unix epoch, so days start at 00:00 UTC
+// like on Binance.
+func BucketStart(t time.Time, timeframe time.Duration) time.Time {
+	ms := t.UnixMilli()
+	size := timeframe.Milliseconds()
+
+	return time.UnixMilli(ms - ms%size)
+}
+
+// IsMultiple reports whether candles of the timeframe can be built from base candles.
+func IsMultiple(timeframe, base time.Duration) bool {
+	return base > 0 && timeframe >= base && timeframe%base == 0
+}
+
+// Resampler builds candles of a higher timeframe from a stream of base
+// candles. Buckets are only handed out once they're closed, so a bucket is
+// never seen before all of its base candles have been.
+type Resampler struct {
+	timeframe time.Duration
+	partial   PartialPolicy
+	bucket    *bucket
+}
+
+type bucket struct {
+	kline *binance.Kline
+	// Whether the base candles so far follow each other from the start of
+	// the bucket, without any missing in between
+	contiguous bool
+	// Close of the last base candle added
+	end int64
+}
+
+func NewResampler(timeframe time.Duration, partial PartialPolicy) *Resampler {
+	return &Resampler{timeframe: timeframe, partial: partial}
+}
+
+// Add takes the next base candle and returns the buckets it closed: the
+// previous one if the candle started a new bucket before it was complete,
+// and the current one if the candle completed it.
+func (r *Resampler) Add(k *binance.Kline) []*binance.Kline {
+	closed := []*binance.Kline{}
+	start := BucketStart(time.UnixMilli(k.OpenTime), r.timeframe).UnixMilli()
+
+	if r.bucket != nil && r.bucket.kline.OpenTime != start {
+		if r.partial == KeepPartial {
+			closed = append(closed, r.bucket.kline)
+		}
+		r.bucket = nil
+	}
+
+	if r.bucket == nil {
+		r.bucket = &bucket{
+			kline: &binance.Kline{
+				OpenTime:  start,
+				Open:      k.Open,
+				High:      k.High,
+				Low:       k.Low,
+				CloseTime: start + r.timeframe.Milliseconds() - 1,
+			},
+			contiguous: true,
+			// As if a candle closed right before the bucket
+			end: start - 1,
+		}
+	}
+	if k.OpenTime != r.bucket.end+1 {
+		r.bucket.contiguous = false
+	}
+	merge(r.bucket.kline, k)
+	r.bucket.end = k.CloseTime
+
+	if r.bucket.end >= r.bucket.kline.CloseTime {
+		if r.bucket.contiguous || r.partial == KeepPartial {
+			closed = append(closed, r.bucket.kline)
+		}
+		r.bucket = nil
+	}
+
+	return closed
+}
+
+// Forming returns a copy of the bucket that hasn't closed yet, nil if there's none.
+func (r *Resampler) Forming() *binance.Kline {
+	if r.bucket == nil {
+		return nil
+	}
+
+	k := *r.bucket.kline
+	return &k
+}
+
+// Resample builds the candles of the timeframe from base candles in time order.
+func Resample(klines []*binance.Kline, timeframe time.Duration, partial PartialPolicy) []*binance.Kline {
+	r := NewResampler(timeframe, partial)
+	result := []*binance.Kline{}
+	for _, k := range klines {
+		result = append(result, r.Add(k)...)
+	}
+
+	if last := r.Forming(); last != nil && partial != DropPartial {
+		result = append(result, last)
+	}
+
+	return result
+}
+
+// merge adds the base candle to the bucket: the high and low are the
+// extremes, the close is the latest, and the volumes and trades add up.
+func merge(b, k *binance.Kline) {
+	if parse(k.High) > parse(b.High) {
+		b.High = k.High
+	}
+	if parse(k.Low) < parse(b.Low) {
+		b.Low = k.Low
+	}
+	b.Close = k.Close
+	b.Volume = add(b.Volume, k.Volume)
+	b.QuoteAssetVolume = add(b.QuoteAssetVolume, k.QuoteAssetVolume)
+	b.TakerBuyBaseAssetVolume = add(b.TakerBuyBaseAssetVolume, k.TakerBuyBaseAssetVolume)
+	b.TakerBuyQuoteAssetVolume = add(b.TakerBuyQuoteAssetVolume, k.TakerBuyQuoteAssetVolume)
+	b.TradeNum += k.TradeNum
+}
+
+func parse(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
+
+// add keeps the decimal places of the inputs, so sums don't pick up float noise.
+func add(a, b string) string {
+	places := decimals(a)
+	if d := decimals(b); d > places {
+		places = d
+	}
+
+	return strconv.FormatFloat(parse(a)+parse(b), 'f', places, 64)
+}
+
+func decimals(s string) int {
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		return len(s) - i - 1
+	}
+
+	return 0
+}
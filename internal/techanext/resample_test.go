@@ -0,0 +1,76 @@
+package techanext_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws396/autobinance/internal/techanext"
+	"github.com/ws396/autobinance/internal/testutil"
+)
+
+func TestResample(t *testing.T) {
+	// Starts two minutes into a 5m bucket and stops two minutes before the end of another
+	bucket := time.Date(2022, 12, 20, 0, 0, 0, 0, time.UTC)
+	klines := testutil.MockKlines(bucket.Add(2*time.Minute), 10, 11, 12, 13, 15, 14, 12, 16, 17, 18, 19, 20)
+
+	t.Run("aggregates complete buckets", func(t *testing.T) {
+		got := techanext.Resample(klines, 5*time.Minute, techanext.DropPartial)
+
+		assert.Len(t, got, 1)
+		assert.Equal(t, &binance.Kline{
+			OpenTime:                 bucket.Add(5 * time.Minute).UnixMilli(),
+			Open:                     klines[3].Open,
+			High:                     klines[7].High,
+			Low:                      klines[6].Low,
+			Close:                    klines[7].Close,
+			Volume:                   "50",
+			CloseTime:                bucket.Add(10*time.Minute).UnixMilli() - 1,
+			QuoteAssetVolume:         "0",
+			TakerBuyBaseAssetVolume:  "0",
+			TakerBuyQuoteAssetVolume: "0",
+		}, got[0])
+	})
+
+	t.Run("keeps partial buckets by policy", func(t *testing.T) {
+		last := techanext.Resample(klines, 5*time.Minute, techanext.KeepLast)
+		all := techanext.Resample(klines, 5*time.Minute, techanext.KeepPartial)
+
+		assert.Len(t, last, 2)
+		assert.Equal(t, klines[11].Close, last[1].Close)
+		assert.Len(t, all, 3)
+		assert.Equal(t, bucket.UnixMilli(), all[0].OpenTime)
+		assert.Equal(t, "30", all[0].Volume)
+	})
+
+	t.Run("drops buckets cut short by missing candles", func(t *testing.T) {
+		gapped := append(append([]*binance.Kline{}, klines[:7]...), klines[8:]...)
+		got := techanext.Resample(gapped, 5*time.Minute, techanext.DropPartial)
+
+		assert.Len(t, got, 0)
+	})
+
+	t.Run("drops buckets missing a candle in the middle", func(t *testing.T) {
+		gapped := append(append([]*binance.Kline{}, klines[:5]...), klines[6:]...)
+		got := techanext.Resample(gapped, 5*time.Minute, techanext.DropPartial)
+		all := techanext.Resample(gapped, 5*time.Minute, techanext.KeepPartial)
+
+		assert.Len(t, got, 0)
+		assert.Len(t, all, 3)
+		assert.Equal(t, "40", all[1].Volume)
+	})
+
+	t.Run("hands out buckets only once they close", func(t *testing.T) {
+		r := techanext.NewResampler(5*time.Minute, techanext.DropPartial)
+		for i, k := range klines {
+			closed := r.Add(k)
+			if i == 7 {
+				assert.Len(t, closed, 1)
+				continue
+			}
+			assert.Len(t, closed, 0)
+		}
+		assert.Equal(t, klines[11].Close, r.Forming().Close)
+	})
+}
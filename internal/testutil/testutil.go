@@ -3,9 +3,11 @@ package testutil
 import (
 	"fmt"
 	"math"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/adshao/go-binance/v2"
 	"github.com/sdcoffey/big"
 	"github.com/sdcoffey/techan"
 	"github.com/stretchr/testify/assert"
@@ -65,3 +67,38 @@ func MockTimeSeriesFl(values ...float64) *techan.TimeSeries {
 
 	return MockTimeSeries(strVals...)
 }
+
+// MockKlines returns consecutive 1m klines starting at start. Every candle
+// opens at the previous close and spans 1% around the open/close range.
+func MockKlines(start time.Time, closes ...float64) []*binance.Kline {
+	klines := make([]*binance.Kline, len(closes))
+	open := closes[0]
+
+	for i, c := range closes {
+		openTime := start.Add(time.Duration(i) * time.Minute).UnixMilli()
+		high := math.Max(open, c) * 1.005
+		low := math.Min(open, c) * 0.995
+		klines[i] = &binance.Kline{
+			OpenTime:  openTime,
+			Open:      strconv.FormatFloat(open, 'f', -1, 64),
+			High:      strconv.FormatFloat(high, 'f', -1, 64),
+			Low:       strconv.FormatFloat(low, 'f', -1, 64),
+			Close:     strconv.FormatFloat(c, 'f', -1, 64),
+			Volume:    "10",
+			CloseTime: openTime + time.Minute.Milliseconds() - 1,
+		}
+		open = c
+	}
+
+	return klines
+}
+
+// WaveCloses oscillates around 100, which makes trend following strategies trade.
+func WaveCloses(n int, period float64) []float64 {
+	closes := make([]float64, n)
+	for i := range closes {
+		closes[i] = 100 + 10*math.Sin(2*math.Pi*float64(i)/period)
+	}
+
+	return closes
+}
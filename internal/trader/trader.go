@@ -4,15 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
 	"github.com/sdcoffey/big"
 	"github.com/sdcoffey/techan"
 	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/clock"
 	"github.com/ws396/autobinance/internal/globals"
 	"github.com/ws396/autobinance/internal/output"
+	"github.com/ws396/autobinance/internal/signals"
 	"github.com/ws396/autobinance/internal/storage"
 	"github.com/ws396/autobinance/internal/strategies"
 	"github.com/ws396/autobinance/internal/techanext"
@@ -25,6 +30,18 @@ type Trader struct {
 	ExchangeClient binancew.ExchangeClient
 	Settings       map[string]storage.Setting
 	TickerChan     <-chan time.Time
+	// Per strategy overrides of the registered params
+	Params map[string]strategies.Params
+	// Per strategy overrides of the registered timeframes
+	Timeframes map[string]string
+	// Defaults to the real clock, backtests replace it with simulated time
+	Clock clock.Clock
+	// Pending signals of the "external" strategy, it can't run without them
+	Signals *signals.Queue
+	// Also store holds and rejected orders, so their traces can be inspected later
+	RecordEvaluations bool
+	lastEvaluations   map[string]*storage.Order
+	lock              sync.Mutex
 }
 
 func SetupTrader() (*Trader, error) {
@@ -74,10 +91,12 @@ func SetupTrader() (*Trader, error) {
 	}
 
 	return &Trader{
-		StorageClient:  storageClient,
-		ExchangeClient: exchangeClient,
-		Settings:       s,
-		TickerChan:     ticker.C,
+		StorageClient:     storageClient,
+		ExchangeClient:    exchangeClient,
+		Settings:          s,
+		TickerChan:        ticker.C,
+		Signals:           strategies.Signals,
+		RecordEvaluations: os.Getenv("RECORD_EVALUATIONS") == "true",
 	}, nil
 }
 
@@ -101,7 +120,13 @@ func (t *Trader) StartTradingSession(w output.Writer) chan error {
 		t.TradingRunning = true
 		chanSize := len(t.Settings["selected_strategies"].ValueArr) *
 			len(t.Settings["selected_symbols"].ValueArr)
-		ordersChan := make(chan *storage.Order)
+		// Every strategy-symbol sends a result, failed or not, so the
+		// collecting below never waits on one that's gone
+		type result struct {
+			order *storage.Order
+			err   error
+		}
+		results := make(chan result)
 
 		for t.TradingRunning {
 			<-t.TickerChan
@@ -110,39 +135,57 @@ func (t *Trader) StartTradingSession(w output.Writer) chan error {
 				break
 			}
 
+			strategyNames := t.Settings["selected_strategies"].ValueArr
 			for _, symbol := range t.Settings["selected_symbols"].ValueArr {
 				go func(symbol string) {
+					fail := func(err error) {
+						for range strategyNames {
+							results <- result{err: err}
+						}
+					}
+
 					klines, err := t.ExchangeClient.GetKlines(symbol, globals.Timeframe)
 					if err != nil {
-						errChan <- err
+						fail(err)
 						return
 					}
 
-					series := techanext.GetSeries(
-						klines,
-						globals.Durations[globals.Timeframe],
-					)
-					for _, strategy := range t.Settings["selected_strategies"].ValueArr {
-						go func(strategy string) {
-							order, err := t.Trade(strategy, symbol, series)
-							if err != nil {
-								errChan <- err
-								return
-							}
+					// Every strategy's timeframes are resampled from the same base klines
+					cache := map[string]*techan.TimeSeries{}
+					bundles := map[string]strategies.Bundle{}
+					for _, strategy := range strategyNames {
+						bundles[strategy], err = t.liveBundle(strategy, klines, cache)
+						if err != nil {
+							fail(err)
+							return
+						}
+					}
 
-							ordersChan <- order
+					for _, strategy := range strategyNames {
+						bundle := bundles[strategy]
+						go func(strategy string) {
+							order, err := t.TradeBundle(strategy, symbol, bundle)
+							results <- result{order, err}
 						}(strategy)
 					}
 				}(symbol)
 			}
 
 			var orders []*storage.Order
-			for i := 0; i < chanSize; i++ { // Need something different here?
-				data := <-ordersChan
-				if data != nil {
-					orders = append(orders, data)
+			var failed error
+			for i := 0; i < chanSize; i++ {
+				res := <-results
+				if res.err != nil && failed == nil {
+					failed = res.err
+				}
+				if res.order != nil {
+					orders = append(orders, res.order)
 				}
 			}
+			if failed != nil {
+				errChan <- failed
+				continue
+			}
 
 			err := w.WriteToLog(orders)
 			if err != nil {
@@ -169,26 +212,99 @@ func (t *Trader) StopTradingSession() error {
 	return nil
 }
 
+// Timeframe the strategy trades on: the override if there is one, then the
+// registered timeframe, then the base one.
+func (t *Trader) Timeframe(strategy string) string {
+	if tf := t.Timeframes[strategy]; tf != "" {
+		return tf
+	}
+	if tf := strategies.StrategiesInfo[strategy].Timeframe; tf != "" {
+		return tf
+	}
+
+	return globals.Timeframe
+}
+
+// liveBundle builds the series of the strategy from the base klines. The main
+// series keeps the candle still forming, the way the exchange serves it, while
+// higher timeframes only get closed candles.
+func (t *Trader) liveBundle(strategy string, klines []*binance.Kline, cache map[string]*techan.TimeSeries) (strategies.Bundle, error) {
+	// The forming base candle already has the close time of its minute, it
+	// would close the higher timeframe candle it ends a minute early
+	closed := klines
+	if n := len(klines); n > 0 && klines[n-1].CloseTime >= t.now().UnixMilli() {
+		closed = klines[:n-1]
+	}
+
+	series := func(tf string, partial techanext.PartialPolicy) (*techan.TimeSeries, error) {
+		k := tf + "_" + string(partial)
+		if s, ok := cache[k]; ok {
+			return s, nil
+		}
+
+		d := globals.Durations[tf]
+		if !techanext.IsMultiple(d, globals.Durations[globals.Timeframe]) {
+			return nil, globals.ErrWrongTimeframe
+		}
+		if tf == globals.Timeframe {
+			cache[k] = techanext.GetSeries(klines, d)
+		} else {
+			source := klines
+			if partial == techanext.DropPartial {
+				source = closed
+			}
+			cache[k] = techanext.GetSeries(techanext.Resample(source, d, partial), d)
+		}
+
+		return cache[k], nil
+	}
+
+	var err error
+	bundle := strategies.Bundle{Higher: map[string]*techan.TimeSeries{}}
+	bundle.Series, err = series(t.Timeframe(strategy), techanext.KeepLast)
+	if err != nil {
+		return strategies.Bundle{}, err
+	}
+	for _, tf := range strategies.StrategiesInfo[strategy].Timeframes {
+		bundle.Higher[tf], err = series(tf, techanext.DropPartial)
+		if err != nil {
+			return strategies.Bundle{}, err
+		}
+	}
+
+	return bundle, nil
+}
+
 func (t *Trader) Trade(strategy, symbol string, series *techan.TimeSeries) (*storage.Order, error) {
-	decision, indicators, err := strategies.RunStrategy(strategy, series)
+	return t.TradeBundle(strategy, symbol, strategies.Bundle{Series: series})
+}
+
+// TradeBundle is Trade for strategies that look at several timeframes.
+func (t *Trader) TradeBundle(strategy, symbol string, bundle strategies.Bundle) (*storage.Order, error) {
+	bundle.Signals, bundle.Now = t.Signals, t.now()
+	eval, err := strategies.RunStrategy(strategy, symbol, bundle, t.Params[strategy])
 	if err != nil {
 		return nil, err
 	}
+	decision := eval.Decision
 
 	order := &storage.Order{
-		Strategy:   strategy,
-		Symbol:     symbol,
-		Decision:   decision,
-		Quantity:   0,
-		Price:      0,
-		Indicators: indicators,
-		Timeframe:  globals.Timeframe,
-		Successful: false,
-		CreatedAt:  time.Now(),
+		Strategy:    strategy,
+		Fingerprint: eval.Fingerprint,
+		Symbol:      symbol,
+		Decision:    decision,
+		Quantity:    0,
+		Price:       0,
+		Indicators:  eval.Indicators,
+		Trace:       eval.Trace,
+		Timeframe:   t.Timeframe(strategy),
+		Successful:  false,
+		CreatedAt:   t.now(),
 	}
+	defer t.rememberEvaluation(order)
 
 	if decision == globals.Hold {
-		return order, nil
+		return t.reject(order)
 	}
 
 	foundOrder, err := t.StorageClient.GetLastOrder(strategy, symbol)
@@ -199,20 +315,24 @@ func (t *Trader) Trade(strategy, symbol string, series *techan.TimeSeries) (*sto
 	if foundOrder != nil {
 		if (foundOrder.Decision == globals.Sell || foundOrder.Decision == "") && decision == globals.Sell {
 			//return nil, errors.New("err: no recent buy has been done on this symbol to initiate sell")
-			return order, nil
+			return t.reject(order)
 		} else if foundOrder.Decision == globals.Buy && decision == globals.Buy {
 			//return nil, errors.New("err: this position is already bought")
-			return order, nil
+			return t.reject(order)
 		}
 	} else if decision == globals.Sell {
-		return order, nil
+		return t.reject(order)
 	}
 
-	assetPrice := series.LastCandle().ClosePrice
+	assetPrice := bundle.Series.LastCandle().ClosePrice
 	var quantity big.Decimal
 	switch decision {
 	case globals.Buy:
 		quantity = big.NewDecimal(globals.BuyAmount).Div(assetPrice)
+		// Signals go through the same sizing, they can only buy less
+		if size := big.NewDecimal(eval.Size); eval.Size > 0 && size.LT(quantity) {
+			quantity = size
+		}
 	case globals.Sell:
 		quantity = big.NewDecimal(foundOrder.Quantity)
 	}
@@ -222,7 +342,7 @@ func (t *Trader) Trade(strategy, symbol string, series *techan.TimeSeries) (*sto
 	order.Price = orderPrice.Float()
 	order.Successful = true
 
-	_, err = t.ExchangeClient.CreateOrder(
+	resp, err := t.ExchangeClient.CreateOrder(
 		symbol,
 		quantity.String(),
 		orderPrice.String(),
@@ -231,6 +351,10 @@ func (t *Trader) Trade(strategy, symbol string, series *techan.TimeSeries) (*sto
 	if err != nil {
 		return nil, err
 	}
+	if !applyFills(order, resp) {
+		order.Successful = false
+		return t.reject(order)
+	}
 
 	err = t.StorageClient.StoreOrder(order)
 	if err != nil {
@@ -239,3 +363,115 @@ func (t *Trader) Trade(strategy, symbol string, series *techan.TimeSeries) (*sto
 
 	return order, nil
 }
+
+// applyFills prices the order at what the exchange actually filled. Orders
+// without a response keep the decision price. Slippage is measured from the
+// price of the order on the exchange, the next open on backtests, so the gap
+// from the decision price isn't counted in. Returns false if nothing got filled.
+func applyFills(order *storage.Order, resp *binance.CreateOrderResponse) bool {
+	if resp == nil {
+		return true
+	}
+	if len(resp.Fills) == 0 {
+		return resp.Status == binance.OrderStatusTypeFilled
+	}
+
+	var quantity, cost, fee float64
+	for _, f := range resp.Fills {
+		price, _ := strconv.ParseFloat(f.Price, 64)
+		qty, _ := strconv.ParseFloat(f.Quantity, 64)
+		quantity += qty
+		cost += price * qty
+		fee += quoteCommission(resp.Symbol, f, price)
+	}
+	if quantity == 0 {
+		return false
+	}
+
+	reference := order.Price
+	if p, _ := strconv.ParseFloat(resp.Price, 64); p > 0 {
+		reference = p
+	}
+	price := cost / quantity
+	slippage := (price - reference) * quantity
+	if order.Decision == globals.Sell {
+		slippage = -slippage
+	}
+
+	order.Quantity = quantity
+	order.Price = price
+	order.Fee = fee
+	order.Slippage = slippage
+
+	return true
+}
+
+// quoteCommission turns the commission of a fill into the quote asset.
+// Commissions in the base asset are priced at the fill, ones in other assets,
+// like BNB, have no price here and are left out. Simulated fills don't set
+// the asset, they're charged in the quote one.
+func quoteCommission(symbol string, f *binance.Fill, price float64) float64 {
+	commission, _ := strconv.ParseFloat(f.Commission, 64)
+	switch {
+	case f.CommissionAsset == "" || strings.HasSuffix(symbol, f.CommissionAsset):
+		return commission
+	case strings.HasPrefix(symbol, f.CommissionAsset):
+		return commission * price
+	}
+
+	return 0
+}
+
+func (t *Trader) now() time.Time {
+	if t.Clock == nil {
+		return time.Now()
+	}
+
+	return t.Clock.Now()
+}
+
+// Unsuccessful orders are only stored if evaluations are recorded.
+func (t *Trader) reject(order *storage.Order) (*storage.Order, error) {
+	if !t.RecordEvaluations {
+		return order, nil
+	}
+
+	err := t.StorageClient.StoreOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+func (t *Trader) rememberEvaluation(order *storage.Order) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.lastEvaluations == nil {
+		t.lastEvaluations = map[string]*storage.Order{}
+	}
+
+	o := *order
+	t.lastEvaluations[order.Strategy+"_"+order.Symbol] = &o
+}
+
+// LastEvaluations returns the latest evaluation for every strategy and symbol pair.
+func (t *Trader) LastEvaluations() []*storage.Order {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	orders := []*storage.Order{}
+	for _, o := range t.lastEvaluations {
+		orders = append(orders, o)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].Strategy != orders[j].Strategy {
+			return orders[i].Strategy < orders[j].Strategy
+		}
+		return orders[i].Symbol < orders[j].Symbol
+	})
+
+	return orders
+}
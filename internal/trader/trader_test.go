@@ -1,15 +1,21 @@
 package trader
 
 import (
+	"math"
 	"reflect"
 	"testing"
 	"time"
 
+	"github.com/adshao/go-binance/v2"
 	"github.com/sdcoffey/big"
 	"github.com/sdcoffey/techan"
 	"github.com/ws396/autobinance/internal/binancew"
+	"github.com/ws396/autobinance/internal/clock"
 	"github.com/ws396/autobinance/internal/globals"
+	"github.com/ws396/autobinance/internal/output"
+	"github.com/ws396/autobinance/internal/signals"
 	"github.com/ws396/autobinance/internal/storage"
+	"github.com/ws396/autobinance/internal/strategies"
 )
 
 func TestTrade(t *testing.T) {
@@ -18,6 +24,10 @@ func TestTrade(t *testing.T) {
 	if err != nil {
 		t.Errorf("failed to setup mock trader, %v", err)
 	}
+	fingerprint, err := strategies.Fingerprint("example")
+	if err != nil {
+		t.Errorf("failed to get fingerprint, %v", err)
+	}
 
 	t.Run("successfully orders buy", func(t *testing.T) {
 		got, err := trader.Trade(
@@ -30,16 +40,18 @@ func TestTrade(t *testing.T) {
 		}
 
 		want := &storage.Order{
-			ID:         0,
-			Strategy:   "example",
-			Symbol:     "LTCBTC",
-			Decision:   globals.Buy,
-			Quantity:   5,
-			Price:      10,
-			Indicators: map[string]string{"SMA0": "5", "SMA1": "5.5"},
-			Timeframe:  "1m",
-			Successful: true,
-			CreatedAt:  got.CreatedAt,
+			ID:          0,
+			Strategy:    "example",
+			Fingerprint: fingerprint,
+			Symbol:      "LTCBTC",
+			Decision:    globals.Buy,
+			Quantity:    5,
+			Price:       10,
+			Indicators:  map[string]string{"SMA0": "5", "SMA1": "5.5"},
+			Trace:       exampleTrace("10", "5", "5.5", true, true, false, false),
+			Timeframe:   "1m",
+			Successful:  true,
+			CreatedAt:   got.CreatedAt,
 		}
 
 		if !reflect.DeepEqual(got, want) {
@@ -67,16 +79,18 @@ func TestTrade(t *testing.T) {
 		}
 
 		want := &storage.Order{
-			ID:         0,
-			Strategy:   "example",
-			Symbol:     "LTCBTC",
-			Decision:   globals.Buy,
-			Quantity:   0,
-			Price:      0,
-			Indicators: map[string]string{"SMA0": "5", "SMA1": "6"},
-			Timeframe:  "1m",
-			Successful: false,
-			CreatedAt:  got.CreatedAt,
+			ID:          0,
+			Strategy:    "example",
+			Fingerprint: fingerprint,
+			Symbol:      "LTCBTC",
+			Decision:    globals.Buy,
+			Quantity:    0,
+			Price:       0,
+			Indicators:  map[string]string{"SMA0": "5", "SMA1": "6"},
+			Trace:       exampleTrace("10", "5", "6", true, true, false, false),
+			Timeframe:   "1m",
+			Successful:  false,
+			CreatedAt:   got.CreatedAt,
 		}
 
 		if !reflect.DeepEqual(got, want) {
@@ -104,16 +118,18 @@ func TestTrade(t *testing.T) {
 		}
 
 		want := &storage.Order{
-			ID:         0,
-			Strategy:   "example",
-			Symbol:     "LTCBTC",
-			Decision:   globals.Hold,
-			Quantity:   0,
-			Price:      0,
-			Indicators: map[string]string{"SMA0": "5.5", "SMA1": "6"},
-			Timeframe:  "1m",
-			Successful: false,
-			CreatedAt:  got.CreatedAt,
+			ID:          0,
+			Strategy:    "example",
+			Fingerprint: fingerprint,
+			Symbol:      "LTCBTC",
+			Decision:    globals.Hold,
+			Quantity:    0,
+			Price:       0,
+			Indicators:  map[string]string{"SMA0": "5.5", "SMA1": "6"},
+			Trace:       exampleTrace("5", "5.5", "6", false, true, true, false),
+			Timeframe:   "1m",
+			Successful:  false,
+			CreatedAt:   got.CreatedAt,
 		}
 
 		if !reflect.DeepEqual(got, want) {
@@ -141,16 +157,18 @@ func TestTrade(t *testing.T) {
 		}
 
 		want := &storage.Order{
-			ID:         0,
-			Strategy:   "example",
-			Symbol:     "LTCBTC",
-			Decision:   globals.Sell,
-			Quantity:   5,
-			Price:      1,
-			Indicators: map[string]string{"SMA0": "6", "SMA1": "5.6"},
-			Timeframe:  "1m",
-			Successful: true,
-			CreatedAt:  got.CreatedAt,
+			ID:          0,
+			Strategy:    "example",
+			Fingerprint: fingerprint,
+			Symbol:      "LTCBTC",
+			Decision:    globals.Sell,
+			Quantity:    5,
+			Price:       1,
+			Indicators:  map[string]string{"SMA0": "6", "SMA1": "5.6"},
+			Trace:       exampleTrace("1", "6", "5.6", false, false, true, true),
+			Timeframe:   "1m",
+			Successful:  true,
+			CreatedAt:   got.CreatedAt,
 		}
 
 		if !reflect.DeepEqual(got, want) {
@@ -159,6 +177,274 @@ func TestTrade(t *testing.T) {
 	})
 }
 
+func TestApplyFills(t *testing.T) {
+	fill := func(asset, commission string) *binance.CreateOrderResponse {
+		return &binance.CreateOrderResponse{
+			Symbol: "ETHUSDT",
+			Price:  "100",
+			Status: binance.OrderStatusTypeFilled,
+			Fills: []*binance.Fill{{
+				Price:           "101",
+				Quantity:        "2",
+				Commission:      commission,
+				CommissionAsset: asset,
+			}},
+		}
+	}
+
+	tests := []struct {
+		name string
+		resp *binance.CreateOrderResponse
+		fee  float64
+	}{
+		{"counts commissions in the quote asset", fill("USDT", "0.2"), 0.2},
+		{"prices commissions in the base asset at the fill", fill("ETH", "0.002"), 0.202},
+		{"leaves out commissions in other assets", fill("BNB", "0.001"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &storage.Order{Decision: globals.Buy, Price: 99}
+			if !applyFills(order, tt.resp) {
+				t.Fatal("expected the order to be filled")
+			}
+
+			if math.Abs(order.Fee-tt.fee) > 1e-9 {
+				t.Errorf("expected fee %v, got %v", tt.fee, order.Fee)
+			}
+			// From the price of the order on the exchange, not the decision
+			if math.Abs(order.Slippage-2) > 1e-9 {
+				t.Errorf("expected slippage 2, got %v", order.Slippage)
+			}
+		})
+	}
+}
+
+func TestTradeExternal(t *testing.T) {
+	series := getMockSeries()
+	trader, err := setupMockTrader()
+	if err != nil {
+		t.Errorf("failed to setup mock trader, %v", err)
+	}
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	trader.Clock = clock.NewSimulated(now)
+	trader.Signals = signals.NewQueue()
+
+	t.Run("holds without pending signal", func(t *testing.T) {
+		got, err := trader.Trade("external", "LTCBTC", series)
+		if err != nil {
+			t.Errorf("failed to attempt trade, %v", err)
+		}
+
+		if got.Decision != globals.Hold || got.Successful {
+			t.Errorf("expected unsuccessful hold, got %v", got)
+		}
+	})
+
+	t.Run("orders buy with signal size", func(t *testing.T) {
+		err := trader.Signals.Push(signals.Signal{
+			ID:        "trader-test-1",
+			Symbol:    "LTCBTC",
+			Decision:  globals.Buy,
+			Size:      2,
+			ExpiresAt: now.Add(time.Hour),
+		}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := trader.Trade("external", "LTCBTC", series)
+		if err != nil {
+			t.Errorf("failed to attempt trade, %v", err)
+		}
+
+		if got.Decision != globals.Buy || got.Quantity != 2 || got.Price != 10 || !got.Successful {
+			t.Errorf("created wrong order, got %v", got)
+		}
+		if got.Indicators["Signal ID"] != "trader-test-1" {
+			t.Errorf("expected signal id in indicators, got %v", got.Indicators)
+		}
+	})
+
+	t.Run("expires signals by the clock of the trader", func(t *testing.T) {
+		err := trader.Signals.Push(signals.Signal{
+			ID:        "trader-test-2",
+			Symbol:    "LTCBTC",
+			Decision:  globals.Sell,
+			ExpiresAt: now.Add(time.Minute),
+		}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trader.Clock = clock.NewSimulated(now.Add(time.Hour))
+
+		got, err := trader.Trade("external", "LTCBTC", series)
+		if err != nil {
+			t.Errorf("failed to attempt trade, %v", err)
+		}
+
+		if got.Decision != globals.Hold {
+			t.Errorf("expected expired signal to be dropped, got %v", got)
+		}
+	})
+
+	t.Run("caps signal size at the buy amount", func(t *testing.T) {
+		trader, err := setupMockTrader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		trader.Clock = clock.NewSimulated(now)
+		trader.Signals = signals.NewQueue()
+		err = trader.Signals.Push(signals.Signal{
+			ID:        "trader-test-3",
+			Symbol:    "LTCBTC",
+			Decision:  globals.Buy,
+			Size:      1000,
+			ExpiresAt: now.Add(time.Hour),
+		}, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := trader.Trade("external", "LTCBTC", series)
+		if err != nil {
+			t.Errorf("failed to attempt trade, %v", err)
+		}
+
+		if got.Quantity != globals.BuyAmount/got.Price || !got.Successful {
+			t.Errorf("expected the buy amount to be ordered, got %v", got)
+		}
+	})
+
+	t.Run("fails without a signal queue", func(t *testing.T) {
+		trader.Signals = nil
+
+		_, err := trader.Trade("external", "LTCBTC", series)
+		if err != globals.ErrNoSignalSource {
+			t.Errorf("expected no signal source error, got %v", err)
+		}
+	})
+}
+
+func TestTradeRecordEvaluations(t *testing.T) {
+	series := getMockSeries()
+	trader, err := setupMockTrader()
+	if err != nil {
+		t.Errorf("failed to setup mock trader, %v", err)
+	}
+	trader.RecordEvaluations = true
+
+	t.Run("stores rejected orders without affecting position", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			_, err := trader.Trade("example", "LTCBTC", series)
+			if err != nil {
+				t.Errorf("failed to attempt trade, %v", err)
+			}
+		}
+
+		orders, _ := trader.StorageClient.GetAllOrders()
+		if len(orders) != 2 || !orders[0].Successful || orders[1].Successful {
+			t.Errorf("expected one successful and one rejected order, got %v", orders)
+		}
+		if len(orders[1].Trace) != 2 {
+			t.Errorf("expected rejected order to keep its trace, got %v", orders[1].Trace)
+		}
+
+		last, err := trader.StorageClient.GetLastOrder("example", "LTCBTC")
+		if err != nil || last.Quantity != 5 {
+			t.Errorf("expected last successful order, got %v", last)
+		}
+
+		evaluations := trader.LastEvaluations()
+		if len(evaluations) != 1 || evaluations[0].Successful {
+			t.Errorf("expected latest evaluation to be the rejected one, got %v", evaluations)
+		}
+	})
+}
+
+func TestLiveBundle(t *testing.T) {
+	trader, err := setupMockTrader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	klines := []*binance.Kline{}
+	for i := 0; i < 30; i++ {
+		openTime := start.Add(time.Duration(i) * time.Minute).UnixMilli()
+		klines = append(klines, &binance.Kline{
+			OpenTime:  openTime,
+			CloseTime: openTime + time.Minute.Milliseconds() - 1,
+			Open:      "1", High: "1", Low: "1", Close: "1", Volume: "1",
+		})
+	}
+
+	t.Run("keeps the forming candle out of higher timeframes", func(t *testing.T) {
+		// Inside the last minute of the second 15m candle
+		trader.Clock = clock.NewSimulated(start.Add(29*time.Minute + 30*time.Second))
+
+		bundle, err := trader.liveBundle("example_trend", klines, map[string]*techan.TimeSeries{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(bundle.Higher["15m"].Candles); got != 1 {
+			t.Errorf("expected only the closed 15m candle, got %v", got)
+		}
+		if got := len(bundle.Series.Candles); got != 30 {
+			t.Errorf("expected the main series to keep the forming candle, got %v", got)
+		}
+	})
+
+	t.Run("closes the higher timeframe candle once its last minute is over", func(t *testing.T) {
+		trader.Clock = clock.NewSimulated(start.Add(30 * time.Minute))
+
+		bundle, err := trader.liveBundle("example_trend", klines, map[string]*techan.TimeSeries{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := len(bundle.Higher["15m"].Candles); got != 2 {
+			t.Errorf("expected both 15m candles closed, got %v", got)
+		}
+	})
+}
+
+// klinesExchange serves the same klines on every tick.
+type klinesExchange struct {
+	binancew.ExchangeClient
+	klines []*binance.Kline
+}
+
+func (e *klinesExchange) GetKlines(symbol, timeframe string) ([]*binance.Kline, error) {
+	return e.klines, nil
+}
+
+func TestTradingSession(t *testing.T) {
+	t.Run("reports failed bundles instead of hanging", func(t *testing.T) {
+		trader, err := setupMockTrader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		trader.ExchangeClient = &klinesExchange{trader.ExchangeClient, nil}
+		// Not a multiple of the base timeframe
+		trader.Timeframes = map[string]string{"example": "7m"}
+		ticks := make(chan time.Time)
+		trader.TickerChan = ticks
+
+		errChan := trader.StartTradingSession(&output.StubWriter{})
+		go func() { ticks <- time.Now() }()
+
+		select {
+		case err := <-errChan:
+			if err != globals.ErrWrongTimeframe {
+				t.Errorf("expected wrong timeframe error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("session hung on a failed bundle")
+		}
+		// The session stays parked on the next tick
+	})
+}
+
 func BenchmarkTrade(b *testing.B) {
 	series := getMockSeries()
 	trader, err := setupMockTrader()
@@ -205,6 +491,35 @@ func setupMockTrader() (*Trader, error) {
 	}, nil
 }
 
+func exampleTrace(price, sma0, sma1 string, buy0, buy1, sell0, sell1 bool) storage.Trace {
+	return storage.Trace{
+		storage.NewRuleTrace("buy",
+			storage.Condition{
+				Name:   "price above SMA1",
+				Inputs: map[string]string{"Price": price, "SMA1": sma1},
+				Result: buy0,
+			},
+			storage.Condition{
+				Name:   "SMA rising",
+				Inputs: map[string]string{"SMA0": sma0, "SMA1": sma1},
+				Result: buy1,
+			},
+		),
+		storage.NewRuleTrace("sell",
+			storage.Condition{
+				Name:   "price below SMA1",
+				Inputs: map[string]string{"Price": price, "SMA1": sma1},
+				Result: sell0,
+			},
+			storage.Condition{
+				Name:   "SMA falling",
+				Inputs: map[string]string{"SMA0": sma0, "SMA1": sma1},
+				Result: sell1,
+			},
+		),
+	}
+}
+
 func getMockSeries() *techan.TimeSeries {
 	series := techan.NewTimeSeries()
 
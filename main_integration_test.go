@@ -27,7 +27,7 @@ func (p *mockWriter) WriteToLog(orders []*storage.Order) error {
 // Need to mock the data source too, also this is not an integration test as of now :)
 func TestStartTradingSession(t *testing.T) {
 	t.Run("successfully starts trading session and attempts one trade", func(t *testing.T) {
-		storageClient := &storage.GORMClient{setupMockStorage(t, mockExpect)}
+		storageClient := &storage.GORMClient{DB: setupMockStorage(t, mockExpect)}
 		exchangeClient := binancew.NewExtClientSim("", "")
 		tickerChan := make(chan time.Time)
 		trader := trader.Trader{
@@ -67,23 +67,24 @@ func mockExpect(mock sqlmock.Sqlmock) {
 	mock.ExpectQuery(
 		regexp.QuoteMeta(
 			`SELECT * FROM "orders" 
-			WHERE strategy = $1 AND symbol = $2 
+			WHERE strategy = $1 AND symbol = $2 AND successful = $3 AND run_id = $4 
 			ORDER BY "orders"."id" DESC LIMIT 1`,
 		),
 	).
-		WithArgs("example", "LTCBTC").
+		WithArgs("example", "LTCBTC", true, 0).
 		WillReturnError(gorm.ErrRecordNotFound)
 
 	mock.ExpectBegin()
 	mock.ExpectQuery(
 		regexp.QuoteMeta(
 			`INSERT INTO "orders" 
-			("strategy","symbol","decision","quantity","price","indicators","timeframe","successful","created_at") 
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING "id"`,
+			("strategy","fingerprint","symbol","decision","quantity","price","fee","slippage","ambiguous","indicators","trace","timeframe","successful","run_id","created_at") 
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15) RETURNING "id"`,
 		),
 	).
 		WithArgs(
 			"example",
+			sqlmock.AnyArg(),
 			"LTCBTC",
 			sqlmock.AnyArg(),
 			sqlmock.AnyArg(),
@@ -92,6 +93,11 @@ func mockExpect(mock sqlmock.Sqlmock) {
 			sqlmock.AnyArg(),
 			sqlmock.AnyArg(),
 			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
+			sqlmock.AnyArg(),
 		).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectCommit()